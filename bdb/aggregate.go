@@ -0,0 +1,266 @@
+package bdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// AggResult holds the aggregates of a numeric field, as computed by
+// Aggregate.
+type AggResult struct {
+	// Count is the number of records with a numeric value.
+	Count int
+	// Skipped is the number of records where the field is missing or not
+	// a number.
+	Skipped int
+	// Sum, Avg, Min and Max are computed over the numeric values; they are
+	// zero if Count is.
+	Sum float64
+	Avg float64
+	Min float64
+	Max float64
+}
+
+// Aggregate computes the count, sum, average, minimum and maximum of a
+// numeric field over the records of a collection.
+//
+// Records are streamed with ForEach, so memory use does not grow with the
+// collection. Records where the field is missing or not a number are
+// skipped and counted in AggResult.Skipped.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field, or dotted path into nested objects, e.g. "Address.Pincode".
+//
+// Returns:
+// - AggResult: The aggregates.
+// - error: An error if the collection cannot be read or a record cannot be decoded.
+func (d *Driver) Aggregate(collection, field string) (AggResult, error) {
+	var result AggResult
+
+	if field == "" {
+		return result, fmt.Errorf("missing field")
+	}
+
+	err := d.ForEach(collection, func(id string, raw []byte) error {
+		record, err := d.decodeRecord(collection, raw)
+		if err != nil {
+			return fmt.Errorf("unable to decode record: %s/%s (%w)", collection, id, err)
+		}
+
+		value, ok := util.GetField(record, field)
+		if !ok {
+			result.Skipped++
+			return nil
+		}
+
+		number, ok := util.ToFloat(value)
+		if !ok {
+			result.Skipped++
+			return nil
+		}
+
+		if result.Count == 0 || number < result.Min {
+			result.Min = number
+		}
+		if result.Count == 0 || number > result.Max {
+			result.Max = number
+		}
+		result.Sum += number
+		result.Count++
+
+		return nil
+	})
+	if err != nil {
+		return AggResult{}, err
+	}
+
+	if result.Count > 0 {
+		result.Avg = result.Sum / float64(result.Count)
+	}
+
+	return result, nil
+}
+
+// GroupCount counts the records of a collection per distinct value of a
+// field, e.g. to build facet filters.
+//
+// String values are used as they are as keys, so the string "42" and the
+// number 42 share a key. Numbers, booleans and null are keyed by their JSON
+// encoding, e.g. "42", "true" or "null", and so are objects and arrays.
+// Records where the field is missing are counted under the empty key "".
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field, or dotted path into nested objects, e.g. "Address.City".
+//
+// Returns:
+// - map[string]int: The number of records per value.
+// - error: An error if the collection cannot be read or a record cannot be decoded.
+func (d *Driver) GroupCount(collection, field string) (map[string]int, error) {
+	if field == "" {
+		return nil, fmt.Errorf("missing field")
+	}
+
+	counts := map[string]int{}
+
+	err := d.ForEach(collection, func(id string, raw []byte) error {
+		record, err := d.decodeRecord(collection, raw)
+		if err != nil {
+			return fmt.Errorf("unable to decode record: %s/%s (%w)", collection, id, err)
+		}
+
+		value, ok := util.GetField(record, field)
+		if !ok {
+			counts[""]++
+			return nil
+		}
+
+		key, err := groupKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field '%s' in record %s/%s: %s", field, collection, id, err)
+		}
+		counts[key]++
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// groupKey returns the GroupCount key of a value: strings as they are,
+// other values JSON-encoded.
+//
+// Parameters:
+// - value: The decoded value.
+//
+// Returns:
+// - string: The key.
+// - error: An error if the value cannot be encoded.
+func groupKey(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+// Distinct returns the distinct values a field takes in the records of a
+// collection, e.g. to populate a filter.
+//
+// Records where the field is missing are skipped. Numbers are returned as
+// float64, as by Query. Values are sorted by JSON type first, in the order
+// null, booleans, numbers, strings, arrays and objects, then by value;
+// arrays and objects are ordered by their JSON encoding.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field, or dotted path into nested objects, e.g. "Company.Name".
+//
+// Returns:
+// - []interface{}: The sorted distinct values.
+// - error: An error if the collection cannot be read or a record cannot be decoded.
+func (d *Driver) Distinct(collection, field string) ([]interface{}, error) {
+	if field == "" {
+		return nil, fmt.Errorf("missing field")
+	}
+
+	type distinctValue struct {
+		rank    int
+		encoded string
+		value   interface{}
+	}
+
+	seen := map[string]bool{}
+	values := []distinctValue{}
+
+	err := d.ForEach(collection, func(id string, raw []byte) error {
+		record, err := d.decodeRecord(collection, raw)
+		if err != nil {
+			return fmt.Errorf("unable to decode record: %s/%s (%w)", collection, id, err)
+		}
+
+		value, ok := util.GetField(record, field)
+		if !ok {
+			return nil
+		}
+
+		if value, err = util.Normalize(value); err != nil {
+			return fmt.Errorf("invalid value for field '%s' in record %s/%s: %s", field, collection, id, err)
+		}
+
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field '%s' in record %s/%s: %s", field, collection, id, err)
+		}
+
+		rank := typeRank(value)
+		key := fmt.Sprintf("%d:%s", rank, encoded)
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		values = append(values, distinctValue{rank: rank, encoded: string(encoded), value: value})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+
+		switch av := a.value.(type) {
+		case bool:
+			return !av && b.value.(bool)
+		case float64:
+			return av < b.value.(float64)
+		case string:
+			return av < b.value.(string)
+		}
+
+		return a.encoded < b.encoded
+	})
+
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v.value
+	}
+
+	return result, nil
+}
+
+// typeRank returns the position of the JSON type of a normalized value in
+// the order Distinct sorts by.
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	}
+
+	return 5
+}
@@ -0,0 +1,62 @@
+package bdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeUsers writes users living in the given cities, aged 20, 21 and so on.
+func writeUsers(t testing.TB, db *Driver, cities ...string) {
+	t.Helper()
+
+	for i, city := range cities {
+		user := testUser{
+			Name:    "user",
+			Age:     json.Number(strconv.Itoa(20 + i)),
+			Address: testAddress{City: city},
+		}
+		if _, err := db.Write("users", user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupCountNestedField(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	writeUsers(t, db, "bangalore", "chennai", "bangalore", "")
+	if err := db.WriteWithID("users", "nocity", map[string]interface{}{"Name": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GroupCount("users", "Address.City")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"bangalore": 2, "chennai": 1, "": 2}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("GroupCount = %v, want %v", counts, want)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	writeUsers(t, db, "a", "b", "c")
+	if err := db.WriteWithID("users", "noage", map[string]interface{}{"Age": "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Aggregate("users", "Age")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := AggResult{Count: 3, Skipped: 1, Sum: 63, Avg: 21, Min: 20, Max: 22}
+	if got != want {
+		t.Errorf("Aggregate = %+v, want %+v", got, want)
+	}
+}
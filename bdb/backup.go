@@ -0,0 +1,221 @@
+package bdb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backup writes a gzip-compressed tar archive of every collection to w.
+//
+// Each collection is archived while holding its mutex, so the snapshot of
+// a collection is consistent, although different collections may be
+// captured at slightly different times. Temporary files are skipped.
+// Records are archived as stored, i.e. still compressed or encrypted, and
+// schemas and indexes are archived along with the collections.
+//
+// Parameters:
+// - w: The writer receiving the archive.
+//
+// Returns:
+// - error: An error if a collection cannot be read or w fails.
+func (d *Driver) Backup(w io.Writer) error {
+	collections, err := d.Collections()
+	if err != nil {
+		return err
+	}
+	collections = append(collections, schemaDir, indexDir)
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, collection := range collections {
+		if err := d.backupCollection(tw, collection); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// backupCollection adds the files of a collection to a tar archive while
+// holding the collection mutex.
+//
+// Parameters:
+// - tw: The archive.
+// - collection: The name of the collection.
+//
+// Returns:
+// - error: An error if a file cannot be read or archived.
+func (d *Driver) backupCollection(tw *tar.Writer, collection string) error {
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	return d.walk(filepath.Join(d.dir, collection), func(path string, fi os.FileInfo) error {
+		if strings.HasSuffix(path, ".tmp") || !(fi.Mode().IsRegular() || fi.IsDir()) {
+			return nil
+		}
+
+		name, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("unable to archive file: %s (%s)", path, err)
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		data, err := d.storage.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		_, err = tw.Write(data)
+		return err
+	})
+}
+
+// Restore unpacks an archive created by Backup into the database.
+//
+// Records present in the database but not in the archive are left alone.
+// The archive is applied in a single pass, so if it fails part way, the
+// records restored until then are kept.
+//
+// Parameters:
+// - r: The reader providing the archive.
+// - overwrite: Whether existing records may be replaced.
+//
+// Returns:
+// - error: ErrDuplicateKey if a record already exists and overwrite is
+// false, ErrDryRun with Options.DryRun, or an error if the archive is
+// invalid or cannot be written.
+func (d *Driver) Restore(r io.Reader, overwrite bool) error {
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	if d.dryRun {
+		return ErrDryRun
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid backup archive: %s", err)
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	// Schemas, indexes and collection configs may be replaced by the
+	// archive; load them again.
+	defer func() {
+		d.forgetConfig("")
+
+		d.schemas.mutex.Lock()
+		d.schemas.loaded = nil
+		d.schemas.mutex.Unlock()
+
+		d.indexes.mutex.Lock()
+		d.indexes.loaded = nil
+		d.indexes.mutex.Unlock()
+	}()
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid backup archive: %s", err)
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if filepath.IsAbs(name) || name != filepath.Clean(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("invalid path in backup archive: %s", header.Name)
+		}
+
+		collection := strings.SplitN(name, string(filepath.Separator), 2)[0]
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := d.mkdirAll(filepath.Join(d.dir, name)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if collection == name {
+				return fmt.Errorf("invalid path in backup archive: %s", header.Name)
+			}
+			if err := d.restoreFile(collection, name, tr, overwrite); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// restoreFile writes one archived file while holding the collection mutex.
+//
+// Parameters:
+// - collection: The name of the collection the file belongs to.
+// - name: The path of the file, relative to the database directory.
+// - r: The reader providing the content of the file.
+// - overwrite: Whether an existing file may be replaced.
+//
+// Returns:
+// - error: ErrDuplicateKey if the file exists and overwrite is false, or an
+// error if it cannot be written.
+func (d *Driver) restoreFile(collection, name string, r io.Reader, overwrite bool) error {
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	path := filepath.Join(d.dir, name)
+
+	if _, err := d.storage.Stat(path); err == nil {
+		if !overwrite {
+			return fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to check resource: %s (%s)", path, err)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("invalid backup archive: %s", err)
+	}
+
+	if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	d.watchers.markSelfChange(path)
+
+	if err := d.writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	d.cache.invalidateCollection(collection)
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package bdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	src := newTestDriver(t, nil)
+
+	users := map[string]testUser{
+		"john": {Name: "John", Age: "23", Address: testAddress{City: "bangalore", Pincode: "515671"}},
+		"jane": {Name: "Jane", Age: "31", Address: testAddress{City: "chennai", Pincode: "600001"}},
+	}
+	for id, user := range users {
+		if err := src.WriteWithID("users", id, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.WriteWithID("orders", "1", map[string]interface{}{"total": 10}); err != nil {
+		t.Fatal(err)
+	}
+	stray := src.recordPath("users", "x") + ".tmp"
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := src.Backup(&archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Temporary files are not archived.
+	gr, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(header.Name, ".tmp") {
+			t.Errorf("temporary file archived: %s", header.Name)
+		}
+	}
+
+	dst := newTestDriver(t, nil)
+	if err := dst.Restore(bytes.NewReader(archive.Bytes()), false); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range users {
+		var got testUser
+		if err := dst.Read("users", id, &got); err != nil {
+			t.Fatalf("Read %s: %s", id, err)
+		}
+		if got != want {
+			t.Errorf("%s = %+v, want %+v", id, got, want)
+		}
+	}
+	if n, err := dst.Count("orders"); err != nil || n != 1 {
+		t.Errorf("Count(orders) = %d, %v; want 1", n, err)
+	}
+
+	// Existing records are only replaced with overwrite.
+	if err := dst.Restore(bytes.NewReader(archive.Bytes()), false); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("second Restore: got %v, want ErrDuplicateKey", err)
+	}
+	if err := dst.Restore(bytes.NewReader(archive.Bytes()), true); err != nil {
+		t.Errorf("Restore with overwrite: %s", err)
+	}
+}
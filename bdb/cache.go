@@ -0,0 +1,153 @@
+package bdb
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// cache is an LRU cache of encoded records, keyed by collection and ID.
+//
+// A nil *cache is valid and caches nothing, so callers do not need to check
+// whether caching is enabled.
+type cache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	// epoch is bumped by every invalidation. A record read from disk is
+	// only cached if no invalidation happened since the read started, so a
+	// slow reader cannot store data older than a concurrent write.
+	epoch uint64
+}
+
+type cacheEntry struct {
+	key   string
+	bytes []byte
+}
+
+// newCache creates a cache holding up to size records.
+//
+// Parameters:
+// - size: The maximum number of records, 0 or less to disable caching.
+//
+// Returns:
+// - *cache: The cache, nil if caching is disabled.
+func newCache(size int) *cache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &cache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// cacheKey returns the key of a record in the cache.
+func cacheKey(collection, resource string) string {
+	return collection + "/" + resource
+}
+
+// get returns the cached bytes of a record and marks it as recently used.
+func (c *cache) get(collection, resource string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[cacheKey(collection, resource)]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*cacheEntry).bytes, true
+}
+
+// begin returns the current epoch, to be passed to put once the record
+// has been read.
+func (c *cache) begin() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.epoch
+}
+
+// put caches the bytes of a record read since epoch, evicting the least
+// recently used record if the cache is full.
+func (c *cache) put(collection, resource string, bytes []byte, epoch uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if epoch != c.epoch {
+		return
+	}
+
+	key := cacheKey(collection, resource)
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheEntry).bytes = bytes
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, bytes: bytes})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops a record from the cache.
+func (c *cache) invalidate(collection, resource string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.epoch++
+
+	key := cacheKey(collection, resource)
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// invalidateCollection drops every record of a collection from the cache.
+func (c *cache) invalidateCollection(collection string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.epoch++
+
+	prefix := cacheKey(collection, "")
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package bdb
+
+import "testing"
+
+// BenchmarkRead reads the same record over and over, from the disk and
+// from the cache.
+func BenchmarkRead(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		size int
+	}{{"uncached", 0}, {"cached", 16}} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := newTestDriver(b, &Options{CacheSize: bc.size})
+			if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "30", Address: testAddress{City: "Bangalore"}}); err != nil {
+				b.Fatal(err)
+			}
+
+			var v testUser
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Read("users", "a", &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
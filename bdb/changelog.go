@@ -0,0 +1,168 @@
+package bdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// changeLogName is the name of the change log file in a collection
+// directory.
+const changeLogName = "_changelog"
+
+// ChangeEntry is a mutation recorded in the change log of a collection.
+type ChangeEntry struct {
+	Op         Op        `json:"op"`
+	Collection string    `json:"collection"`
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Document is the new content of the record, nil for a delete.
+	Document map[string]interface{} `json:"document,omitempty"`
+}
+
+// MarshalText encodes the operation by name, e.g. "Create".
+func (op Op) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText decodes an operation encoded by MarshalText.
+func (op *Op) UnmarshalText(text []byte) error {
+	for _, candidate := range []Op{OpCreate, OpUpdate, OpDelete} {
+		if candidate.String() == string(text) {
+			*op = candidate
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown operation: %s", text)
+}
+
+// ReadChangeLog returns the mutations recorded in the change log of a
+// collection, oldest first.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - []ChangeEntry: The entries, empty if nothing was logged.
+// - error: An error if the change log cannot be read or decoded.
+func (d *Driver) ReadChangeLog(collection string) ([]ChangeEntry, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	if collection == "" {
+		return nil, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	path := filepath.Join(d.dir, collection, changeLogName)
+
+	// With LockRecord, writers do not take the collection lock; the log
+	// lock keeps them from appending while the log is read.
+	unlockLog := d.lockChangeLog(collection)
+	data, err := d.storage.ReadFile(path)
+	unlockLog()
+	if os.IsNotExist(err) {
+		return []ChangeEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %s (%s)", path, err)
+	}
+
+	entries := []ChangeEntry{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+
+		if !bytes.HasPrefix(line, []byte("{")) {
+			if line, err = d.decryptChangeLine(line); err != nil {
+				return nil, fmt.Errorf("invalid change log entry on line %d: %s (%s)", lineNo, path, err)
+			}
+		}
+
+		var entry ChangeEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("invalid change log entry on line %d: %s (%s)", lineNo, path, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// logChange appends a mutation to the change log of a collection, if
+// Options.EnableChangeLog is set. The caller must hold the lock of the
+// record, which covers the change log (see lockChangeLog), so the log order
+// matches the order the changes were applied in.
+//
+// Failures are logged rather than returned, as the change itself has
+// already been applied.
+//
+// Parameters:
+// - op: The kind of change.
+// - collection: The name of the collection.
+// - id: The ID of the changed record.
+// - record: The new content of the record, nil for a delete.
+func (d *Driver) logChange(op Op, collection, id string, record map[string]interface{}) {
+	if !d.changeLog {
+		return
+	}
+
+	path := filepath.Join(d.dir, collection, changeLogName)
+
+	line, err := json.Marshal(ChangeEntry{Op: op, Collection: collection, ID: id, Timestamp: time.Now().UTC(), Document: record})
+	if err == nil && d.aead != nil {
+		line, err = d.encryptChangeLine(line)
+	}
+	if err != nil {
+		d.log.Error("Unable to encode change log entry for '%s/%s': %s", collection, id, err)
+		return
+	}
+
+	// A single write per entry keeps each line whole.
+	if err := d.storage.AppendFile(path, append(line, '\n'), d.filePerm()); err != nil {
+		d.log.Error("Unable to append to change log: %s (%s)", path, err)
+	}
+}
+
+// encryptChangeLine encrypts a change log entry into a base64 line, so
+// logged documents are protected like the records themselves.
+func (d *Driver) encryptChangeLine(line []byte) ([]byte, error) {
+	encrypted, err := encrypt(d.aead, line)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(encrypted)))
+	base64.StdEncoding.Encode(out, encrypted)
+
+	return out, nil
+}
+
+// decryptChangeLine decrypts a line produced by encryptChangeLine.
+func (d *Driver) decryptChangeLine(line []byte) ([]byte, error) {
+	encrypted := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+
+	n, err := base64.StdEncoding.Decode(encrypted, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(d.aead, encrypted[:n])
+}
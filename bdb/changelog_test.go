@@ -0,0 +1,102 @@
+package bdb
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestChangeLog(t *testing.T) {
+	db := newTestDriver(t, &Options{EnableChangeLog: true})
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("users", "a", map[string]interface{}{"Name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.ReadChangeLog("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []Op
+	for _, entry := range entries {
+		if entry.Collection != "users" || entry.ID != "a" {
+			t.Errorf("entry for %s/%s, want users/a", entry.Collection, entry.ID)
+		}
+		ops = append(ops, entry.Op)
+	}
+	if want := []Op{OpCreate, OpUpdate, OpDelete}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("ops = %v, want %v", ops, want)
+	}
+	if entries[1].Document["Name"] != "Jane" || entries[2].Document != nil {
+		t.Errorf("documents = %v, %v", entries[1].Document, entries[2].Document)
+	}
+}
+
+func TestChangeLogOrderWithRecordLocks(t *testing.T) {
+	// The validator runs under the lock of the record, right before it is
+	// written, so it sees the records in the order they are applied.
+	var mutex sync.Mutex
+	var applied []string
+	validator := func(record map[string]interface{}) error {
+		mutex.Lock()
+		applied = append(applied, record["_id"].(string))
+		mutex.Unlock()
+		return nil
+	}
+
+	db := newTestDriver(t, &Options{
+		EnableChangeLog: true,
+		LockGranularity: LockRecord,
+		Validators:      map[string]func(map[string]interface{}) error{"users": validator},
+	})
+
+	const writers, writes = 8, 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writes+writes)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := db.WriteWithID("users", fmt.Sprintf("%d-%d", w, i), testUser{Name: "John"}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			if _, err := db.ReadChangeLog("users"); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	entries, err := db.ReadChangeLog("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := make([]string, len(entries))
+	for i, entry := range entries {
+		logged[i] = entry.ID
+	}
+	if !reflect.DeepEqual(logged, applied) {
+		t.Errorf("log order differs from the order the records were written in:\nlogged  %v\napplied %v", logged, applied)
+	}
+}
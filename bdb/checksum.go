@@ -0,0 +1,116 @@
+package bdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// checksumField holds the CRC-32 checksum of a record when
+// Options.Checksum is set.
+const checksumField = "_checksum"
+
+// ErrChecksumMismatch is returned when a record no longer matches the
+// checksum stored with it, e.g. after the file was corrupted on disk.
+var ErrChecksumMismatch = errors.New("record checksum mismatch")
+
+// recordChecksum computes the checksum of a record, leaving out its
+// "_checksum" field.
+//
+// The record is hashed as compact JSON with sorted keys rather than as
+// stored, so the checksum does not depend on the codec, the key order or
+// the indentation of the file.
+//
+// Parameters:
+// - record: The record.
+//
+// Returns:
+// - string: The CRC-32 (IEEE) of the record, as 8 hex digits.
+// - error: An error if the record cannot be encoded.
+func recordChecksum(record map[string]interface{}) (string, error) {
+	body := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		if key != checksumField {
+			body[key] = value
+		}
+	}
+
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(bytes)), nil
+}
+
+// stampChecksum stores the checksum of a record in its "_checksum" field
+// if Options.Checksum is set, and removes a stale one otherwise.
+//
+// Parameters:
+// - record: The record, changed in place.
+//
+// Returns:
+// - error: An error if the record cannot be encoded.
+func (d *Driver) stampChecksum(record map[string]interface{}) error {
+	if !d.checksum {
+		delete(record, checksumField)
+		return nil
+	}
+
+	sum, err := recordChecksum(record)
+	if err != nil {
+		return err
+	}
+	record[checksumField] = sum
+
+	return nil
+}
+
+// verifyChecksum checks a decoded record against its "_checksum" field.
+// Records without one, e.g. written before Options.Checksum was set, pass.
+//
+// Parameters:
+// - record: The decoded record.
+//
+// Returns:
+// - error: ErrChecksumMismatch if the record does not match its checksum.
+func verifyChecksum(record map[string]interface{}) error {
+	stored, ok := record[checksumField]
+	if !ok {
+		return nil
+	}
+
+	sum, err := recordChecksum(record)
+	if err != nil {
+		return err
+	}
+
+	if stored != sum {
+		return fmt.Errorf("%w: stored %v, computed %s", ErrChecksumMismatch, stored, sum)
+	}
+
+	return nil
+}
+
+// checkRecord verifies the checksum of a record read from path when
+// Options.Checksum is set, so corrupt records are not returned as is.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - path: The path the record was read from, for the error.
+// - bytes: The encoded record.
+//
+// Returns:
+// - error: ErrChecksumMismatch if the record is corrupt, or an error if it cannot be decoded.
+func (d *Driver) checkRecord(collection, path string, bytes []byte) error {
+	if !d.checksum {
+		return nil
+	}
+
+	if _, err := d.decodeRecord(collection, bytes); err != nil {
+		return fmt.Errorf("corrupt record: %s (%w)", path, err)
+	}
+
+	return nil
+}
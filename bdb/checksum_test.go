@@ -0,0 +1,97 @@
+package bdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// corruptRecord flips a byte of the value "alice" in a record file.
+func corruptRecord(t *testing.T, db *Driver, collection, id string) {
+	t.Helper()
+
+	path := db.recordPath(collection, id)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := bytes.Index(raw, []byte("alice"))
+	if i < 0 {
+		t.Fatalf("value not found in %s", raw)
+	}
+	raw[i+4] ^= 0x01
+
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	db := newTestDriver(t, &Options{Checksum: true})
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice", "age": 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("users", "a", &record); err != nil {
+		t.Fatalf("Read before corruption: %s", err)
+	}
+	if _, ok := record[checksumField]; !ok {
+		t.Fatalf("record has no %s field: %v", checksumField, record)
+	}
+
+	db.cache.invalidate("users", "a")
+	corruptRecord(t, db, "users", "a")
+
+	checks := map[string]func() error{
+		"Read": func() error {
+			return db.Read("users", "a", &record)
+		},
+		"ReadAll": func() error {
+			_, err := db.ReadAll("users")
+			return err
+		},
+		"ForEach": func() error {
+			return db.ForEach("users", func(string, []byte) error { return nil })
+		},
+		"Update": func() error {
+			return db.Update("users", "a", map[string]interface{}{"age": 31})
+		},
+		"Replace": func() error {
+			return db.Replace("users", "a", map[string]interface{}{"name": "bob"})
+		},
+		"ReadField": func() error {
+			_, err := db.ReadField("users", "a", "name")
+			return err
+		},
+		"ReadProjected": func() error {
+			return db.ReadProjected("users", "a", []string{"name"}, &record)
+		},
+	}
+
+	for name, check := range checks {
+		if err := check(); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("%s: got %v, want ErrChecksumMismatch", name, err)
+		}
+	}
+}
+
+func TestChecksumSkipsRecordsWithoutOne(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = openTestDriver(t, dir, &Options{Checksum: true})
+
+	var record map[string]interface{}
+	if err := db.Read("users", "a", &record); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+}
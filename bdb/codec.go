@@ -0,0 +1,136 @@
+package bdb
+
+import (
+	"encoding/json"
+
+	"github.com/babu10103/bdb/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec serializes records to and from their on-disk format.
+type Codec interface {
+	// Marshal encodes a record.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes a record into v.
+	Unmarshal(data []byte, v interface{}) error
+	// Ext returns the file extension of records, including the dot.
+	Ext() string
+}
+
+// JSONCodec stores records as JSON, tab-indented by default. It is the
+// default codec. Records are read whatever their whitespace, so changing
+// the format only affects records written afterwards.
+type JSONCodec struct {
+	// Indent is the indentation of each level, e.g. "  "; empty for a tab.
+	Indent string
+	// Compact stores records without any whitespace, which makes them
+	// smaller and faster to parse. Indent is then ignored.
+	Compact bool
+}
+
+// Marshal encodes v as indented JSON followed by a newline, or as compact
+// JSON.
+func (c JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	if c.Compact {
+		return json.Marshal(v)
+	}
+
+	bytes, err := json.MarshalIndent(v, "", c.indent())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(bytes, byte('\n')), nil
+}
+
+// indent returns the indentation of the codec.
+func (c JSONCodec) indent() string {
+	if c.Indent == "" {
+		return "\t"
+	}
+
+	return c.Indent
+}
+
+// Unmarshal decodes JSON into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Ext returns ".json".
+func (JSONCodec) Ext() string {
+	return ".json"
+}
+
+// decodeRecord decodes a record into a map. With JSONCodec, numbers are kept
+// as json.Number, so rewriting the record, e.g. on Update, does not round
+// large integers or precise decimals.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - data: The encoded record.
+//
+// With Options.Checksum, the record is verified against its checksum.
+//
+// Returns:
+// - map[string]interface{}: The record.
+// - error: ErrChecksumMismatch if the record is corrupt, or an error if it cannot be decoded.
+func (d *Driver) decodeRecord(collection string, data []byte) (map[string]interface{}, error) {
+	var record map[string]interface{}
+
+	if _, ok := d.codecFor(collection).(JSONCodec); ok {
+		decoded, err := util.DecodeMap(data)
+		if err != nil {
+			return nil, err
+		}
+		record = decoded
+	} else if err := d.codecFor(collection).Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	if d.checksum {
+		if err := verifyChecksum(record); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+// YAMLCodec stores records as YAML, which is convenient for hand-edited
+// documents.
+//
+// Values are converted through JSON on the way in and out, so struct
+// "json" tags are honored exactly as with JSONCodec and "yaml" tags are
+// ignored.
+type YAMLCodec struct{}
+
+// Marshal encodes v as YAML.
+func (YAMLCodec) Marshal(v interface{}) ([]byte, error) {
+	generic, err := util.Normalize(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(generic)
+}
+
+// Unmarshal decodes YAML into v.
+func (YAMLCodec) Unmarshal(data []byte, v interface{}) error {
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, v)
+}
+
+// Ext returns ".yaml".
+func (YAMLCodec) Ext() string {
+	return ".yaml"
+}
@@ -0,0 +1,45 @@
+package bdb
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// BenchmarkCompact parses a record stored compact and indented, reporting
+// the size of its file.
+func BenchmarkCompact(b *testing.B) {
+	user := testUser{
+		Name:    "John",
+		Age:     "30",
+		Contact: "9876543210",
+		Company: "Acme",
+		Address: testAddress{City: "Bangalore", State: "Karnataka", Country: "India", Pincode: "560001"},
+	}
+
+	for _, bc := range []struct {
+		name    string
+		compact bool
+	}{{"indented", false}, {"compact", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			db := newTestDriver(b, &Options{Compact: bc.compact})
+			if err := db.WriteWithID("users", "a", user); err != nil {
+				b.Fatal(err)
+			}
+			data, err := os.ReadFile(db.Path("users", "a"))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var v map[string]interface{}
+				if err := json.Unmarshal(data, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "file-bytes")
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package bdb
+
+import (
+	"fmt"
+)
+
+// Collection is a typed view over a single collection of the database.
+//
+// It delegates to the Driver methods and takes care of unmarshalling
+// records into T.
+type Collection[T any] struct {
+	driver *Driver
+	name   string
+}
+
+// NewCollection creates a typed view over a collection.
+//
+// Parameters:
+// - d: The database driver.
+// - name: The name of the collection.
+//
+// Returns:
+// - *Collection[T]: The typed collection.
+func NewCollection[T any](d *Driver, name string) *Collection[T] {
+	return &Collection[T]{driver: d, name: name}
+}
+
+// Write writes a record to the collection.
+//
+// Parameters:
+// - v: The record to write.
+//
+// Returns:
+// - string: The ID of the new record.
+// - error: An error if the write operation fails.
+func (c *Collection[T]) Write(v T) (string, error) {
+	return c.driver.Write(c.name, v)
+}
+
+// Read retrieves a record from the collection.
+//
+// Parameters:
+// - id: The ID of the record to read.
+//
+// Returns:
+// - T: The record.
+// - error: An error if the read operation fails.
+func (c *Collection[T]) Read(id string) (T, error) {
+	var v T
+	if err := c.driver.Read(c.name, id, &v); err != nil {
+		return v, err
+	}
+
+	return v, nil
+}
+
+// ReadAll retrieves all the records from the collection.
+//
+// Returns:
+// - []T: The list of records.
+// - error: An error if the operation fails.
+func (c *Collection[T]) ReadAll() ([]T, error) {
+	records, err := c.driver.ReadAll(c.name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]T, 0, len(records))
+	for _, record := range records {
+		var v T
+		if err := c.driver.codecFor(c.name).Unmarshal([]byte(record), &v); err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %s", err)
+		}
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+// Update updates a record in the collection.
+//
+// Parameters:
+// - id: The ID of the record to update.
+// - v: The data to update.
+//
+// Returns:
+// - error: An error if the update operation fails.
+func (c *Collection[T]) Update(id string, v T) error {
+	return c.driver.Update(c.name, id, v)
+}
+
+// Delete removes a record from the collection.
+//
+// Parameters:
+// - id: The ID of the record to delete.
+//
+// Returns:
+// - error: An error if the delete operation fails.
+func (c *Collection[T]) Delete(id string) error {
+	return c.driver.Delete(c.name, id)
+}
@@ -0,0 +1,96 @@
+package bdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// compactGracePeriod is how old a temporary file must be before Compact
+// removes it, so files of writes still in progress elsewhere, e.g. in
+// another process, are left alone.
+const compactGracePeriod = time.Minute
+
+// Compact removes the temporary files left in a collection by crashed
+// writes.
+//
+// Only ".tmp" files older than a minute are removed, including those in
+// shard directories. The collection lock
+// is held meanwhile, so writes of this driver cannot be in progress.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - int: The number of files removed.
+// - error: An error if the collection cannot be listed or a file cannot be removed.
+func (d *Driver) Compact(collection string) (removed int, err error) {
+	if err := d.checkWritable(collection); err != nil {
+		return 0, err
+	}
+
+	if collection == "" {
+		return 0, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	if _, err := d.storage.Stat(collectionPath); err != nil {
+		return 0, lookupError("collection", collectionPath, err)
+	}
+
+	cutoff := time.Now().Add(-compactGracePeriod)
+
+	var names []string
+	err = d.walkShards(collectionPath, d.shardDepthFor(collection), func(name string, entry os.DirEntry) {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tmp") {
+			return
+		}
+
+		if info, err := entry.Info(); err == nil && !info.ModTime().After(cutoff) {
+			names = append(names, name)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+		if err := d.storage.Remove(path); err != nil {
+			return removed, err
+		}
+
+		d.log.Debug("Removed stale temporary file: %s", path)
+		removed++
+	}
+
+	return removed, nil
+}
+
+// CompactAll runs Compact on every collection.
+//
+// Returns:
+// - error: The first error met; the remaining collections are not compacted.
+func (d *Driver) CompactAll() error {
+	collections, err := d.Collections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if _, err := d.Compact(collection); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
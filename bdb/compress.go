@@ -0,0 +1,50 @@
+package bdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// gzipExt is appended to the codec extension of compressed records.
+const gzipExt = ".gz"
+
+// compress gzip-compresses data.
+//
+// Parameters:
+// - data: The bytes to compress.
+//
+// Returns:
+// - []byte: The compressed bytes.
+// - error: An error if compression fails.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress.
+//
+// Parameters:
+// - data: The gzip-compressed bytes.
+//
+// Returns:
+// - []byte: The decompressed bytes.
+// - error: An error if data is not valid gzip.
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,70 @@
+package bdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressShrinksLargeRecord(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, &Options{Compress: true})
+
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 2000)
+	if err := db.WriteWithID("docs", "a", map[string]interface{}{"text": text}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "docs", "a.json"+gzipExt))
+	if err != nil {
+		t.Fatalf("compressed record file: %s", err)
+	}
+	if info.Size() >= int64(len(text)) {
+		t.Errorf("compressed record is %d bytes, text alone is %d", info.Size(), len(text))
+	}
+
+	var got map[string]interface{}
+	if err := db.Read("docs", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["text"] != text {
+		t.Error("text changed by the round trip")
+	}
+}
+
+func TestCompressReadsMixedCollection(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("docs", "plain", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = openTestDriver(t, dir, &Options{Compress: true})
+	if err := db.WriteWithID("docs", "gzipped", map[string]interface{}{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := db.ReadAll("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("ReadAll returned %d records, want 2", len(records))
+	}
+
+	// An update keeps the form of the record, rather than leaving two files.
+	if err := db.Update("docs", "plain", map[string]interface{}{"n": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs", "plain.json"+gzipExt)); !os.IsNotExist(err) {
+		t.Errorf("compressed copy of the updated record: %v", err)
+	}
+
+	var got struct{ N int }
+	if err := db.Read("docs", "plain", &got); err != nil || got.N != 3 {
+		t.Errorf("Read after Update = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,398 @@
+package bdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// configFile is the reserved file, in the directory of a collection,
+// holding its CollectionConfig. It has no record extension, so it is never
+// listed as a record.
+const configFile = ".bdb-config"
+
+// CollectionConfig overrides the Options of a single collection. The zero
+// value keeps the Options.
+type CollectionConfig struct {
+	// Codec names the codec records are stored with, "json" or "yaml";
+	// empty for Options.Codec.
+	Codec string `json:"codec,omitempty"`
+	// Compress overrides Options.Compress for new writes; nil keeps it.
+	Compress *bool `json:"compress,omitempty"`
+	// Encrypt overrides whether new writes are encrypted with
+	// Options.EncryptionKey, which must be set to enable it; nil encrypts
+	// them if the key is set.
+	Encrypt *bool `json:"encrypt,omitempty"`
+	// ReadOnly makes the operations that would change the collection
+	// return ErrReadOnly, like Options.ReadOnly does for the database.
+	// ConfigureCollection can still lift it.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// DefaultTTL, if positive, is how long records written without an
+	// expiry time live, as if written with WriteWithTTL.
+	DefaultTTL time.Duration `json:"defaultTTL,omitempty"`
+	// ShardDepth overrides Options.ShardDepth; nil keeps it. Reshard sets
+	// it along with moving the records.
+	ShardDepth *int `json:"shardDepth,omitempty"`
+	// MaxRecords, if positive, is the number of records the collection
+	// can hold; inserts beyond it return ErrQuotaExceeded. SetQuota sets
+	// it alone.
+	MaxRecords int `json:"maxRecords,omitempty"`
+}
+
+// codecs are the codecs CollectionConfig.Codec can name.
+var codecs = map[string]Codec{
+	"json": JSONCodec{},
+	"yaml": YAMLCodec{},
+}
+
+// configs caches the configurations of the collections, loaded lazily from
+// disk.
+type configs struct {
+	mutex  sync.Mutex
+	loaded map[string]CollectionConfig
+}
+
+// ConfigureCollection sets the configuration of a collection, overriding
+// the Options for it.
+//
+// The configuration is stored in the reserved ".bdb-config" file of the
+// collection, so it applies again after a restart; it is removed along with
+// the collection by DropCollection. Changes of compression and encryption
+// apply to records written afterwards, existing records stay readable. The
+// codec cannot be changed once the collection holds records. Passing the
+// zero CollectionConfig restores the Options. Likewise, the shard depth
+// cannot be changed once the collection holds records; Reshard moves them.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - cfg: The configuration.
+//
+// Returns:
+// - error: An error if the configuration is invalid or cannot be stored.
+func (d *Driver) ConfigureCollection(collection string, cfg CollectionConfig) error {
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	if collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	if _, ok := codecs[cfg.Codec]; cfg.Codec != "" && !ok {
+		return fmt.Errorf("unknown codec: %s", cfg.Codec)
+	}
+
+	if cfg.Encrypt != nil && *cfg.Encrypt && d.aead == nil {
+		return fmt.Errorf("unable to encrypt collection '%s': no encryption key is configured", collection)
+	}
+
+	if cfg.DefaultTTL < 0 {
+		return fmt.Errorf("invalid ttl: %s", cfg.DefaultTTL)
+	}
+
+	if cfg.ShardDepth != nil && (*cfg.ShardDepth < 0 || *cfg.ShardDepth > maxShardDepth) {
+		return fmt.Errorf("invalid shard depth: %d", *cfg.ShardDepth)
+	}
+
+	if cfg.MaxRecords < 0 {
+		return fmt.Errorf("invalid quota: %d", cfg.MaxRecords)
+	}
+
+	cfg = cfg.clone()
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	if d.codecFor(collection).Ext() != d.configCodec(cfg).Ext() {
+		if _, names, err := d.recordNames(collection); err == nil && len(names) > 0 {
+			return fmt.Errorf("unable to change the codec of collection '%s': it holds records", collection)
+		}
+	}
+
+	if d.shardDepthFor(collection) != d.configShardDepth(cfg) {
+		if _, names, err := d.recordNames(collection); err == nil && len(names) > 0 {
+			return fmt.Errorf("unable to change the shard depth of collection '%s': it holds records, use Reshard", collection)
+		}
+	}
+
+	return d.storeConfig(collection, cfg)
+}
+
+// storeConfig stores the configuration of a collection and caches it. The
+// caller must hold the collection lock.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - cfg: The configuration.
+//
+// Returns:
+// - error: An error if the configuration cannot be stored.
+func (d *Driver) storeConfig(collection string, cfg CollectionConfig) error {
+	path := filepath.Join(d.dir, collection, configFile)
+
+	if cfg == (CollectionConfig{}) {
+		if err := d.storage.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove collection config: %s (%s)", path, err)
+		}
+	} else {
+		bytes, err := json.MarshalIndent(cfg, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+
+		if err := d.writeFileAtomic(path, append(bytes, '\n')); err != nil {
+			return fmt.Errorf("unable to store collection config: %s (%s)", path, err)
+		}
+	}
+
+	d.configs.mutex.Lock()
+	defer d.configs.mutex.Unlock()
+
+	if d.configs.loaded == nil {
+		d.configs.loaded = make(map[string]CollectionConfig)
+	}
+	d.configs.loaded[collection] = cfg
+
+	d.cache.invalidateCollection(collection)
+
+	return nil
+}
+
+// CollectionConfig returns the configuration of a collection.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - CollectionConfig: The configuration, the zero value if the collection has none.
+// - error: An error if the stored configuration cannot be read.
+func (d *Driver) CollectionConfig(collection string) (CollectionConfig, error) {
+	if err := d.checkOpen(); err != nil {
+		return CollectionConfig{}, err
+	}
+
+	if collection == "" {
+		return CollectionConfig{}, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return CollectionConfig{}, err
+	}
+
+	cfg, err := d.loadConfig(collection)
+
+	return cfg.clone(), err
+}
+
+// clone returns a copy of the configuration that shares no pointer with it.
+func (cfg CollectionConfig) clone() CollectionConfig {
+	if cfg.Compress != nil {
+		compress := *cfg.Compress
+		cfg.Compress = &compress
+	}
+	if cfg.Encrypt != nil {
+		encrypt := *cfg.Encrypt
+		cfg.Encrypt = &encrypt
+	}
+	if cfg.ShardDepth != nil {
+		depth := *cfg.ShardDepth
+		cfg.ShardDepth = &depth
+	}
+
+	return cfg
+}
+
+// loadConfig returns the configuration of a collection, loading it from
+// disk the first time.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - CollectionConfig: The configuration, the zero value if the collection has none.
+// - error: An error if the stored configuration cannot be read or parsed.
+func (d *Driver) loadConfig(collection string) (CollectionConfig, error) {
+	d.configs.mutex.Lock()
+	defer d.configs.mutex.Unlock()
+
+	if cfg, ok := d.configs.loaded[collection]; ok {
+		return cfg, nil
+	}
+
+	path := filepath.Join(d.dir, collection, configFile)
+
+	var cfg CollectionConfig
+
+	data, err := d.storage.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return CollectionConfig{}, fmt.Errorf("invalid collection config: %s (%s)", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return CollectionConfig{}, fmt.Errorf("unable to read collection config: %s (%s)", path, err)
+	}
+
+	if d.configs.loaded == nil {
+		d.configs.loaded = make(map[string]CollectionConfig)
+	}
+	d.configs.loaded[collection] = cfg
+
+	return cfg, nil
+}
+
+// forgetConfig drops the cached configuration of a collection, or of every
+// collection if collection is empty, so it is loaded again from disk.
+//
+// Parameters:
+// - collection: The name of the collection.
+func (d *Driver) forgetConfig(collection string) {
+	d.configs.mutex.Lock()
+	defer d.configs.mutex.Unlock()
+
+	if collection == "" {
+		d.configs.loaded = nil
+		return
+	}
+
+	delete(d.configs.loaded, collection)
+}
+
+// collectionConfig returns the configuration of a collection, falling back
+// to the zero value, i.e. the Options, if it cannot be loaded or the name
+// is invalid.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - CollectionConfig: The configuration.
+func (d *Driver) collectionConfig(collection string) CollectionConfig {
+	if collection == "" || validateCollection(collection) != nil {
+		return CollectionConfig{}
+	}
+
+	cfg, err := d.loadConfig(collection)
+	if err != nil {
+		d.log.Error("Unable to load the config of '%s', using the defaults: %s", collection, err)
+	}
+
+	return cfg
+}
+
+// configCodec returns the codec a configuration selects.
+//
+// Parameters:
+// - cfg: The configuration.
+//
+// Returns:
+// - Codec: The named codec, or Options.Codec if none is named.
+func (d *Driver) configCodec(cfg CollectionConfig) Codec {
+	if cfg.Codec == "json" {
+		return d.jsonCodec
+	}
+
+	if codec, ok := codecs[cfg.Codec]; ok {
+		return codec
+	}
+
+	return d.codec
+}
+
+// configShardDepth returns the shard depth a configuration selects.
+//
+// Parameters:
+// - cfg: The configuration.
+//
+// Returns:
+// - int: The configured depth, or Options.ShardDepth if none is set.
+func (d *Driver) configShardDepth(cfg CollectionConfig) int {
+	if cfg.ShardDepth != nil {
+		return *cfg.ShardDepth
+	}
+
+	return d.shardDepth
+}
+
+// codecFor returns the codec records of a collection are stored with.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - Codec: The codec of the collection, or Options.Codec.
+func (d *Driver) codecFor(collection string) Codec {
+	return d.configCodec(d.collectionConfig(collection))
+}
+
+// compressFor reports whether new records of a collection are compressed.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: The compression setting of the collection, or Options.Compress.
+func (d *Driver) compressFor(collection string) bool {
+	if cfg := d.collectionConfig(collection); cfg.Compress != nil {
+		return *cfg.Compress
+	}
+
+	return d.compress
+}
+
+// encryptFor reports whether new records of a collection are encrypted.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: True if a key is configured and the collection does not disable encryption.
+func (d *Driver) encryptFor(collection string) bool {
+	if d.aead == nil {
+		return false
+	}
+
+	if cfg := d.collectionConfig(collection); cfg.Encrypt != nil {
+		return *cfg.Encrypt
+	}
+
+	return true
+}
+
+// checkWritable returns ErrClosed if the driver is closed, or ErrReadOnly
+// if the database or the collection is read-only.
+//
+// Parameters:
+// - collection: The name of the collection about to be changed.
+//
+// Returns:
+// - error: ErrClosed or ErrReadOnly, or nil if the collection may be changed.
+func (d *Driver) checkWritable(collection string) error {
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	if d.collectionConfig(collection).ReadOnly {
+		return fmt.Errorf("%w: collection '%s'", ErrReadOnly, collection)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package bdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConfigureCollectionPersists(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.ConfigureCollection("logs", CollectionConfig{ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("logs", "a", map[string]interface{}{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write to a read-only collection: got %v, want ErrReadOnly", err)
+	}
+	if err := db.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Errorf("write to another collection: %s", err)
+	}
+	db.Close()
+
+	db = openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("logs", "a", map[string]interface{}{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write after a restart: got %v, want ErrReadOnly", err)
+	}
+
+	// The read-only flag can be lifted.
+	if err := db.ConfigureCollection("logs", CollectionConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("logs", "a", map[string]interface{}{}); err != nil {
+		t.Errorf("write after lifting read-only: %s", err)
+	}
+}
+
+func TestConfigureCollectionRejectsCodecChange(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ConfigureCollection("users", CollectionConfig{Codec: "yaml"}); err == nil {
+		t.Error("codec change of a collection holding records: got nil error")
+	}
+	if err := db.ConfigureCollection("users", CollectionConfig{Codec: "xml"}); err == nil {
+		t.Error("unknown codec: got nil error")
+	}
+}
+
+func TestConfigureCollectionAfterClose(t *testing.T) {
+	db := newTestDriver(t, nil)
+	db.Close()
+
+	if err := db.ConfigureCollection("users", CollectionConfig{ReadOnly: true}); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,210 @@
+package bdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Copy writes a copy of a record to a collection under a new ID, like
+// Write.
+//
+// The copy gets its own "_id" and starts at version 1; its creation and
+// update times are reset. Soft-deleted and expired records cannot be
+// copied.
+//
+// Parameters:
+// - srcCollection: The name of the collection to copy from.
+// - srcResource: The name of the resource to copy.
+// - dstCollection: The name of the collection to copy to, which may be srcCollection.
+//
+// Returns:
+// - string: The ID of the copy.
+// - error: An error if the record cannot be read or the copy cannot be written.
+func (d *Driver) Copy(srcCollection, srcResource, dstCollection string) (newID string, err error) {
+	defer d.stats.observe(statWrite, dstCollection, time.Now(), &err)
+
+	if err := d.checkWritable(dstCollection); err != nil {
+		return "", err
+	}
+
+	bytes, err := d.readBytes(context.Background(), srcCollection, srcResource)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := d.decodeRecord(srcCollection, bytes)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode record: %s/%s (%w)", srcCollection, srcResource, err)
+	}
+
+	delete(data, createdAtField)
+	delete(data, updatedAtField)
+
+	return d.insert(context.Background(), dstCollection, data, d.recordKeyOrder(srcCollection, bytes))
+}
+
+// Move moves a record to another ID, in the same or another collection.
+//
+// The record is written to its destination, with "_id" set to dstResource,
+// then removed from its source; its other fields, including its version
+// and timestamps, are kept. Both collections are locked meanwhile. The
+// destination file is written in full to a temporary file first, then
+// put into place without replacing a file created meanwhile, e.g. by
+// another process, as with Options.StrictInsert.
+//
+// Parameters:
+// - srcCollection: The name of the collection to move from.
+// - srcResource: The name of the resource to move.
+// - dstCollection: The name of the collection to move to, which may be srcCollection.
+// - dstResource: The new name of the resource.
+//
+// Returns:
+// - error: ErrDuplicateKey if the destination already exists, or an error if the record cannot be moved.
+func (d *Driver) Move(srcCollection, srcResource, dstCollection, dstResource string) error {
+	for _, collection := range []string{srcCollection, dstCollection} {
+		if err := d.checkWritable(collection); err != nil {
+			return err
+		}
+	}
+
+	if srcCollection == "" || dstCollection == "" {
+		return ErrCollectionMissing
+	}
+
+	if srcResource == "" || dstResource == "" {
+		return ErrResourceMissing
+	}
+
+	for _, name := range []string{srcCollection, dstCollection} {
+		if err := validateCollection(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{srcResource, dstResource} {
+		if err := validateName(name); err != nil {
+			return err
+		}
+	}
+
+	if srcCollection == dstCollection && srcResource == dstResource {
+		return nil
+	}
+
+	unlock := d.lockCollections(srcCollection, dstCollection)
+	defer unlock()
+
+	srcPath, err := d.locateRecord(srcCollection, srcResource)
+	if err != nil {
+		return lookupError("resource", srcPath, err)
+	}
+
+	bytes, err := d.readRecord(srcPath)
+	if err != nil {
+		return fmt.Errorf("error reading file: %s (%s)", srcPath, err)
+	}
+
+	if d.isHidden(srcCollection, bytes, false) {
+		return fmt.Errorf("%w: resource %s/%s (record is deleted or expired)", ErrNotFound, srcCollection, srcResource)
+	}
+
+	if path, err := d.locateRecord(dstCollection, dstResource); err == nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to check resource: %s (%s)", path, err)
+	}
+
+	if srcCollection != dstCollection {
+		if err := d.checkQuota(dstCollection); err != nil {
+			return err
+		}
+	}
+
+	data, err := d.decodeRecord(srcCollection, bytes)
+	if err != nil {
+		return fmt.Errorf("unable to decode record: %s (%w)", srcPath, err)
+	}
+	data["_id"] = dstResource
+
+	if err := d.validate(dstCollection, data); err != nil {
+		return err
+	}
+
+	// Within a collection, the record no longer holds its values once
+	// moved.
+	pending := map[string]map[string]interface{}{}
+	if srcCollection == dstCollection {
+		pending[srcResource] = nil
+	}
+
+	if err := d.checkUnique(dstCollection, dstResource, data, pending); err != nil {
+		return err
+	}
+
+	moved, err := d.marshalRecord(dstCollection, data, d.recordKeyOrder(srcCollection, bytes))
+	if err != nil {
+		return err
+	}
+
+	if d.skipChange(OpCreate, dstCollection, dstResource, moved) {
+		d.skipChange(OpDelete, srcCollection, srcResource, nil)
+		return nil
+	}
+
+	if err := d.mkdirAll(filepath.Join(d.dir, dstCollection)); err != nil {
+		return err
+	}
+
+	dstPath := d.recordPath(dstCollection, dstResource)
+	if err := d.putRecord(dstCollection, dstPath, moved, true); err != nil {
+		return err
+	}
+
+	d.watchers.markSelfChange(srcPath)
+
+	if err := d.storage.Remove(srcPath); err != nil {
+		// Undo the copy, so the record is not left in both places.
+		d.watchers.markSelfChange(dstPath)
+		if err := d.storage.Remove(dstPath); err != nil {
+			d.log.Error("Unable to remove moved record: %s (%s)", dstPath, err)
+		}
+		return fmt.Errorf("unable to remove file: %s (%s)", srcPath, err)
+	}
+
+	d.stats.addBytes(dstCollection, 0, len(moved))
+
+	d.reindex(srcCollection, srcResource, nil)
+	d.cache.invalidate(srcCollection, srcResource)
+	d.logChange(OpDelete, srcCollection, srcResource, nil)
+	d.notify(OpDelete, srcCollection, srcResource)
+
+	d.reindex(dstCollection, dstResource, data)
+	d.cache.invalidate(dstCollection, dstResource)
+	d.logChange(OpCreate, dstCollection, dstResource, data)
+	d.notify(OpCreate, dstCollection, dstResource)
+
+	return nil
+}
+
+// Rename changes the ID of a record within its collection, like Move.
+//
+// The record, with its "_id" field updated, is written to a temporary file
+// which is then linked under the new ID, with a Storage implementing
+// Linker, so the new ID appears in a single step, with its full content,
+// and never replaces an existing record. The old file is removed next,
+// both while holding the collection lock; a crash in between leaves the
+// record under both IDs, never under neither.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - oldResource: The current ID of the record.
+// - newResource: The new ID of the record.
+//
+// Returns:
+// - error: ErrNotFound if the record does not exist, ErrDuplicateKey if the new ID is taken, ErrInvalidName if it is not a valid name, or an error if the record cannot be renamed.
+func (d *Driver) Rename(collection, oldResource, newResource string) error {
+	return d.Move(collection, oldResource, collection, newResource)
+}
@@ -0,0 +1,109 @@
+package bdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameRoundTrip(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	user := testUser{Name: "John", Age: "23", Address: testAddress{City: "bangalore", Pincode: "515671"}}
+	if err := db.WriteWithID("users", "john", user); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("users", "taken", testUser{Name: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename("users", "john", "j.smith"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "john", &got); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of the old ID: got %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(db.recordPath("users", "john")); !os.IsNotExist(err) {
+		t.Errorf("old file still present: %v", err)
+	}
+
+	var record struct {
+		testUser
+		ID string `json:"_id"`
+	}
+	if err := db.Read("users", "j.smith", &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.testUser != user || record.ID != "j.smith" {
+		t.Errorf("renamed record = %+v, want the original under _id j.smith", record)
+	}
+
+	// And back again.
+	if err := db.Rename("users", "j.smith", "john"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Read("users", "john", &got); err != nil || got != user {
+		t.Errorf("after renaming back = %+v, %v", got, err)
+	}
+
+	errs := map[string]struct {
+		err  error
+		want error
+	}{
+		"missing source": {db.Rename("users", "nobody", "x"), ErrNotFound},
+		"taken target":   {db.Rename("users", "john", "taken"), ErrDuplicateKey},
+		"invalid target": {db.Rename("users", "john", "../x"), ErrInvalidName},
+	}
+	for name, e := range errs {
+		if !errors.Is(e.err, e.want) {
+			t.Errorf("%s: got %v, want %v", name, e.err, e.want)
+		}
+	}
+
+	if err := db.Read("users", "taken", &got); err != nil || got.Name != "Other" {
+		t.Errorf("target of the rejected rename = %+v, %v", got, err)
+	}
+}
+
+// racingStorage creates the file at path, as another process would, right
+// before the driver writes its temporary file.
+type racingStorage struct {
+	DiskStorage
+	path string
+}
+
+func (s racingStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if path == s.path+".tmp" {
+		if err := os.WriteFile(s.path, []byte(`{"_id":"b","Name":"Other"}`), 0644); err != nil {
+			return err
+		}
+	}
+	return s.DiskStorage.WriteFile(path, data, perm)
+}
+
+func TestRenameKeepsRecordCreatedMeanwhile(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, &Options{Storage: racingStorage{path: filepath.Join(dir, "users", "b.json")}})
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename("users", "a", "b"); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("Rename: got %v, want ErrDuplicateKey", err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "b", &got); err != nil || got.Name != "Other" {
+		t.Errorf("record created meanwhile = %+v, %v; want it kept", got, err)
+	}
+	if err := db.Read("users", "a", &got); err != nil || got.Name != "John" {
+		t.Errorf("renamed record = %+v, %v; want it kept under its old ID", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users", "b.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package bdb
+
+import "errors"
+
+// ErrDryRun is returned by the operations that cannot be previewed when
+// Options.DryRun is set, such as Tx.Commit.
+var ErrDryRun = errors.New("operation not supported in a dry run")
+
+// skipChange reports whether a change to a record must be skipped because
+// Options.DryRun is set, logging the change it stands for instead. Callers
+// return right away when it does, before touching the file system or
+// running the hooks of the change.
+//
+// Parameters:
+// - op: The kind of change.
+// - collection: The name of the collection.
+// - id: The ID of the record.
+// - bytes: The encoded record that would be written, nil for a delete.
+//
+// Returns:
+// - bool: True if the change must be skipped.
+func (d *Driver) skipChange(op Op, collection, id string, bytes []byte) bool {
+	if !d.dryRun {
+		return false
+	}
+
+	if op == OpDelete {
+		d.log.Info("Dry run: %s %s/%s", op, collection, id)
+	} else {
+		d.log.Info("Dry run: %s %s/%s (%d bytes): %s", op, collection, id, len(bytes), bytes)
+	}
+
+	return true
+}
+
+// skipRecords logs the removal of every record of a collection, which
+// DropCollection and Truncate stand for in a dry run.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - error: An error if the collection does not exist or cannot be read.
+func (d *Driver) skipRecords(collection string) error {
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	_, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		d.skipChange(OpDelete, collection, d.recordID(collection, name), nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package bdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	writeExpired(t, db, "users", "expired")
+	db.Close()
+
+	db = openTestDriver(t, dir, &Options{DryRun: true})
+	before := listFiles(t, dir)
+
+	update := map[string]interface{}{"Name": "Jane"}
+	ops := map[string]func() error{
+		"Write": func() error {
+			_, err := db.Write("users", update)
+			return err
+		},
+		"WriteWithID": func() error { return db.WriteWithID("users", "b", update) },
+		"Update":      func() error { return db.Update("users", "a", update) },
+		"Replace":     func() error { return db.Replace("users", "a", update) },
+		"Upsert": func() error {
+			_, err := db.Upsert("users", "c", update)
+			return err
+		},
+		"Patch":          func() error { return db.Patch("users", "a", "/Name", "Jane") },
+		"Delete":         func() error { return db.Delete("users", "a") },
+		"Move":           func() error { return db.Move("users", "a", "archive", "a") },
+		"Rename":         func() error { return db.Rename("users", "a", "b") },
+		"Truncate":       func() error { return db.Truncate("users") },
+		"DropCollection": func() error { return db.DropCollection("users") },
+		"reap": func() error {
+			db.reap()
+			return nil
+		},
+	}
+	for name, op := range ops {
+		if err := op(); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	tx := db.Begin("users")
+	if _, err := tx.Write(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrDryRun) {
+		t.Errorf("Tx.Commit: got %v, want ErrDryRun", err)
+	}
+	if _, err := db.Reshard("users", 1); !errors.Is(err, ErrDryRun) {
+		t.Errorf("Reshard: got %v, want ErrDryRun", err)
+	}
+
+	if after := listFiles(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("files changed by a dry run:\nbefore %v\nafter  %v", before, after)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil || got.Name != "John" {
+		t.Errorf("Read = %+v, %v", got, err)
+	}
+
+	// Lookups and validation still run.
+	if err := db.Update("users", "missing", update); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a missing record: got %v, want ErrNotFound", err)
+	}
+}
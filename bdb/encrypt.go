@@ -0,0 +1,125 @@
+package bdb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// encryptedMagic prefixes every encrypted record so encrypted files can be
+// told apart from plain ones.
+var encryptedMagic = []byte("BDBENC1\x00")
+
+// ErrEncrypted is returned when reading an encrypted record without an
+// encryption key configured.
+var ErrEncrypted = errors.New("record is encrypted but no encryption key is configured")
+
+// newAEAD creates the AES-GCM cipher for an encryption key.
+//
+// Parameters:
+// - key: The AES key, 32 bytes for AES-256 (16 and 24 are also accepted).
+//
+// Returns:
+// - cipher.AEAD: The cipher.
+// - error: An error if the key has an invalid length.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %s", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals data with a random nonce, returning the magic header,
+// the nonce and the ciphertext.
+//
+// Parameters:
+// - aead: The cipher.
+// - data: The plaintext.
+//
+// Returns:
+// - []byte: The encrypted record.
+// - error: An error if no nonce could be generated.
+func encrypt(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// decrypt opens data produced by encrypt.
+//
+// Parameters:
+// - aead: The cipher, nil if no key is configured.
+// - data: The encrypted record, including the magic header.
+//
+// Returns:
+// - []byte: The plaintext.
+// - error: ErrEncrypted if aead is nil, or an error if authentication
+// fails, e.g. because the key is wrong.
+func decrypt(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if aead == nil {
+		return nil, ErrEncrypted
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("unable to decrypt record: data too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt record (wrong key?): %s", err)
+	}
+
+	return plain, nil
+}
+
+// isEncrypted reports whether raw file content is an encrypted record.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// IsEncrypted reports whether the records of a collection are encrypted.
+//
+// The first record of the collection is inspected, so callers can tell a
+// missing key apart from a corrupt record before reading.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: True if the records are encrypted, false for an empty collection.
+// - error: An error if the collection cannot be read.
+func (d *Driver) IsEncrypted(collection string) (bool, error) {
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return false, err
+	}
+
+	if len(names) == 0 {
+		return false, nil
+	}
+
+	path := filepath.Join(collectionPath, names[0])
+
+	data, err := d.storage.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("error reading file: %s (%s)", path, err)
+	}
+
+	return isEncrypted(data), nil
+}
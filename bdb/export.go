@@ -0,0 +1,307 @@
+package bdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// ExportNDJSON writes the records of a collection to w as newline-delimited
+// JSON, one compact object per line, sorted by ID.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - w: The writer receiving the records.
+//
+// Returns:
+// - error: An error if a record cannot be read or w fails.
+func (d *Driver) ExportNDJSON(collection string, w io.Writer) error {
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		data, err := d.readRecord(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		record, err := d.decodeRecord(collection, data)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling json: %s (%w)", path, err)
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("error marshalling json: %s (%s)", path, err)
+		}
+
+		if _, err := bw.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ImportNDJSON writes each line of newline-delimited JSON read from r as a
+// new record of a collection.
+//
+// A record keeps the ID in its "_id" field, if any, and gets a generated
+// one otherwise; an ID that is already taken fails the import with
+// ErrDuplicateKey, as with WriteWithID, rather than overwriting the
+// record. Blank lines are skipped. Import stops at the first
+// invalid line or failed write; records written until then are kept.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - r: The reader providing the records.
+//
+// Returns:
+// - []string: The IDs of the written records, in input order.
+// - error: An error if a line is invalid or a record cannot be written.
+func (d *Driver) ImportNDJSON(collection string, r io.Reader) ([]string, error) {
+	if err := d.checkWritable(collection); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+
+	ids := []string{}
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return ids, err
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			record, err := util.DecodeMap(trimmed)
+			if err != nil {
+				return ids, fmt.Errorf("invalid json on line %d: %s", lineNo, err)
+			}
+			util.DropDeleted(record)
+
+			id, err := d.importRecord(collection, record)
+			if err != nil {
+				return ids, fmt.Errorf("unable to import line %d: %w", lineNo, err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		if err != nil {
+			return ids, nil
+		}
+	}
+}
+
+// importRecord writes an imported record, honouring its "_id" field.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - record: The record to write.
+//
+// Returns:
+// - string: The ID of the record.
+// - error: An error if the record cannot be written.
+func (d *Driver) importRecord(collection string, record map[string]interface{}) (string, error) {
+	if id, ok := record["_id"].(string); ok && id != "" {
+		return id, d.WriteWithID(collection, id, record)
+	}
+
+	return d.Write(collection, record)
+}
+
+// ExportCSV writes the records of a collection to w as CSV, one row per
+// record, sorted by ID.
+//
+// Nested objects are flattened into dotted columns ("Address.City"). The
+// header is the sorted union of the columns of all records; a record
+// without a column gets an empty cell. Arrays and empty objects are
+// written as JSON within the cell, and null values as empty cells.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - w: The writer receiving the CSV.
+//
+// Returns:
+// - error: An error if a record cannot be read or w fails.
+func (d *Driver) ExportCSV(collection string, w io.Writer) error {
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]map[string]string, 0, len(names))
+	columns := map[string]bool{}
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		data, err := d.readRecord(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		record, err := d.decodeRecord(collection, data)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling json: %s (%w)", path, err)
+		}
+
+		row := map[string]string{}
+		if err := flattenCSV("", record, row); err != nil {
+			return fmt.Errorf("unable to flatten record: %s (%s)", path, err)
+		}
+
+		for column := range row {
+			columns[column] = true
+		}
+		rows = append(rows, row)
+	}
+
+	header := make([]string, 0, len(columns))
+	for column := range columns {
+		header = append(header, column)
+	}
+	sort.Strings(header)
+
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		cells := make([]string, len(header))
+		for i, column := range header {
+			cells[i] = row[column]
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// flattenCSV adds the cells of a record, or of a nested object under
+// prefix, to row.
+func flattenCSV(prefix string, record map[string]interface{}, row map[string]string) error {
+	for key, value := range record {
+		column := key
+		if prefix != "" {
+			column = prefix + "." + key
+		}
+
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if len(value) > 0 {
+				if err := flattenCSV(column, value, row); err != nil {
+					return err
+				}
+				continue
+			}
+			row[column] = "{}"
+		case nil:
+			row[column] = ""
+		case string:
+			row[column] = value
+		default:
+			cell, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			row[column] = string(cell)
+		}
+	}
+
+	return nil
+}
+
+// ImportCSV writes each row of CSV read from r as a new record of a
+// collection.
+//
+// The first row is the header. Dotted columns ("Address.City") are
+// rebuilt into nested objects, and empty cells are omitted. A cell that
+// holds a JSON number, boolean, array or object is decoded as such, so
+// that ExportCSV output round-trips; any other cell is kept as a string.
+// A record keeps the ID in its "_id" column, if any, and gets a generated
+// one otherwise; an ID that is already taken fails the import with
+// ErrDuplicateKey. Import stops at the first invalid row or failed write;
+// records written until then are kept.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - r: The reader providing the CSV.
+//
+// Returns:
+// - error: An error if a row is invalid or a record cannot be written.
+func (d *Driver) ImportCSV(collection string, r io.Reader) error {
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid csv header: %s", err)
+	}
+
+	for rowNo := 2; ; rowNo++ {
+		cells, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid csv on row %d: %s", rowNo, err)
+		}
+
+		record := map[string]interface{}{}
+		for i, cell := range cells {
+			if cell == "" {
+				continue
+			}
+			if err := util.SetField(record, header[i], parseCSVCell(cell)); err != nil {
+				return fmt.Errorf("invalid csv on row %d: %s", rowNo, err)
+			}
+		}
+
+		if _, err := d.importRecord(collection, record); err != nil {
+			return fmt.Errorf("unable to import row %d: %w", rowNo, err)
+		}
+	}
+}
+
+// parseCSVCell decodes a cell holding a JSON number, boolean, array or
+// object, and returns any other cell as a string.
+func parseCSVCell(cell string) interface{} {
+	c := cell[0]
+	if c == '[' || c == '{' || c == '-' || (c >= '0' && c <= '9') || cell == "true" || cell == "false" {
+		var value interface{}
+		if err := json.Unmarshal([]byte(cell), &value); err == nil {
+			return value
+		}
+	}
+
+	return cell
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package bdb
+
+import "os"
+
+// flock does nothing on platforms without flock(2); the lock file is still
+// created, but does not keep other processes out.
+func flock(file *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package bdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes a non-blocking flock(2) lock on a file, exclusive or shared.
+// It is released when the file is closed.
+//
+// Parameters:
+// - file: The lock file.
+// - exclusive: Whether to take an exclusive lock rather than a shared one.
+//
+// Returns:
+// - error: An error if the lock is held by another process.
+func flock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	return syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+}
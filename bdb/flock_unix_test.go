@@ -0,0 +1,53 @@
+//go:build unix
+
+package bdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFailsWhenLockedByAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+
+	// A lock taken through another open file stands for another process.
+	file, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := flock(file, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("writable driver: got %v, want ErrLocked", err)
+	}
+	if _, err := New(dir, &Options{ReadOnly: true}); !errors.Is(err, ErrLocked) {
+		t.Errorf("read-only driver: got %v, want ErrLocked", err)
+	}
+}
+
+func TestReadOnlyDriversShareLock(t *testing.T) {
+	dir := t.TempDir()
+
+	openTestDriver(t, dir, nil).Close()
+
+	// A shared lock taken through another open file stands for another
+	// reading process.
+	file, err := os.Open(filepath.Join(dir, lockFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := flock(file, false); err != nil {
+		t.Fatal(err)
+	}
+
+	openTestDriver(t, dir, &Options{ReadOnly: true}).Close()
+	if _, err := New(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("writable driver: got %v, want ErrLocked", err)
+	}
+}
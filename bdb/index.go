@@ -0,0 +1,688 @@
+package bdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// indexDir is the reserved directory, relative to the database directory,
+// holding the indexes of each collection as "<collection>/<field>.json".
+// The changes made since an index file was written are appended to a log
+// next to it, "<collection>/<field>.<generation>.log".
+const indexDir = "_index"
+
+// indexLogSlack is the number of log entries, beyond the number of indexed
+// records, after which the log of an index is folded into its file. It
+// bounds the time spent replaying the log on load.
+const indexLogSlack = 1024
+
+// index maps the values of a field to the IDs of the records holding them.
+//
+// Values are keyed by their JSON encoding, so 1 and "1" are different
+// values. Only the ID to value mapping is persisted; the reverse mapping
+// is rebuilt on load.
+type index struct {
+	field  string
+	unique bool
+	ids    map[string]string
+	values map[string]map[string]bool
+	// gen is the generation of the log the index file refers to, and
+	// logged the number of entries appended to it.
+	gen    int
+	logged int
+}
+
+// indexFile is the on-disk form of an index.
+type indexFile struct {
+	Unique bool              `json:"unique,omitempty"`
+	Log    int               `json:"log,omitempty"`
+	IDs    map[string]string `json:"ids"`
+}
+
+// indexLogEntry is a change of an index, appended to its log: the new key
+// of a record, or its removal.
+type indexLogEntry struct {
+	ID      string `json:"id"`
+	Key     string `json:"key,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// indexes caches the indexes of the collections, loaded lazily from disk.
+type indexes struct {
+	mutex  sync.Mutex
+	loaded map[string]map[string]*index
+}
+
+// CreateIndex builds an index on a field of a collection and keeps it up
+// to date on every later change made through the driver.
+//
+// The index is stored in the reserved "_index" directory, so it survives
+// restarts. Each later change appends an entry to a log next to the index
+// rather than rewriting it, so it costs the same whatever the size of the
+// collection; the log is folded into the index once it outgrows it.
+// Creating an index that already exists rebuilds it, which also repairs an
+// index that got out of sync, e.g. after records were changed by another
+// process. Records without the field are not indexed.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field to index, as a dotted path for nested fields (e.g. "Address.City").
+//
+// Returns:
+// - error: An error if the collection cannot be read or the index cannot be stored.
+func (d *Driver) CreateIndex(collection, field string) error {
+	return d.buildIndex(collection, field, false)
+}
+
+// AddUniqueConstraint makes Write, Update and Replace reject a record whose
+// field holds the same value as the field of another record of the
+// collection.
+//
+// The constraint is enforced through an index on the field, which is
+// created, or rebuilt, and persisted like one made by CreateIndex. Records
+// without the field are not constrained. If existing records already
+// violate the constraint, it is not added and the violations are reported.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field that must be unique, as a dotted path for nested fields.
+//
+// Returns:
+// - error: ErrDuplicateKey listing the existing violations, or an error if the index cannot be built.
+func (d *Driver) AddUniqueConstraint(collection, field string) error {
+	return d.buildIndex(collection, field, true)
+}
+
+// buildIndex builds and stores an index on a field of a collection.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The field to index.
+// - unique: Whether the index enforces a unique constraint; an existing constraint is kept either way.
+//
+// Returns:
+// - error: ErrDuplicateKey if a unique index cannot be built, or an error if the collection cannot be read or the index cannot be stored.
+func (d *Driver) buildIndex(collection, field string, unique bool) error {
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	if collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	if field == "" {
+		return fmt.Errorf("missing field")
+	}
+
+	if strings.ContainsAny(field, `/\`) {
+		return fmt.Errorf("invalid field: %s", field)
+	}
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	idx := newIndex(field)
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		record, err := d.decodeRecord(collection, bytes)
+		if err != nil {
+			return fmt.Errorf("error unmarshalling json: %s (%s)", path, err)
+		}
+
+		if err := idx.set(d.recordID(collection, name), record); err != nil {
+			return fmt.Errorf("unable to index record: %s (%s)", path, err)
+		}
+	}
+
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	collectionIndexes, err := d.loadIndexes(collection)
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := collectionIndexes[field]; ok {
+		unique = unique || existing.unique
+		idx.gen = existing.gen
+	}
+
+	if unique {
+		if err := idx.duplicates(); err != nil {
+			return err
+		}
+		idx.unique = true
+	}
+
+	if err := d.storeIndex(collection, idx); err != nil {
+		return err
+	}
+
+	collectionIndexes[field] = idx
+
+	return nil
+}
+
+// FindByIndex returns the IDs of the records whose field equals value,
+// using the index on the field instead of reading every record.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - field: The indexed field.
+// - value: The value to look up.
+//
+// Returns:
+// - []string: The matching IDs, sorted.
+// - error: An error if the field is not indexed.
+func (d *Driver) FindByIndex(collection, field string, value interface{}) ([]string, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	if collection == "" {
+		return nil, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	key, err := indexKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %s", err)
+	}
+
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	collectionIndexes, err := d.loadIndexes(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, ok := collectionIndexes[field]
+	if !ok {
+		return nil, fmt.Errorf("no index on field '%s' of collection '%s'", field, collection)
+	}
+
+	ids := make([]string, 0, len(idx.values[key]))
+	for id := range idx.values[key] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// checkUnique verifies that a record about to be stored under id does not
+// violate a unique constraint of its collection. The caller must hold the
+// collection lock, which lockRecord takes for collections with a unique
+// constraint.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+// - record: The record about to be stored.
+// - pending: The records changed but not yet indexed, nil for a deleted one (used by transactions).
+//
+// Returns:
+// - error: ErrDuplicateKey if another record holds the same value, or an error if the indexes cannot be loaded.
+func (d *Driver) checkUnique(collection, id string, record map[string]interface{}, pending map[string]map[string]interface{}) error {
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	collectionIndexes, err := d.loadIndexes(collection)
+	if err != nil {
+		return err
+	}
+
+	for field, idx := range collectionIndexes {
+		if !idx.unique {
+			continue
+		}
+
+		value, ok := util.GetField(record, field)
+		if !ok {
+			continue
+		}
+
+		key, err := indexKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field '%s': %s", field, err)
+		}
+
+		for other := range idx.values[key] {
+			if _, changed := pending[other]; other != id && !changed {
+				return fmt.Errorf("%w: field '%s' has value %s in record %s", ErrDuplicateKey, field, key, other)
+			}
+		}
+
+		for other, otherRecord := range pending {
+			if other == id || otherRecord == nil {
+				continue
+			}
+			if otherValue, ok := util.GetField(otherRecord, field); ok {
+				if otherKey, err := indexKey(otherValue); err == nil && otherKey == key {
+					return fmt.Errorf("%w: field '%s' has value %s in record %s", ErrDuplicateKey, field, key, other)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// reindex updates the indexes of a collection after a record was written
+// or, if record is nil, removed. The caller must hold the lock of the
+// record.
+//
+// Failures are logged rather than returned, as the record itself has
+// already been changed; CreateIndex rebuilds an index that is out of sync.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+// - record: The new content of the record, nil if it was removed.
+func (d *Driver) reindex(collection, id string, record map[string]interface{}) {
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	collectionIndexes, err := d.loadIndexes(collection)
+	if err != nil {
+		d.log.Error("Unable to update the indexes of '%s': %s", collection, err)
+		return
+	}
+
+	for _, idx := range collectionIndexes {
+		var changed bool
+		var err error
+		if record == nil {
+			changed = idx.remove(id)
+		} else if changed, err = idx.changes(id, record); err == nil && changed {
+			err = idx.set(id, record)
+		}
+
+		if err == nil && changed {
+			err = d.logIndexChange(collection, idx, id)
+		}
+
+		if err != nil {
+			d.log.Error("Unable to update index '%s' of '%s': %s", idx.field, collection, err)
+		}
+	}
+}
+
+// clearIndexes empties the indexes of a collection, keeping their
+// definitions. The caller must hold the collection lock.
+//
+// Parameters:
+// - collection: The name of the collection.
+func (d *Driver) clearIndexes(collection string) {
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	collectionIndexes, err := d.loadIndexes(collection)
+	if err != nil {
+		d.log.Error("Unable to clear the indexes of '%s': %s", collection, err)
+		return
+	}
+
+	for field, old := range collectionIndexes {
+		idx := newIndex(field)
+		idx.unique = old.unique
+		idx.gen = old.gen
+		if err := d.storeIndex(collection, idx); err != nil {
+			d.log.Error("Unable to clear index '%s' of '%s': %s", field, collection, err)
+			continue
+		}
+		collectionIndexes[field] = idx
+	}
+}
+
+// dropIndexes removes the indexes of a collection. The caller must hold
+// the collection lock.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - error: An error if the index files cannot be removed.
+func (d *Driver) dropIndexes(collection string) error {
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	delete(d.indexes.loaded, collection)
+
+	path := filepath.Join(d.dir, indexDir, collection)
+	if err := d.storage.RemoveAll(path); err != nil {
+		return fmt.Errorf("unable to remove indexes: %s (%s)", path, err)
+	}
+
+	return nil
+}
+
+// loadIndexes returns the indexes of a collection keyed by field, loading
+// them from disk the first time. The caller must hold d.indexes.mutex.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - map[string]*index: The indexes, empty if the collection has none.
+// - error: An error if a stored index cannot be read.
+func (d *Driver) loadIndexes(collection string) (map[string]*index, error) {
+	if collectionIndexes, ok := d.indexes.loaded[collection]; ok {
+		return collectionIndexes, nil
+	}
+
+	dir := filepath.Join(d.dir, indexDir, collection)
+
+	entries, err := d.storage.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read directory: %s (%s)", dir, err)
+	}
+
+	collectionIndexes := map[string]*index{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		data, err := d.storage.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		var file indexFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid index: %s (%s)", path, err)
+		}
+
+		idx := newIndex(strings.TrimSuffix(entry.Name(), ".json"))
+		idx.unique = file.Unique
+		idx.gen = file.Log
+		for id, key := range file.IDs {
+			idx.ids[id] = key
+			idx.add(id, key)
+		}
+
+		if err := d.replayIndexLog(collection, idx); err != nil {
+			return nil, err
+		}
+
+		collectionIndexes[idx.field] = idx
+	}
+
+	if d.indexes.loaded == nil {
+		d.indexes.loaded = make(map[string]map[string]*index)
+	}
+	d.indexes.loaded[collection] = collectionIndexes
+
+	return collectionIndexes, nil
+}
+
+// storeIndex writes an index to disk, starting a new, empty log. The
+// caller must hold d.indexes.mutex.
+//
+// The index file names the generation of its log, so the previous log is
+// ignored as soon as the file is replaced, even if removing it fails.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - idx: The index.
+//
+// Returns:
+// - error: An error if the index cannot be written.
+func (d *Driver) storeIndex(collection string, idx *index) error {
+	path := filepath.Join(d.dir, indexDir, collection, idx.field+".json")
+	oldLog := d.indexLogPath(collection, idx)
+
+	data, err := json.Marshal(indexFile{Unique: idx.unique, Log: idx.gen + 1, IDs: idx.ids})
+	if err != nil {
+		return err
+	}
+
+	if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if err := d.writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("unable to store index: %s (%s)", path, err)
+	}
+
+	idx.gen++
+	idx.logged = 0
+
+	if err := d.storage.RemoveAll(oldLog); err != nil {
+		d.log.Warn("Unable to remove index log: %s (%s)", oldLog, err)
+	}
+
+	return nil
+}
+
+// indexLogPath returns the path of the current log of an index.
+func (d *Driver) indexLogPath(collection string, idx *index) string {
+	return filepath.Join(d.dir, indexDir, collection, fmt.Sprintf("%s.%d.log", idx.field, idx.gen))
+}
+
+// logIndexChange appends the entry of a record to the log of an index,
+// folding the log into the index file once it holds more entries than the
+// index by indexLogSlack. The caller must hold d.indexes.mutex.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - idx: The index, already updated.
+// - id: The ID of the changed record.
+//
+// Returns:
+// - error: An error if the log or the index cannot be written.
+func (d *Driver) logIndexChange(collection string, idx *index, id string) error {
+	entry := indexLogEntry{ID: id}
+	if key, ok := idx.ids[id]; ok {
+		entry.Key = key
+	} else {
+		entry.Removed = true
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := d.indexLogPath(collection, idx)
+
+	// A single write per entry keeps each line whole.
+	if err := d.storage.AppendFile(path, append(line, '\n'), d.filePerm()); err != nil {
+		return fmt.Errorf("unable to append to index log: %s (%s)", path, err)
+	}
+	idx.logged++
+
+	if idx.logged > len(idx.ids)+indexLogSlack {
+		return d.storeIndex(collection, idx)
+	}
+
+	return nil
+}
+
+// replayIndexLog applies the log of an index loaded from its file. A line
+// that cannot be decoded, such as one cut short by a crash, is skipped;
+// CreateIndex rebuilds an index that is out of sync.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - idx: The index.
+//
+// Returns:
+// - error: An error if the log exists but cannot be read.
+func (d *Driver) replayIndexLog(collection string, idx *index) error {
+	path := d.indexLogPath(collection, idx)
+
+	data, err := d.storage.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading file: %s (%s)", path, err)
+	}
+
+	for lineNo, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry indexLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.ID == "" {
+			d.log.Warn("Skipped invalid index log entry on line %d: %s", lineNo+1, path)
+			continue
+		}
+
+		idx.remove(entry.ID)
+		if !entry.Removed {
+			idx.ids[entry.ID] = entry.Key
+			idx.add(entry.ID, entry.Key)
+		}
+		idx.logged++
+	}
+
+	return nil
+}
+
+// newIndex creates an empty index on a field.
+func newIndex(field string) *index {
+	return &index{
+		field:  field,
+		ids:    map[string]string{},
+		values: map[string]map[string]bool{},
+	}
+}
+
+// indexKey returns the key a value is indexed under. Numbers are written
+// exactly, so two large integers never share a key.
+func indexKey(value interface{}) (string, error) {
+	normalized, err := util.Canonicalize(value)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+// changes reports whether indexing record under id would change the index.
+func (idx *index) changes(id string, record map[string]interface{}) (bool, error) {
+	old, indexed := idx.ids[id]
+
+	value, ok := util.GetField(record, idx.field)
+	if !ok {
+		return indexed, nil
+	}
+
+	key, err := indexKey(value)
+	if err != nil {
+		return false, err
+	}
+
+	return !indexed || key != old, nil
+}
+
+// set indexes record under id, replacing its previous entry.
+func (idx *index) set(id string, record map[string]interface{}) error {
+	idx.remove(id)
+
+	value, ok := util.GetField(record, idx.field)
+	if !ok {
+		return nil
+	}
+
+	key, err := indexKey(value)
+	if err != nil {
+		return err
+	}
+
+	idx.ids[id] = key
+	idx.add(id, key)
+
+	return nil
+}
+
+// duplicates reports the values held by more than one record.
+func (idx *index) duplicates() error {
+	var violations []string
+	for key, ids := range idx.values {
+		if len(ids) < 2 {
+			continue
+		}
+
+		sorted := make([]string, 0, len(ids))
+		for id := range ids {
+			sorted = append(sorted, id)
+		}
+		sort.Strings(sorted)
+
+		violations = append(violations, fmt.Sprintf("%s in records %s", key, strings.Join(sorted, ", ")))
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	sort.Strings(violations)
+
+	return fmt.Errorf("%w: field '%s' has duplicate values: %s", ErrDuplicateKey, idx.field, strings.Join(violations, "; "))
+}
+
+// add adds id to the reverse mapping of key.
+func (idx *index) add(id, key string) {
+	if idx.values[key] == nil {
+		idx.values[key] = map[string]bool{}
+	}
+	idx.values[key][id] = true
+}
+
+// remove drops the entry of id, reporting whether there was one.
+func (idx *index) remove(id string) bool {
+	key, ok := idx.ids[id]
+	if !ok {
+		return false
+	}
+
+	delete(idx.ids, id)
+	delete(idx.values[key], id)
+	if len(idx.values[key]) == 0 {
+		delete(idx.values, key)
+	}
+
+	return true
+}
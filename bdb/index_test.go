@@ -0,0 +1,213 @@
+package bdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexKeepsLargeIntegersApart(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	for id, n := range map[string]int64{"a": 1 << 53, "b": 1<<53 + 1} {
+		if err := db.WriteWithID("accounts", id, map[string]interface{}{"Number": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.AddUniqueConstraint("accounts", "Number"); err != nil {
+		t.Fatalf("AddUniqueConstraint: %s", err)
+	}
+
+	lookups := map[interface{}][]string{
+		int64(1<<53 + 1):                  {"b"},
+		json.Number("9007199254740992"):   {"a"},
+		json.Number("9007199254740992.0"): {"a"},
+	}
+	for value, want := range lookups {
+		ids, err := db.FindByIndex("accounts", "Number", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("FindByIndex(%v) = %v, want %v", value, ids, want)
+		}
+	}
+
+	err := db.WriteWithID("accounts", "c", map[string]interface{}{"Number": int64(1<<53 + 1)})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("WriteWithID of a duplicate: got %v, want ErrDuplicateKey", err)
+	}
+	if err := db.WriteWithID("accounts", "c", map[string]interface{}{"Number": int64(1<<53 + 2)}); err != nil {
+		t.Errorf("WriteWithID of a distinct value: %s", err)
+	}
+}
+
+func TestIndexChangesAreLogged(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Address: testAddress{City: "Pune"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("users", "Address.City"); err != nil {
+		t.Fatal(err)
+	}
+
+	indexPath := filepath.Join(dir, indexDir, "users", "Address.City.json")
+	before, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.WriteWithID("users", "b", testUser{Name: "Jane", Address: testAddress{City: "Pune"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("users", "a", map[string]interface{}{"Address": map[string]interface{}{"City": "Goa"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("users", "c", testUser{Name: "Joe", Address: testAddress{City: "Goa"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("users", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, err := os.ReadFile(indexPath); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("index file rewritten by a write: %s, %v", after, err)
+	}
+
+	want := map[string][]string{"Pune": {"b"}, "Goa": {"a"}}
+	check := func(db *Driver) {
+		t.Helper()
+		for city, ids := range want {
+			got, err := db.FindByIndex("users", "Address.City", city)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, ids) {
+				t.Errorf("FindByIndex(%s) = %v, want %v", city, got, ids)
+			}
+		}
+	}
+	check(db)
+
+	db.Close()
+	check(openTestDriver(t, dir, nil))
+}
+
+func TestIndexLogIsFolded(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("counters", "a", map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("counters", "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	const updates = indexLogSlack + 10
+	for i := 1; i <= updates; i++ {
+		if err := db.Update("counters", "a", map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs, err := filepath.Glob(filepath.Join(dir, indexDir, "counters", "n.*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("index logs = %v, want a single one", logs)
+	}
+	data, err := os.ReadFile(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := bytes.Count(data, []byte("\n")); entries >= indexLogSlack {
+		t.Errorf("index log holds %d entries after folding", entries)
+	}
+
+	db.Close()
+	db = openTestDriver(t, dir, nil)
+	if ids, err := db.FindByIndex("counters", "n", updates); err != nil || !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("FindByIndex = %v, %v; want [a]", ids, err)
+	}
+}
+
+// BenchmarkIndexedWrite writes records to indexed collections of growing
+// size; the cost of keeping the index up to date does not grow with it.
+func BenchmarkIndexedWrite(b *testing.B) {
+	for _, records := range []int{100, 10000} {
+		db := newTestDriver(b, nil)
+		for i := 0; i < records; i++ {
+			if err := db.WriteWithID("users", fmt.Sprint(i), testUser{Address: testAddress{City: fmt.Sprint("city", i)}}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := db.CreateIndex("users", "Address.City"); err != nil {
+			b.Fatal(err)
+		}
+
+		next := 0
+		b.Run(fmt.Sprint(records), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				next++
+				user := testUser{Address: testAddress{City: fmt.Sprint("new", next)}}
+				if err := db.WriteWithID("users", fmt.Sprint("new", next), user); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFindByIndex looks up the records of a city among 10k records,
+// through an index and by scanning the collection with Query.
+func BenchmarkFindByIndex(b *testing.B) {
+	const records, cities = 10000, 100
+
+	db := newTestDriver(b, nil)
+	for i := 0; i < records; i++ {
+		user := testUser{Name: fmt.Sprint("user", i), Address: testAddress{City: fmt.Sprint("city", i%cities)}}
+		if err := db.WriteWithID("users", fmt.Sprint(i), user); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := db.CreateIndex("users", "Address.City"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ids, err := db.FindByIndex("users", "Address.City", "city7")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(ids) != records/cities {
+				b.Fatalf("found %d records, want %d", len(ids), records/cities)
+			}
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			found, err := db.Query("users", func(record map[string]interface{}) bool {
+				address, _ := record["Address"].(map[string]interface{})
+				return address["City"] == "city7"
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(found) != records/cities {
+				b.Fatalf("found %d records, want %d", len(found), records/cities)
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package bdb
+
+import "path/filepath"
+
+// Iterator is a cursor over the records of a collection, in ID order.
+//
+// The collection is listed when the iterator is created and records are
+// read lazily, one per call to Next. No file is kept open between calls,
+// so an iterator can be abandoned at any point without being closed.
+//
+// A typical loop looks like:
+//
+//	it, err := db.Iterate("users")
+//	if err != nil {
+//		return err
+//	}
+//	for it.Next() {
+//		fmt.Println(it.ID(), string(it.Record()))
+//	}
+//	return it.Err()
+type Iterator struct {
+	driver     *Driver
+	collection string
+	dir        string
+	names      []string
+	pos        int
+
+	id     string
+	record []byte
+	err    error
+}
+
+// Iterate returns an iterator over the records of a collection.
+//
+// Soft-deleted and expired records are skipped, and so are records
+// removed after the collection was listed; records added after it are not
+// visited.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - *Iterator: The iterator, positioned before the first record.
+// - error: An error if the collection does not exist or cannot be listed.
+func (d *Driver) Iterate(collection string) (*Iterator, error) {
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	dir, names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator{driver: d, collection: collection, dir: dir, names: names}, nil
+}
+
+// Next advances the iterator to the next record.
+//
+// Returns:
+// - bool: False once there are no more records or a record cannot be read, see Err.
+func (it *Iterator) Next() bool {
+	it.id, it.record = "", nil
+
+	for it.err == nil && it.pos < len(it.names) {
+		name := it.names[it.pos]
+		it.pos++
+
+		id := it.driver.recordID(it.collection, name)
+
+		bytes, ok, err := it.driver.readVisible(it.collection, id, filepath.Join(it.dir, name))
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if ok {
+			it.id, it.record = id, bytes
+			return true
+		}
+	}
+
+	return false
+}
+
+// Seek moves the iterator past the record with the given ID, so the next
+// call to Next returns the record following it. This resumes iteration
+// from an ID returned by an earlier iterator.
+//
+// Parameters:
+// - id: The ID of the last record seen.
+func (it *Iterator) Seek(id string) {
+	it.pos = len(it.names)
+
+	for i, name := range it.names {
+		if it.driver.recordID(it.collection, name) > id {
+			it.pos = i
+			return
+		}
+	}
+}
+
+// ID returns the ID of the current record.
+//
+// Returns:
+// - string: The ID, empty before the first call to Next or once it returned false.
+func (it *Iterator) ID() string {
+	return it.id
+}
+
+// Record returns the encoded content of the current record.
+//
+// Returns:
+// - []byte: The record, nil before the first call to Next or once it returned false.
+func (it *Iterator) Record() []byte {
+	return it.record
+}
+
+// Err returns the error that stopped the iteration, if any.
+//
+// Returns:
+// - error: The error, nil if the iteration ran to the end or is still in progress.
+func (it *Iterator) Err() error {
+	return it.err
+}
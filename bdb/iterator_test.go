@@ -0,0 +1,141 @@
+package bdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// iterate collects the IDs an iterator visits.
+func iterate(it *Iterator) []string {
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.ID())
+	}
+	return ids
+}
+
+func TestIterate(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	for _, id := range []string{"c", "a", "b", "d"} {
+		if err := db.WriteWithID("users", id, testUser{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SoftDelete("users", "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := db.Iterate("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records removed after the listing are skipped.
+	if err := db.Delete("users", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := iterate(it); !reflect.DeepEqual(ids, []string{"a", "c"}) {
+		t.Errorf("visited %v, want [a c]", ids)
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+	if it.Next() {
+		t.Error("Next is true after the last record")
+	}
+}
+
+func TestIterateEmptyCollection(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, nil)
+
+	if _, err := db.Iterate("users"); err == nil {
+		t.Error("Iterate of a missing collection: got nil error")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "users"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	it, err := db.Iterate("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := iterate(it); len(ids) != 0 || it.Err() != nil {
+		t.Errorf("visited %v with error %v, want nothing", ids, it.Err())
+	}
+}
+
+func TestIterateStopsAtReadError(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, nil)
+
+	for _, id := range []string{"a", "c"} {
+		if err := db.WriteWithID("users", id, testUser{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A compressed record that is not valid gzip cannot be read.
+	if err := os.WriteFile(filepath.Join(dir, "users", "b.json"+gzipExt), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := db.Iterate("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := iterate(it); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("visited %v, want [a]", ids)
+	}
+	if it.Err() == nil {
+		t.Error("Err = nil after a read error")
+	}
+	if it.Next() {
+		t.Error("Next is true after a read error")
+	}
+}
+
+func ExampleDriver_Iterate() {
+	dir, err := os.MkdirTemp("", "bdb")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := New(dir, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	users := map[string]string{"u1": "John", "u2": "Jane"}
+	for id, name := range users {
+		if err := db.WriteWithID("users", id, map[string]string{"name": name}); err != nil {
+			panic(err)
+		}
+	}
+
+	it, err := db.Iterate("users")
+	if err != nil {
+		panic(err)
+	}
+	for it.Next() {
+		var user struct{ Name string }
+		if err := json.Unmarshal(it.Record(), &user); err != nil {
+			panic(err)
+		}
+		fmt.Println(it.ID(), user.Name)
+	}
+	if err := it.Err(); err != nil {
+		panic(err)
+	}
+
+	// Output:
+	// u1 John
+	// u2 Jane
+}
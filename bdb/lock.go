@@ -0,0 +1,264 @@
+package bdb
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// LockGranularity selects what the driver locks while writing a record.
+type LockGranularity int
+
+const (
+	// LockCollection serializes all writes to a collection. It is the
+	// default.
+	LockCollection LockGranularity = iota
+	// LockRecord serializes writes to the same record only, so writes to
+	// different records of a collection proceed concurrently.
+	LockRecord
+)
+
+// recordStripes is the number of mutexes records are spread over when
+// Options.LockGranularity is LockRecord.
+const recordStripes = 64
+
+// lockRecord locks a single record for writing.
+//
+// With LockRecord, the record is locked through one of the striped
+// mutexes, keyed by collection and ID. Collections with a unique
+// constraint or a quota are still locked as a whole, as checking the
+// constraint or the quota and writing the record must not interleave with
+// writes to other records.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+//
+// Returns:
+// - func(): Releases the lock.
+func (d *Driver) lockRecord(collection, id string) func() {
+	if d.granularity != LockRecord || d.hasUniqueIndex(collection) || d.hasQuota(collection) {
+		return d.lockCollection(collection)
+	}
+
+	unlockLog := d.lockChangeLog(collection)
+
+	stripe := d.stripe(collection, id)
+	stripe.Lock()
+
+	return func() {
+		stripe.Unlock()
+		unlockLog()
+	}
+}
+
+// rlockRecord locks a single record for reading, so it is not read while
+// it is being written.
+//
+// The collection is read-locked too, which keeps collection-wide changes,
+// such as DropCollection, out; with LockRecord, the record is additionally
+// read-locked through its striped mutex.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+//
+// Returns:
+// - func(): Releases the lock.
+func (d *Driver) rlockRecord(collection, id string) func() {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.RLock()
+
+	if d.granularity != LockRecord {
+		return mutex.RUnlock
+	}
+
+	stripe := d.stripe(collection, id)
+	stripe.RLock()
+
+	return func() {
+		stripe.RUnlock()
+		mutex.RUnlock()
+	}
+}
+
+// lockCollection locks a whole collection for writing.
+//
+// With LockRecord, every striped mutex is taken as well, in order, so no
+// record of any collection is being written while the lock is held.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - func(): Releases the lock.
+func (d *Driver) lockCollection(collection string) func() {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+
+	if d.granularity != LockRecord {
+		return mutex.Unlock
+	}
+
+	unlockLog := d.lockChangeLog(collection)
+
+	for i := range d.stripes {
+		d.stripes[i].Lock()
+	}
+
+	return func() {
+		for i := range d.stripes {
+			d.stripes[i].Unlock()
+		}
+		unlockLog()
+		mutex.Unlock()
+	}
+}
+
+// lockCollections locks several collections for writing, like
+// lockCollection. The collections are locked in name order, so two calls
+// locking the same collections cannot deadlock; duplicates are locked once.
+//
+// Parameters:
+// - collections: The names of the collections.
+//
+// Returns:
+// - func(): Releases the locks.
+func (d *Driver) lockCollections(collections ...string) func() {
+	names := append([]string(nil), collections...)
+	sort.Strings(names)
+
+	var mutexes []*sync.RWMutex
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+
+		mutex := d.getOrCreateMutex(name)
+		mutex.Lock()
+		mutexes = append(mutexes, mutex)
+	}
+
+	unlockLog := func() {}
+	if d.granularity == LockRecord {
+		unlockLog = d.lockChangeLog(names...)
+		for i := range d.stripes {
+			d.stripes[i].Lock()
+		}
+	}
+
+	return func() {
+		if d.granularity == LockRecord {
+			for i := range d.stripes {
+				d.stripes[i].Unlock()
+			}
+		}
+		unlockLog()
+		for _, mutex := range mutexes {
+			mutex.Unlock()
+		}
+	}
+}
+
+// rlockCollection locks a whole collection for reading. Readers do not
+// block each other, only writers.
+//
+// With LockRecord, writes to single records do not take the collection
+// lock, so they may proceed while the collection is read-locked; each
+// record is still written atomically, so it is read either before or after
+// the change.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - func(): Releases the lock.
+func (d *Driver) rlockCollection(collection string) func() {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.RLock()
+
+	return mutex.RUnlock
+}
+
+// lockChangeLog locks the change logs of collections, if
+// Options.EnableChangeLog is set with LockRecord. Writes to different
+// records then hold it from the change to the append, so the log lists the
+// changes in the order they were applied; with LockCollection, the
+// collection lock already does.
+//
+// The logs are guarded by striped mutexes, keyed by collection, taken in
+// order after the collection mutexes and before the record stripes.
+//
+// Parameters:
+// - collections: The names of the collections.
+//
+// Returns:
+// - func(): Releases the lock.
+func (d *Driver) lockChangeLog(collections ...string) func() {
+	if !d.changeLog || d.granularity != LockRecord {
+		return func() {}
+	}
+
+	var indexes []int
+	for _, collection := range collections {
+		h := fnv.New32a()
+		h.Write([]byte(collection))
+		indexes = append(indexes, int(h.Sum32()%recordStripes))
+	}
+	sort.Ints(indexes)
+
+	var locked []*sync.Mutex
+	for i, index := range indexes {
+		if i > 0 && index == indexes[i-1] {
+			continue
+		}
+		d.logStripes[index].Lock()
+		locked = append(locked, &d.logStripes[index])
+	}
+
+	return func() {
+		for _, mutex := range locked {
+			mutex.Unlock()
+		}
+	}
+}
+
+// stripe returns the striped mutex of a record.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+//
+// Returns:
+// - *sync.RWMutex: The mutex guarding the record.
+func (d *Driver) stripe(collection, id string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(collection + "/" + id))
+
+	return &d.stripes[h.Sum32()%recordStripes]
+}
+
+// hasUniqueIndex reports whether a collection has a unique constraint.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: True if one of its indexes is unique, or if they cannot be loaded.
+func (d *Driver) hasUniqueIndex(collection string) bool {
+	d.indexes.mutex.Lock()
+	defer d.indexes.mutex.Unlock()
+
+	loaded, err := d.loadIndexes(collection)
+	if err != nil {
+		return true
+	}
+
+	for _, idx := range loaded {
+		if idx.unique {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package bdb
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkConcurrentWrites writes distinct records from parallel
+// goroutines with both lock granularities.
+func BenchmarkConcurrentWrites(b *testing.B) {
+	for _, bc := range []struct {
+		name        string
+		granularity LockGranularity
+	}{{"collection", LockCollection}, {"record", LockRecord}} {
+		b.Run(bc.name, func(b *testing.B) {
+			// The seed record creates the collection directory up front.
+			db := newTestDriver(b, &Options{LockGranularity: bc.granularity})
+			if err := db.WriteWithID("users", "seed", testUser{}); err != nil {
+				b.Fatal(err)
+			}
+
+			var next int64
+			user := testUser{Name: "John", Age: "30"}
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := strconv.FormatInt(atomic.AddInt64(&next, 1), 10)
+					if err := db.WriteWithID("users", id, user); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package bdb
+
+import "fmt"
+
+// LogLevel selects which messages of the driver are logged.
+type LogLevel int32
+
+const (
+	// LogSilent logs nothing.
+	LogSilent LogLevel = iota - 3
+	// LogError logs errors only.
+	LogError
+	// LogWarn logs errors and warnings.
+	LogWarn
+	// LogInfo logs errors, warnings and informational messages. It is the
+	// default.
+	LogInfo
+	// LogDebug logs every message, including the details of each
+	// operation.
+	LogDebug
+)
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	switch l {
+	case LogSilent:
+		return "Silent"
+	case LogError:
+		return "Error"
+	case LogWarn:
+		return "Warn"
+	case LogInfo:
+		return "Info"
+	case LogDebug:
+		return "Debug"
+	}
+
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// SetLogLevel changes which messages the driver logs, like
+// Options.LogLevel. It is safe to call while the driver is in use.
+//
+// Parameters:
+// - level: The new level.
+func (d *Driver) SetLogLevel(level LogLevel) {
+	d.logLevel.Store(int32(level))
+}
+
+// leveledLogger passes the messages of the driver at or below its level to
+// the configured Logger.
+type leveledLogger struct {
+	driver *Driver
+	logger Logger
+}
+
+// enabled reports whether messages of a level are logged.
+func (l leveledLogger) enabled(level LogLevel) bool {
+	return level <= LogLevel(l.driver.logLevel.Load())
+}
+
+// Fatal logs a message at the error level.
+func (l leveledLogger) Fatal(format string, args ...interface{}) {
+	if l.enabled(LogError) {
+		l.logger.Fatal(format, args...)
+	}
+}
+
+// Error logs a message at the error level.
+func (l leveledLogger) Error(format string, args ...interface{}) {
+	if l.enabled(LogError) {
+		l.logger.Error(format, args...)
+	}
+}
+
+// Warn logs a message at the warning level.
+func (l leveledLogger) Warn(format string, args ...interface{}) {
+	if l.enabled(LogWarn) {
+		l.logger.Warn(format, args...)
+	}
+}
+
+// Info logs a message at the informational level.
+func (l leveledLogger) Info(format string, args ...interface{}) {
+	if l.enabled(LogInfo) {
+		l.logger.Info(format, args...)
+	}
+}
+
+// Trace logs a message at the debug level.
+func (l leveledLogger) Trace(format string, args ...interface{}) {
+	if l.enabled(LogDebug) {
+		l.logger.Trace(format, args...)
+	}
+}
+
+// Debug logs a message at the debug level.
+func (l leveledLogger) Debug(format string, args ...interface{}) {
+	if l.enabled(LogDebug) {
+		l.logger.Debug(format, args...)
+	}
+}
@@ -1,12 +1,22 @@
 package bdb
 
 import (
+	"context"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/babu10103/bdb/util"
 	"github.com/jcelliott/lumber"
@@ -14,10 +24,56 @@ import (
 
 type (
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		mutex     sync.Mutex
+		mutexes   map[string]*sync.RWMutex
+		dir       string
+		log       Logger
+		newID     func() string
+		codec     Codec
+		jsonCodec JSONCodec
+		compress  bool
+		storage   Storage
+		aead      cipher.AEAD
+		key       []byte
+		watchers  watchers
+		stats     stats
+		cache     *cache
+		schemas   schemas
+		configs   configs
+		indexes   indexes
+
+		validators    map[string]func(map[string]interface{}) error
+		timestamps    bool
+		preserveOrder bool
+		readOnly      bool
+		arrayMerge    ArrayMergeStrategy
+		shardDepth    int
+		readWorkers   int
+		maxRecord     int64
+		checksum      bool
+		dryRun        bool
+		strictInsert  bool
+		changeLog     bool
+		fileMode      os.FileMode
+		dirMode       os.FileMode
+
+		// stripes are the record mutexes used with LockRecord, and
+		// logStripes the mutexes keeping the change log of a collection in
+		// the order its records are written.
+		stripes     [recordStripes]sync.RWMutex
+		logStripes  [recordStripes]sync.Mutex
+		granularity LockGranularity
+
+		// lockFile holds the advisory lock on the directory, and refs
+		// counts the New calls sharing the driver; both are guarded by
+		// openMutex.
+		lockFile *os.File
+		refs     int
+
+		closed  atomic.Bool
+		closers closers
+
+		logLevel atomic.Int32
 	}
 	Logger interface {
 		Fatal(string, ...interface{})
@@ -31,9 +87,264 @@ type (
 
 type Options struct {
 	Logger
+
+	// Codec serializes records. Defaults to JSONCodec.
+	Codec Codec
+	// Compact stores JSON records without whitespace, as with
+	// JSONCodec.Compact. It applies to Options.Codec if it is a JSONCodec
+	// and to collections configured with the "json" codec.
+	Compact bool
+	// Indent sets the indentation of JSON records, as with
+	// JSONCodec.Indent; it may only hold spaces and tabs. Defaults to a
+	// tab. Like Compact, it applies to every JSONCodec of the driver.
+	Indent string
+
+	// Compress gzip-compresses new records ("<id>.json.gz"). Records are
+	// read in either form, so collections may mix plain and compressed
+	// records.
+	Compress bool
+
+	// EncryptionKey enables AES-GCM encryption of records at rest. It must
+	// be 32 bytes long for AES-256.
+	EncryptionKey []byte
+
+	// CacheSize is the number of records kept in an in-memory LRU cache
+	// serving Read. Zero disables caching. Changes made to the files by
+	// other processes are not seen until the record is evicted or changed
+	// through this driver.
+	CacheSize int
+
+	// Validators are called, keyed by collection, before a record is
+	// persisted by Write, Update or Replace. The validator receives the
+	// fully decoded record, including its "_id" field, and a non-nil error
+	// aborts the operation without writing anything. Validators run after
+	// the collection's schema, if any, and while holding the collection
+	// mutex, so they must not call back into the driver for the same
+	// collection.
+	Validators map[string]func(map[string]interface{}) error
+
+	// Timestamps stamps "_created_at" on records written by Write and
+	// "_updated_at" on records changed by Update or Replace, as RFC 3339
+	// strings. Records without timestamps are read as usual.
+	Timestamps bool
+
+	// EnableChangeLog appends every successful Write, Update, Replace and
+	// Delete to a "_changelog" file in the collection directory, one JSON
+	// line per change (see ReadChangeLog), in the order the changes were
+	// applied. Lines are encrypted if an EncryptionKey is set. With
+	// LockRecord, writes to different records of a collection then take
+	// turns to append to the log, while other collections are unaffected.
+	EnableChangeLog bool
+
+	// FileMode is the permission of the files the driver creates. It is
+	// applied as is, regardless of the process umask. Defaults to 0644,
+	// subject to the umask.
+	FileMode os.FileMode
+
+	// DirMode is the permission of the directories the driver creates. It
+	// is applied as is, regardless of the process umask, to the database
+	// directory and the collection directories. Defaults to 0755, subject
+	// to the umask.
+	DirMode os.FileMode
+	// LockGranularity selects whether writes lock the whole collection
+	// (LockCollection, the default) or only the record being written
+	// (LockRecord), which lets writes to different records of a collection
+	// run concurrently.
+	LockGranularity LockGranularity
+	// Storage is the file system the database is stored in. Defaults to
+	// DiskStorage; NewMemStorage returns one that keeps the database in
+	// memory, and the bdbafero package one backed by an afero.Fs.
+	Storage Storage
+	// IDGenerator returns the IDs Write assigns to new records. Defaults to
+	// util.GenerateObjectId. The IDs must be unique and usable as file
+	// names, i.e. non-empty, without "/", "\", ".." or null bytes. Write
+	// tries another ID when one is already taken, up to 5 times, so a
+	// generator that keeps returning taken IDs makes Write fail.
+	IDGenerator func() string
+	// PreserveFieldOrder keeps the fields of JSON records in a stable order
+	// instead of sorting them: Write stores them in the order v marshals
+	// to, i.e. struct declaration order, and Update keeps the order found
+	// on disk, adding new fields after it. Fields the driver adds, such as
+	// "_id", come last. It costs an extra encoding pass per write and only
+	// applies to JSONCodec.
+	PreserveFieldOrder bool
+	// SkipWriteProbe disables the check New makes that the database
+	// directory is writable, by creating and removing a file in it. Set it
+	// for file systems where that is slow or has side effects.
+	SkipWriteProbe bool
+	// ArrayMergeStrategy selects how Update and UpdateWithVersion merge an
+	// array into the array already stored in a field: ArrayReplace (the
+	// default) replaces it, ArrayAppend appends to it and ArrayUnion
+	// appends the elements it does not hold yet, compared by their JSON
+	// encoding.
+	ArrayMergeStrategy ArrayMergeStrategy
+	// ShardDepth, from 0 to 4, spreads the records of a collection over
+	// that many levels of subdirectories, e.g. "<collection>/3f/<id>.json"
+	// at depth 1, so that no directory grows too large for the file
+	// system. The subdirectories are named after a hash of the IDs.
+	// Defaults to 0, which stores records directly in the collection
+	// directory. Collections created with another depth must be moved
+	// with Reshard.
+	ShardDepth int
+	// ReadConcurrency is the number of record files ReadAll reads at the
+	// same time. Records are returned in the same order either way.
+	// Defaults to 1, which reads them one after the other.
+	ReadConcurrency int
+	// MaxRecordBytes rejects the records whose encoded size, before
+	// compression and encryption, exceeds that many bytes: Write, Update,
+	// Replace and the other operations storing a record return
+	// ErrRecordTooLarge without writing anything. Defaults to 0, which
+	// sets no limit.
+	MaxRecordBytes int64
+	// Checksum stores a CRC-32 checksum of every record written in its
+	// "_checksum" field, and verifies it whenever a record is read, so
+	// records corrupted on disk fail with ErrChecksumMismatch instead of
+	// being returned. Records written without a checksum are read as is.
+	Checksum bool
+	// DryRun makes Write, WriteWithID, Update, Replace, Delete and the
+	// other operations built on them, such as Upsert or Patch, go through
+	// their lookups and validation and encode the record, then log the
+	// change at Info level instead of making it: no file is created,
+	// modified or removed, and neither indexes nor watchers see it. Move,
+	// Rename, Truncate, DropCollection and the TTL reaper log the records
+	// they would create or remove the same way. Tx.Commit, Restore,
+	// Reshard and VerifyAndRepair, which cannot be previewed, return
+	// ErrDryRun.
+	DryRun bool
+	// StrictInsert makes the operations creating a record, such as Write,
+	// WriteWithID and the inserts of Upsert and ImportNDJSON, never replace
+	// a file found at the path of the new record. The driver always checks
+	// that an ID is free, under the lock of the record, but other processes
+	// writing to the directory bypass that lock: a record one of them
+	// creates before the new record is renamed into place would be
+	// overwritten. With StrictInsert, the insert fails with ErrDuplicateKey
+	// instead, atomically if the Storage implements Linker, as DiskStorage
+	// and MemStorage do.
+	StrictInsert bool
+	// LogLevel selects which messages of the driver reach the Logger.
+	// Defaults to LogInfo, which leaves out the per-operation debug
+	// messages; SetLogLevel changes it at run time.
+	LogLevel LogLevel
+	// ReadOnly opens the database for reading only: every operation that
+	// would change it, such as Write, Update, Replace or Delete, returns
+	// ErrReadOnly without touching the file system. New does not create
+	// the database directory and fails if it does not exist.
+	ReadOnly bool
+}
+
+// maxIDAttempts is the number of IDs Write generates before giving up on
+// finding one that is not taken.
+const maxIDAttempts = 5
+
+// ErrDuplicateKey is returned when a record with the requested ID already
+// exists.
+var ErrDuplicateKey = errors.New("record already exists")
+
+// ErrCollectionMissing is returned when an operation is called without a
+// collection name.
+var ErrCollectionMissing = errors.New("missing collection")
+
+// ErrResourceMissing is returned when an operation is called without a
+// resource name.
+var ErrResourceMissing = errors.New("missing resource")
+
+// ErrNotFound is returned when a collection or record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrReadOnly is returned by operations that would change a database
+// opened with Options.ReadOnly, or a collection configured as read-only.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrNotAPointer is returned when a value to read a record into is not a
+// non-nil pointer, as the record could not be stored in it.
+var ErrNotAPointer = errors.New("target must be a non-nil pointer")
+
+// ErrRecordTooLarge is returned when an encoded record exceeds
+// Options.MaxRecordBytes.
+var ErrRecordTooLarge = errors.New("record too large")
+
+// ErrFieldMissing is returned by ReadField when a record has no value at
+// the requested path.
+var ErrFieldMissing = errors.New("field missing")
+
+// lookupError describes a failure to find a collection or record. Only a
+// missing file wraps ErrNotFound; other failures, e.g. a permission error,
+// are reported as they are.
+//
+// Parameters:
+// - kind: What was looked up, "collection" or "resource".
+// - path: The path that was looked up.
+// - err: The stat error.
+//
+// Returns:
+// - error: The error to return to the caller.
+func lookupError(kind, path string, err error) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s %s (%s)", ErrNotFound, kind, path, err)
+	}
+
+	return fmt.Errorf("unable to find %s: %s (%s)", kind, path, err)
+}
+
+// ErrInvalidName is returned when a collection or resource name could
+// escape the database directory, or a collection name is reserved.
+var ErrInvalidName = errors.New("invalid name")
+
+// validateName checks that a collection or resource name is a single path
+// element, so it cannot be used to reach files outside the database
+// directory.
+//
+// Parameters:
+// - name: The name to check.
+//
+// Returns:
+// - error: ErrInvalidName if the name is empty or contains "/", "\", ".." or a null byte.
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidName)
+	}
+
+	if strings.ContainsAny(name, "/\\\x00") || strings.Contains(name, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+// validateCollection checks a collection name like validateName, and that
+// it is not one of the directories the driver keeps its own files in.
+//
+// Parameters:
+// - name: The collection name to check.
+//
+// Returns:
+// - error: ErrInvalidName if the name is invalid or reserved.
+func validateCollection(name string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	if reservedCollection(name) {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+// reservedCollection reports whether name is one of the "_schema", "_index"
+// and "_corrupt" directories the driver keeps its own files in.
+//
+// Parameters:
+// - name: The name to check.
+//
+// Returns:
+// - bool: True if the name is reserved.
+func reservedCollection(name string) bool {
+	return name == schemaDir || name == indexDir || name == corruptDir
 }
 
-// New creates a new database driver.
+// open creates a database driver for New, creating the database directory
+// if needed and locking it.
 //
 // Parameters:
 // - dir: The directory where the database is stored.
@@ -41,8 +352,8 @@ type Options struct {
 //
 // Returns:
 // - *Driver: The newly created database driver.
-// - error: An error if the database cannot be created.
-func New(dir string, options *Options) (*Driver, error) {
+// - error: An error if the database cannot be created or is locked.
+func open(dir string, options *Options) (*Driver, error) {
 	dir = filepath.Clean(dir)
 
 	opts := Options{}
@@ -53,277 +364,2409 @@ func New(dir string, options *Options) (*Driver, error) {
 	if opts.Logger == nil {
 		opts.Logger = lumber.NewConsoleLogger((lumber.INFO))
 	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
+
+	// The JSON format of the options applies to Options.Codec as well as
+	// to collections configured with the "json" codec.
+	jsonCodec := JSONCodec{Indent: opts.Indent, Compact: opts.Compact}
+	if codec, ok := opts.Codec.(JSONCodec); ok {
+		if jsonCodec.Indent == "" {
+			jsonCodec.Indent = codec.Indent
+		}
+		jsonCodec.Compact = jsonCodec.Compact || codec.Compact
+		opts.Codec = jsonCodec
+	}
+
+	if strings.Trim(jsonCodec.Indent, " \t") != "" {
+		return nil, fmt.Errorf("invalid indent: %q", jsonCodec.Indent)
+	}
+
+	if opts.MaxRecordBytes < 0 {
+		return nil, fmt.Errorf("invalid max record size: %d", opts.MaxRecordBytes)
+	}
+
+	if opts.ShardDepth < 0 || opts.ShardDepth > maxShardDepth {
+		return nil, fmt.Errorf("invalid shard depth: %d", opts.ShardDepth)
+	}
+
+	var aead cipher.AEAD
+	if opts.EncryptionKey != nil {
+		var err error
+		if aead, err = newAEAD(opts.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
 
 	driver := Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+		dir:       dir,
+		mutexes:   make(map[string]*sync.RWMutex),
+		newID:     util.GenerateObjectId,
+		codec:     opts.Codec,
+		jsonCodec: jsonCodec,
+		compress:  opts.Compress,
+		storage:   opts.Storage,
+		aead:      aead,
+		key:       opts.EncryptionKey,
+		cache:     newCache(opts.CacheSize),
+
+		validators:    make(map[string]func(map[string]interface{}) error, len(opts.Validators)),
+		timestamps:    opts.Timestamps,
+		preserveOrder: opts.PreserveFieldOrder,
+		readOnly:      opts.ReadOnly,
+		arrayMerge:    opts.ArrayMergeStrategy,
+		shardDepth:    opts.ShardDepth,
+		readWorkers:   opts.ReadConcurrency,
+		maxRecord:     opts.MaxRecordBytes,
+		checksum:      opts.Checksum,
+		dryRun:        opts.DryRun,
+		strictInsert:  opts.StrictInsert,
+		changeLog:     opts.EnableChangeLog,
+		fileMode:      opts.FileMode,
+		dirMode:       opts.DirMode,
+
+		granularity: opts.LockGranularity,
+	}
+
+	driver.log = leveledLogger{driver: &driver, logger: opts.Logger}
+	driver.logLevel.Store(int32(opts.LogLevel))
+
+	if driver.storage == nil {
+		driver.storage = DiskStorage{}
+	}
+	if opts.IDGenerator != nil {
+		driver.newID = opts.IDGenerator
+	}
+
+	for collection, validator := range opts.Validators {
+		driver.validators[collection] = validator
+	}
+
+	if _, err := driver.storage.Stat(dir); err == nil {
+		driver.log.Debug("Using '%s' (database already exists)\n", dir)
+	} else if opts.ReadOnly {
+		return nil, lookupError("database", dir, err)
+	} else {
+		driver.log.Debug("Creating the database at '%s'...\n", dir)
+		if err := driver.mkdirAll(dir); err != nil {
+			return &driver, err
+		}
+	}
+
+	// The probe runs first, so a read-only directory is reported as such
+	// rather than as a failure to create the lock file.
+	if !opts.SkipWriteProbe && !opts.ReadOnly {
+		if err := driver.probe(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := driver.lockDir(); err != nil {
+		return nil, err
+	}
+
+	return &driver, nil
+}
+
+// probe checks that the database directory is writable by creating and
+// removing a file in it, so a read-only or inaccessible directory is
+// reported by New rather than by the first write.
+//
+// Returns:
+// - error: An error if the file cannot be created or removed.
+func (d *Driver) probe() error {
+	path := filepath.Join(d.dir, ".probe-"+util.GenerateObjectId()+".tmp")
+
+	if err := d.storage.WriteFile(path, nil, d.filePerm()); err != nil {
+		return fmt.Errorf("database directory is not writable: %s (%s)", d.dir, err)
+	}
+
+	if err := d.storage.Remove(path); err != nil {
+		return fmt.Errorf("database directory is not writable: %s (%s)", d.dir, err)
+	}
+
+	return nil
+}
+
+// getOrCreateMutex returns a mutex for the specified collection.
+//
+// The mutex is used to ensure that only one goroutine at a time
+// writes to a collection, while any number of goroutines may read it.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - *sync.RWMutex: The mutex for the collection.
+func (d *Driver) getOrCreateMutex(collection string) *sync.RWMutex {
+	// Lock the mutex to ensure that only one goroutine at a
+	// time can access the map.
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Attempt to get the mutex for the collection from the map.
+	m, ok := d.mutexes[collection]
+
+	// If the mutex does not exist, create a new mutex and add
+	// it to the map.
+	if !ok {
+		m = &sync.RWMutex{}
+		d.mutexes[collection] = m
+	}
+
+	return m
+
+}
+
+// Dir returns the directory the database is stored in, cleaned.
+//
+// Returns:
+// - string: The database directory.
+func (d *Driver) Dir() string {
+	return d.dir
+}
+
+// Path returns the path of the file holding a record: the file of the
+// record if it exists, plain or compressed, or else the file a new record
+// would be written to, with the extension of the codec of the collection
+// and the gzip extension if it is compressed.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+//
+// Returns:
+// - string: The path of the record file.
+func (d *Driver) Path(collection, resource string) string {
+	if path, err := d.locateRecord(collection, resource); err == nil {
+		return path
+	}
+
+	return d.recordPath(collection, resource)
+}
+
+// ReleaseCollection forgets the mutex of a collection.
+//
+// A mutex is created for every collection the driver touches and is kept
+// for its lifetime. Long-running processes that use many short-lived
+// collections can call ReleaseCollection once they are done with one to
+// keep the map from growing. A mutex that is currently held is left in
+// place. The caller must ensure no other operation on the collection is
+// in flight or about to start, otherwise two goroutines could end up
+// holding different mutexes for the same collection.
+//
+// Parameters:
+// - collection: The name of the collection.
+func (d *Driver) ReleaseCollection(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	m, ok := d.mutexes[collection]
+	if !ok {
+		return
+	}
+
+	if !m.TryLock() {
+		d.log.Debug("Not releasing collection '%s': it is in use", collection)
+		return
+	}
+	defer m.Unlock()
+
+	delete(d.mutexes, collection)
+}
+
+// Write writes the data to the database.
+//
+// A new object ID is generated for the record and stored in its "_id"
+// field. If the ID is already taken, a new one is generated, up to
+// maxIDAttempts times; the ID is checked and the record written under the
+// lock of the record, so Write never overwrites an existing record. To
+// insert under a chosen ID, use WriteWithID, which returns ErrDuplicateKey
+// rather than overwriting. The ID is returned as soon as the record has been
+// marshalled, even if persisting it fails, so callers can clean up. The
+// record's "_version" field starts at 1 and every change increments it
+// (see UpdateWithVersion).
+//
+// Parameters:
+// - collection: The name of the collection to write to.
+// - v: The data to write.
+//
+// Returns:
+// - string: The ID of the new record.
+// - error: An error if the write operation fails.
+func (d *Driver) Write(collection string, v interface{}) (string, error) {
+	return d.WriteContext(context.Background(), collection, v)
+}
+
+// WriteContext is like Write but aborts with ctx.Err() if the context is
+// done before the lock is taken or before the record is
+// persisted.
+func (d *Driver) WriteContext(ctx context.Context, collection string, v interface{}) (id string, err error) {
+	defer d.stats.observe(statWrite, collection, time.Now(), &err)
+
+	data, err := util.ToMap(v)
+	if err != nil {
+		return "", err
+	}
+	util.DropDeleted(data)
+
+	return d.insert(ctx, collection, data, d.keyOrderOf(collection, v))
+}
+
+// WriteRaw writes a JSON document to the database as is, without
+// converting it through a Go value first.
+//
+// Like Write, a new ID is generated and stored in the "_id" field, and the
+// record is stored with the configured codec, i.e. re-indented.
+//
+// Parameters:
+// - collection: The name of the collection to write to.
+// - raw: The JSON document, which must be an object.
+//
+// Returns:
+// - string: The ID of the new record.
+// - error: An error if raw is not a JSON object or the write operation fails.
+func (d *Driver) WriteRaw(collection string, raw json.RawMessage) (id string, err error) {
+	defer d.stats.observe(statWrite, collection, time.Now(), &err)
+
+	data, err := util.DecodeMap(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid json object: %s", err)
+	}
+	util.DropDeleted(data)
+
+	return d.insert(context.Background(), collection, data, d.keyOrderOf(collection, raw))
+}
+
+// insert stores a new record under a generated ID.
+//
+// Parameters:
+// - ctx: The context of the operation.
+// - collection: The name of the collection to write to.
+// - data: The record, which gets the "_id" and "_version" fields.
+// - order: The key order of the record, nil for alphabetical order.
+//
+// Returns:
+// - string: The ID of the new record, once it has been generated.
+// - error: An error if the write operation fails.
+func (d *Driver) insert(ctx context.Context, collection string, data map[string]interface{}, order *keyOrder) (string, error) {
+	if err := d.checkWritable(collection); err != nil {
+		return "", err
+	}
+
+	if collection == "" {
+		return "", fmt.Errorf("%w - no place to save records", ErrCollectionMissing)
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	id, unlock, err := d.uniqueID(collection)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if err := d.checkQuota(collection); err != nil {
+		return "", err
+	}
+
+	data["_id"] = id
+	data[versionField] = 1
+	d.stampCreated(data)
+	d.stampExpiry(collection, data)
+
+	if err := d.validate(collection, data); err != nil {
+		return "", err
+	}
+
+	if err := d.checkUnique(collection, id, data, nil); err != nil {
+		return "", err
+	}
+
+	bytes, err := d.marshalRecord(collection, data, order)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return id, err
+	}
+
+	if d.skipChange(OpCreate, collection, id, bytes) {
+		return id, nil
+	}
+
+	if err := d.mkdirAll(filepath.Join(d.dir, collection)); err != nil {
+		return id, err
+	}
+
+	if err := d.createRecord(collection, d.recordPath(collection, id), bytes); err != nil {
+		return id, err
+	}
+
+	d.stats.addBytes(collection, 0, len(bytes))
+
+	d.reindex(collection, id, data)
+	d.cache.invalidate(collection, id)
+	d.logChange(OpCreate, collection, id, data)
+	d.notify(OpCreate, collection, id)
+
+	return id, nil
+}
+
+// WriteWithID writes the data to the database under a caller-supplied ID.
+//
+// This allows natural keys, such as an email address or SKU, to be used as
+// the record name. The ID is stored in the record's "_id" field.
+//
+// Parameters:
+// - collection: The name of the collection to write to.
+// - id: The ID of the record to write.
+// - v: The data to write.
+//
+// Returns:
+// - error: ErrDuplicateKey if a record with the ID already exists, or an
+// error if the ID is invalid or the write operation fails.
+func (d *Driver) WriteWithID(collection, id string, v interface{}) (err error) {
+	defer d.stats.observe(statWrite, collection, time.Now(), &err)
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	if collection == "" {
+		return fmt.Errorf("%w - no place to save records", ErrCollectionMissing)
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	if id == "" {
+		return fmt.Errorf("%w - unable to save record (no id)", ErrResourceMissing)
+	}
+
+	if err := validateName(id); err != nil {
+		return err
+	}
+
+	unlock := d.lockRecord(collection, id)
+	defer unlock()
+
+	return d.writeWithID(collection, id, v, false)
+}
+
+// writeWithID writes a new record with the given ID, for callers holding
+// the lock of the record.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+// - v: The record.
+// - overwrite: Whether to write over the file of a hidden record, found by the caller.
+//
+// Returns:
+// - error: ErrDuplicateKey if the record exists, or an error if it cannot be written.
+func (d *Driver) writeWithID(collection, id string, v interface{}, overwrite bool) error {
+	data, err := util.ToMap(v)
+	if err != nil {
+		return err
+	}
+	util.DropDeleted(data)
+	data["_id"] = id
+	data[versionField] = 1
+	d.stampCreated(data)
+	d.stampExpiry(collection, data)
+
+	if err := d.validate(collection, data); err != nil {
+		return err
+	}
+
+	if err := d.checkUnique(collection, id, data, nil); err != nil {
+		return err
+	}
+
+	path, err := d.locateRecord(collection, id)
+	found := err == nil
+	if found && !overwrite {
+		return fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+	} else if !found && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to check resource: %s (%s)", path, err)
+	}
+
+	// A hidden record being overwritten already counts for the quota.
+	if !found {
+		if err := d.checkQuota(collection); err != nil {
+			return err
+		}
+	}
+
+	bytes, err := d.marshalRecord(collection, data, d.keyOrderOf(collection, v))
+	if err != nil {
+		return err
+	}
+
+	if d.skipChange(OpCreate, collection, id, bytes) {
+		return nil
+	}
+
+	if err := d.mkdirAll(filepath.Join(d.dir, collection)); err != nil {
+		return err
+	}
+
+	if found {
+		err = d.writeRecord(collection, path, bytes)
+	} else {
+		err = d.createRecord(collection, d.recordPath(collection, id), bytes)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.stats.addBytes(collection, 0, len(bytes))
+
+	d.reindex(collection, id, data)
+	d.cache.invalidate(collection, id)
+	d.logChange(OpCreate, collection, id, data)
+	d.notify(OpCreate, collection, id)
+
+	return nil
+}
+
+// uniqueID generates an object ID that is not yet used in a collection and
+// locks it for writing, so it cannot be taken before the record is written.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - string: The new ID.
+// - func(): Releases the lock on the ID.
+// - error: An error if no free ID was found within maxIDAttempts.
+func (d *Driver) uniqueID(collection string) (string, func(), error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id := d.newID()
+		if err := validateName(id); err != nil {
+			return "", nil, fmt.Errorf("invalid generated id: %w", err)
+		}
+
+		unlock := d.lockRecord(collection, id)
+
+		path, err := d.locateRecord(collection, id)
+		if os.IsNotExist(err) {
+			return id, unlock, nil
+		}
+		unlock()
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to check resource: %s (%s)", path, err)
+		}
+
+		d.log.Warn("Object ID collision on '%s', generating a new one", id)
+	}
+
+	return "", nil, fmt.Errorf("unable to generate a unique id after %d attempts", maxIDAttempts)
+}
+
+// isRecordFile reports whether a directory entry is a stored record.
+//
+// Only files with the codec's extension, optionally followed by the gzip
+// extension, are records; temporary files ("<id>.json.tmp") and
+// directories are not.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - entry: The directory entry to check.
+//
+// Returns:
+// - bool: True if the entry is a record file.
+func (d *Driver) isRecordFile(collection string, entry os.DirEntry) bool {
+	return !entry.IsDir() && d.isRecordName(collection, entry.Name())
+}
+
+// isRecordName reports whether a filename is the name of a record file.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - name: The filename to check.
+//
+// Returns:
+// - bool: True if the name has the codec's extension, optionally gzipped.
+func (d *Driver) isRecordName(collection, name string) bool {
+	ext := d.codecFor(collection).Ext()
+
+	return strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+gzipExt)
+}
+
+// recordID returns the ID of a record from its filename.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - name: The filename of the record, e.g. "<id>.json.gz", optionally within its shard directory.
+//
+// Returns:
+// - string: The ID of the record.
+func (d *Driver) recordID(collection, name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), gzipExt), d.codecFor(collection).Ext())
+}
+
+// recordNames lists the record files of a collection sorted by filename.
+//
+// In sharded collections, the names include the shard directories of the
+// records, e.g. "3f/<id>.json", and are still sorted by filename, i.e. by
+// ID. Files outside the shard directory of their ID are ignored.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - string: The path of the collection directory.
+// - []string: The sorted record filenames, relative to the collection directory.
+// - error: An error if the collection does not exist or cannot be listed.
+func (d *Driver) recordNames(collection string) (string, []string, error) {
+	if err := d.checkOpen(); err != nil {
+		return "", nil, err
+	}
+
+	if collection == "" {
+		return "", nil, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return "", nil, err
+	}
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	if _, err := d.stat(collectionPath, d.codecFor(collection).Ext()); err != nil {
+		return "", nil, lookupError("collection", collectionPath, err)
+	}
+
+	depth := d.shardDepthFor(collection)
+
+	var names []string
+	err := d.walkShards(collectionPath, depth, func(name string, entry os.DirEntry) {
+		if d.isRecordFile(collection, entry) && filepath.Dir(name) == filepath.Join(".", shardDir(d.recordID(collection, name), depth)) {
+			names = append(names, name)
+		}
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return filepath.Base(names[i]) < filepath.Base(names[j])
+	})
+
+	return collectionPath, names, nil
+}
+
+// recordPath returns the path a new record is stored at, following the
+// configured codec and compression.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+//
+// Returns:
+// - string: The path of the record file.
+func (d *Driver) recordPath(collection, resource string) string {
+	ext := d.codecFor(collection).Ext()
+	if d.compressFor(collection) {
+		ext += gzipExt
+	}
+
+	return filepath.Join(d.dir, collection, shardDir(resource, d.shardDepthFor(collection)), resource+ext)
+}
+
+// locateRecord returns the path of an existing record, whether it is stored
+// plain or compressed.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+//
+// Returns:
+// - string: The path of the record file, or the plain path if it is missing.
+// - error: The stat error, satisfying os.IsNotExist if the record is missing.
+func (d *Driver) locateRecord(collection, resource string) (string, error) {
+	path := filepath.Join(d.dir, collection, shardDir(resource, d.shardDepthFor(collection)), resource+d.codecFor(collection).Ext())
+
+	fi, err := d.stat(path, gzipExt)
+	if err != nil {
+		return path, err
+	}
+
+	if strings.HasSuffix(fi.Name(), gzipExt) {
+		path += gzipExt
+	}
+
+	return path, nil
+}
+
+// readRecord reads a record file, decrypting and decompressing it if
+// needed.
+//
+// Parameters:
+// - path: The path of the record file.
+//
+// Returns:
+// - []byte: The encoded record.
+// - error: An error if the file cannot be read, decrypted or decompressed.
+func (d *Driver) readRecord(path string) ([]byte, error) {
+	bytes, err := d.storage.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isEncrypted(bytes) {
+		if bytes, err = decrypt(d.aead, bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.HasSuffix(path, gzipExt) {
+		return decompress(bytes)
+	}
+
+	return bytes, nil
+}
+
+// writeRecord atomically writes an encoded record, compressing it if path
+// has the gzip extension and encrypting it if a key is configured.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - path: The path of the record file.
+// - bytes: The encoded record.
+//
+// Returns:
+// - error: An error if the record cannot be written.
+func (d *Driver) writeRecord(collection, path string, bytes []byte) error {
+	return d.putRecord(collection, path, bytes, false)
+}
+
+// createRecord writes a new record like writeRecord. With
+// Options.StrictInsert, an existing file at path is never replaced.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - path: The path of the record file.
+// - bytes: The encoded record.
+//
+// Returns:
+// - error: ErrDuplicateKey if the record exists in strict mode, or an error if it cannot be written.
+func (d *Driver) createRecord(collection, path string, bytes []byte) error {
+	return d.putRecord(collection, path, bytes, d.strictInsert)
+}
+
+// putRecord compresses and encrypts a record as configured and writes it
+// to path, replacing an existing file unless exclusive is set.
+func (d *Driver) putRecord(collection, path string, bytes []byte, exclusive bool) error {
+	if strings.HasSuffix(path, gzipExt) {
+		compressed, err := compress(bytes)
+		if err != nil {
+			return err
+		}
+		bytes = compressed
+	}
+
+	if d.encryptFor(collection) {
+		encrypted, err := encrypt(d.aead, bytes)
+		if err != nil {
+			return err
+		}
+		bytes = encrypted
+	}
+
+	if d.shardDepthFor(collection) > 0 {
+		if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+
+	d.watchers.markSelfChange(path)
+
+	if exclusive {
+		return d.createFileAtomic(path, bytes)
+	}
+
+	return d.writeFileAtomic(path, bytes)
+}
+
+// writeFileAtomic writes the bytes to a temporary file next to path and
+// renames it into place, so readers never observe a partially written
+// record.
+//
+// Parameters:
+// - path: The final path of the file.
+// - bytes: The content to write.
+//
+// Returns:
+// - error: An error if the write or rename fails.
+func (d *Driver) writeFileAtomic(path string, bytes []byte) error {
+	tempPath, err := d.writeTempFile(path, bytes)
+	if err != nil {
+		return err
+	}
+
+	return d.storage.Rename(tempPath, path)
+}
+
+// createFileAtomic is like writeFileAtomic but fails rather than replace
+// an existing file. With a Storage implementing Linker, the temporary file
+// is linked into place, which checks and creates the file in one step;
+// otherwise the file is checked for right before the rename.
+//
+// Parameters:
+// - path: The final path of the file.
+// - bytes: The content to write.
+//
+// Returns:
+// - error: ErrDuplicateKey if the file exists, or an error if the write fails.
+func (d *Driver) createFileAtomic(path string, bytes []byte) error {
+	tempPath, err := d.writeTempFile(path, bytes)
+	if err != nil {
+		return err
+	}
+
+	if linker, ok := d.storage.(Linker); ok {
+		err := linker.Link(tempPath, path)
+		d.storage.Remove(tempPath)
+		if os.IsExist(err) {
+			return fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+		}
+		return err
+	}
+
+	if _, err := d.storage.Stat(path); err == nil {
+		d.storage.Remove(tempPath)
+		return fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+	}
+
+	return d.storage.Rename(tempPath, path)
+}
+
+// writeTempFile writes the bytes to the temporary file of path, with the
+// configured file mode.
+//
+// Parameters:
+// - path: The final path of the file.
+// - bytes: The content to write.
+//
+// Returns:
+// - string: The path of the temporary file.
+// - error: An error if the file cannot be written.
+func (d *Driver) writeTempFile(path string, bytes []byte) (string, error) {
+	tempPath := path + ".tmp"
+	if err := d.storage.WriteFile(tempPath, bytes, d.filePerm()); err != nil {
+		return "", err
+	}
+
+	// WriteFile only applies the mode to new files, minus the umask.
+	if d.fileMode != 0 {
+		if err := d.storage.Chmod(tempPath, d.fileMode); err != nil {
+			return "", err
+		}
+	}
+
+	return tempPath, nil
+}
+
+// mkdirAll creates a directory and any missing parents with the configured
+// directory mode.
+//
+// Parameters:
+// - path: The path of the directory.
+//
+// Returns:
+// - error: An error if the directory cannot be created.
+func (d *Driver) mkdirAll(path string) error {
+	if fi, err := d.storage.Stat(path); err == nil && fi.IsDir() {
+		return nil
+	}
+
+	mode := d.dirMode
+	if mode == 0 {
+		mode = 0755
+	}
+
+	if err := d.storage.MkdirAll(path, mode); err != nil {
+		return err
+	}
+
+	if d.dirMode != 0 {
+		return d.storage.Chmod(path, d.dirMode)
+	}
+
+	return nil
+}
+
+// filePerm returns the mode new files are created with.
+func (d *Driver) filePerm() os.FileMode {
+	if d.fileMode != 0 {
+		return d.fileMode
+	}
+
+	return 0644
+}
+
+// Read retrieves a record from the database.
+//
+// Parameters:
+// - collection: The name of the collection to read from.
+// - resource: The name of the resource to read.
+// - v: A non-nil pointer to unmarshal the record into.
+//
+// Returns:
+// - error: ErrNotAPointer if v is not a non-nil pointer, or an error if the read operation fails.
+func (d *Driver) Read(collection, resource string, v interface{}) error {
+	return d.ReadContext(context.Background(), collection, resource, v)
+}
+
+// ReadContext is like Read but aborts with ctx.Err() if the context is
+// done before the record is read.
+func (d *Driver) ReadContext(ctx context.Context, collection, resource string, v interface{}) (err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	if err := checkPointer(v); err != nil {
+		return err
+	}
+
+	bytes, err := d.readBytes(ctx, collection, resource)
+	if err != nil {
+		return err
+	}
+
+	if err := d.codecFor(collection).Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("error unmarshalling json: %s", err)
+	}
+
+	d.log.Debug("Unmarshalled record: %+v", v)
+
+	return nil
+}
+
+// ReadField retrieves the value of a single field of a record, without
+// unmarshalling the record into a Go value.
+//
+// Numbers of JSON records are returned as json.Number, which keeps large
+// integers and decimals exact; objects as map[string]interface{} and arrays
+// as []interface{}.
+//
+// Parameters:
+// - collection: The name of the collection to read from.
+// - resource: The name of the resource to read.
+// - field: The field, or dotted path into nested objects (e.g. "Address.City").
+//
+// Returns:
+// - interface{}: The value of the field, nil if it holds null.
+// - error: ErrFieldMissing if the record has no such field, or an error if the record cannot be read.
+func (d *Driver) ReadField(collection, resource, field string) (value interface{}, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	if field == "" {
+		return nil, fmt.Errorf("missing field")
+	}
+
+	bytes, err := d.readBytes(context.Background(), collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := d.decodeRecord(collection, bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling json: %w", err)
+	}
+
+	value, ok := util.GetField(record, field)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFieldMissing, field)
+	}
+
+	return value, nil
+}
+
+// ReadProjected retrieves a subset of the fields of a record, like a
+// MongoDB projection, and unmarshals it into out.
+//
+// Requested fields the record does not have are left out, so the matching
+// fields of out keep their value. "_id" is only included if requested.
+//
+// Parameters:
+// - collection: The name of the collection to read from.
+// - resource: The name of the resource to read.
+// - fields: The fields to keep, as dotted paths for nested fields (e.g. "Address.City").
+// - out: A non-nil pointer to unmarshal the fields into.
+//
+// Returns:
+// - error: ErrNotAPointer if out is not a non-nil pointer, or an error if the read operation fails.
+func (d *Driver) ReadProjected(collection, resource string, fields []string, out interface{}) (err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	if err := checkPointer(out); err != nil {
+		return err
+	}
+
+	bytes, err := d.readBytes(context.Background(), collection, resource)
+	if err != nil {
+		return err
+	}
+
+	record, err := d.decodeRecord(collection, bytes)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling json: %w", err)
+	}
+
+	projected := map[string]interface{}{}
+	for _, field := range fields {
+		if value, ok := util.GetField(record, field); ok {
+			if err := util.SetField(projected, field, value); err != nil {
+				return fmt.Errorf("invalid projection: %s (%s)", field, err)
+			}
+		}
+	}
+
+	codec := d.codecFor(collection)
+
+	bytes, err = codec.Marshal(projected)
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %s", err)
+	}
+
+	if err := codec.Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("error unmarshalling json: %s", err)
+	}
+
+	return nil
+}
+
+// checkPointer checks that a value to read into is a non-nil pointer.
+//
+// Parameters:
+// - v: The value.
+//
+// Returns:
+// - error: ErrNotAPointer, naming the type of v, or nil.
+func checkPointer(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w, got %T", ErrNotAPointer, v)
+	}
+
+	return nil
+}
+
+// ReadRaw retrieves a record from the database as a JSON document, without
+// decoding it into a Go value. Records stored with another codec are
+// converted to JSON.
+//
+// Parameters:
+// - collection: The name of the collection to read from.
+// - resource: The name of the resource to read.
+//
+// Returns:
+// - json.RawMessage: The record.
+// - error: An error if the record cannot be found or read.
+func (d *Driver) ReadRaw(collection, resource string) (raw json.RawMessage, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	bytes, err := d.readBytes(context.Background(), collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bytes may be shared with the cache, so the caller gets a copy.
+	if _, ok := d.codecFor(collection).(JSONCodec); ok {
+		return append(json.RawMessage(nil), bytes...), nil
+	}
+
+	var v interface{}
+	if err := d.codecFor(collection).Unmarshal(bytes, &v); err != nil {
+		return nil, fmt.Errorf("error unmarshalling json: %s", err)
+	}
+
+	return json.Marshal(v)
+}
+
+// readBytes retrieves the encoded content of a visible record, from the
+// cache if possible.
+//
+// Parameters:
+// - ctx: The context of the operation.
+// - collection: The name of the collection to read from.
+// - resource: The name of the resource to read.
+//
+// Returns:
+// - []byte: The encoded record.
+// - error: An error if the record is missing, hidden or cannot be read.
+func (d *Driver) readBytes(ctx context.Context, collection, resource string) ([]byte, error) {
+	d.log.Debug("Reading record: %s from collection: %s", resource, collection)
+
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	if collection == "" {
+		return nil, fmt.Errorf("%w - unable to read!", ErrCollectionMissing)
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	if resource == "" {
+		return nil, fmt.Errorf("%w - unable to read record (no name)!", ErrResourceMissing)
+	}
+
+	if err := validateName(resource); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Cache hits are served without taking a lock or touching the disk.
+	bytes, ok := d.cache.get(collection, resource)
+	if !ok {
+		epoch := d.cache.begin()
+
+		unlock := d.rlockRecord(collection, resource)
+		defer unlock()
+
+		resourcePath, err := d.locateRecord(collection, resource)
+		if err != nil {
+			return nil, lookupError("resource", resourcePath, err)
+		}
+
+		d.log.Debug("Reading record: %s from path: %s", resource, resourcePath)
+
+		if bytes, err = d.readRecord(resourcePath); err != nil {
+			return nil, fmt.Errorf("error reading file: %s (%s)", resourcePath, err)
+		}
+
+		if err := d.checkRecord(collection, resourcePath, bytes); err != nil {
+			return nil, err
+		}
+
+		d.stats.addBytes(collection, len(bytes), 0)
+
+		d.cache.put(collection, resource, bytes, epoch)
+	}
+
+	d.log.Debug("Read bytes from file: %s", string(bytes))
+
+	if d.isHidden(collection, bytes, false) {
+		return nil, fmt.Errorf("%w: resource %s/%s (record is deleted or expired)", ErrNotFound, collection, resource)
+	}
+
+	return bytes, nil
+}
+
+// ReadAll retrieves all the records from the specified collection.
+//
+// Only record files are read; temporary files left by in-progress or
+// crashed writes are skipped, and so are soft-deleted and expired records.
+// Records are returned sorted by filename, i.e. by ID.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - []string: The list of records.
+// - error: An error if the operation fails.
+func (d *Driver) ReadAll(collection string) ([]string, error) {
+	return d.ReadAllContext(context.Background(), collection)
+}
+
+// ReadAllContext is like ReadAll but aborts with ctx.Err() if the context
+// is done before or while the records are read.
+func (d *Driver) ReadAllContext(ctx context.Context, collection string) (records []string, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	return d.readAll(ctx, collection, false)
+}
+
+// readAll reads the records of a collection sorted by filename.
+//
+// Parameters:
+// - ctx: The context of the operation.
+// - collection: The name of the collection.
+// - includeDeleted: Whether soft-deleted records are included.
+//
+// Returns:
+// - []string: The list of records.
+// - error: An error if the operation fails.
+func (d *Driver) readAll(ctx context.Context, collection string, includeDeleted bool) ([]string, error) {
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(name string) (string, bool, error) {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return "", false, fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		if err := d.checkRecord(collection, path, bytes); err != nil {
+			return "", false, err
+		}
+
+		d.stats.addBytes(collection, len(bytes), 0)
+
+		return string(bytes), !d.isHidden(collection, bytes, includeDeleted), nil
+	}
+
+	if d.readWorkers > 1 && len(names) > 1 {
+		return d.readConcurrently(ctx, names, read)
+	}
+
+	var records []string
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, visible, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if visible {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// readConcurrently reads records with Options.ReadConcurrency workers,
+// keeping their order. The first error stops the workers.
+//
+// Parameters:
+// - ctx: The context of the operation.
+// - names: The record filenames, in the order to return the records in.
+// - read: Reads a record, reporting whether it is visible.
+//
+// Returns:
+// - []string: The visible records.
+// - error: The first error met, or ctx.Err() if the context is done first.
+func (d *Driver) readConcurrently(ctx context.Context, names []string, read func(name string) (string, bool, error)) ([]string, error) {
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	records := make([]string, len(names))
+	visible := make([]bool, len(names))
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan int)
+
+	workers := d.readWorkers
+	if workers > len(names) {
+		workers = len(names)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				record, ok, err := read(names[i])
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				records[i], visible[i] = record, ok
+			}
+		}()
+	}
+
+feed:
+	for i := range names {
+		select {
+		case jobs <- i:
+		case <-workCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for i, record := range records {
+		if visible[i] {
+			result = append(result, record)
+		}
+	}
+
+	return result, nil
+}
+
+// ReadAllInto retrieves all the records from the specified collection and
+// unmarshals them into out.
+//
+// Only record files are read; temporary files left by in-progress writes,
+// soft-deleted and expired records are skipped. Records are appended
+// sorted by ID.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - out: A non-nil pointer to a slice, e.g. *[]User.
+//
+// Returns:
+// - error: ErrNotAPointer if out is not a non-nil pointer, or an error if it does not point to a slice or the operation fails.
+func (d *Driver) ReadAllInto(collection string, out interface{}) (err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	if err := checkPointer(out); err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(out)
+	if rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("out must be a non-nil pointer to a slice, got %T", out)
+	}
+
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		if err := d.checkRecord(collection, path, bytes); err != nil {
+			return err
+		}
+
+		d.stats.addBytes(collection, len(bytes), 0)
+
+		if d.isHidden(collection, bytes, false) {
+			continue
+		}
+
+		elem := reflect.New(elemType)
+		if err := d.codecFor(collection).Unmarshal(bytes, elem.Interface()); err != nil {
+			return fmt.Errorf("error unmarshalling json: %s (%s)", path, err)
+		}
+
+		slice = reflect.Append(slice, elem.Elem())
+	}
+
+	rv.Elem().Set(slice)
+
+	return nil
+}
+
+// ReadAllPaged retrieves one page of records from the specified collection.
+//
+// Records are ordered by filename so consecutive pages neither overlap nor
+// skip records. Soft-deleted and expired records are left out of the page
+// they fall in, so a page may hold fewer than limit records.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - offset: The number of records to skip.
+// - limit: The maximum number of records to return, 0 for no limit.
+//
+// Returns:
+// - []string: The records of the page, empty if offset is past the end.
+// - error: An error if the operation fails.
+func (d *Driver) ReadAllPaged(collection string, offset, limit int) (records []string, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset >= len(names) {
+		return []string{}, nil
+	}
+
+	names = names[offset:]
+	if limit > 0 && limit < len(names) {
+		names = names[:limit]
+	}
+
+	records = make([]string, 0, len(names))
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		if err := d.checkRecord(collection, path, bytes); err != nil {
+			return nil, err
+		}
+
+		d.stats.addBytes(collection, len(bytes), 0)
+
+		if d.isHidden(collection, bytes, false) {
+			continue
+		}
+
+		records = append(records, string(bytes))
+	}
+
+	return records, nil
+}
+
+// ForEach calls fn for every record of a collection, one at a time, in ID
+// order, so collections of any size are processed in constant memory.
+//
+// Only record files are visited; soft-deleted and expired records are
+// skipped, and so are records removed after the collection was listed. No
+// lock is held while fn runs, so fn may write to the collection. Iteration
+// stops at the first error returned by fn, which is returned by ForEach.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - fn: Called with the ID and encoded content of each record. It must not retain raw after returning, as the slice may be reused.
+//
+// Returns:
+// - error: The error returned by fn, or an error if the collection cannot be read.
+func (d *Driver) ForEach(collection string, fn func(id string, raw []byte) error) (err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	unlock := d.rlockCollection(collection)
+	collectionPath, names, err := d.recordNames(collection)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		id := d.recordID(collection, name)
+
+		bytes, ok, err := d.readVisible(collection, id, filepath.Join(collectionPath, name))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := fn(id, bytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Walk calls fn for every record of every collection, one at a time, like
+// ForEach does for a single collection, so the whole database is visited
+// in constant memory.
+//
+// Collections are visited in name order, as listed by Collections, which
+// leaves out the reserved directories. Collections dropped during the
+// walk are skipped. Iteration stops at the first error returned by fn,
+// which is returned by Walk.
+//
+// Parameters:
+// - fn: Called with the collection, ID and encoded content of each record. It must not retain raw after returning.
+//
+// Returns:
+// - error: The error returned by fn, or an error if a collection cannot be read.
+func (d *Driver) Walk(fn func(collection, id string, raw []byte) error) error {
+	collections, err := d.Collections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		err := d.ForEach(collection, func(id string, raw []byte) error {
+			return fn(collection, id, raw)
+		})
+		if errors.Is(err, ErrNotFound) {
+			if exists, _ := d.CollectionExists(collection); !exists {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readVisible reads a record under its read lock, for callers iterating a
+// collection listed earlier.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - id: The ID of the record.
+// - path: The path of the record file.
+//
+// Returns:
+// - []byte: The encoded record.
+// - bool: False if the record has been removed since, or is soft-deleted or expired.
+// - error: An error if the record cannot be read.
+func (d *Driver) readVisible(collection, id, path string) ([]byte, bool, error) {
+	unlock := d.rlockRecord(collection, id)
+	defer unlock()
+
+	bytes, err := d.readRecord(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading file: %s (%s)", path, err)
+	}
+
+	if err := d.checkRecord(collection, path, bytes); err != nil {
+		return nil, false, err
+	}
+
+	d.stats.addBytes(collection, len(bytes), 0)
+
+	return bytes, !d.isHidden(collection, bytes, false), nil
+}
+
+// Count returns the number of records in the specified collection without
+// reading them. Soft-deleted and expired records are counted, as their
+// files remain.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - int: The number of records.
+// - error: An error if the collection does not exist or cannot be listed.
+func (d *Driver) Count(collection string) (int, error) {
+	if err := d.checkOpen(); err != nil {
+		return 0, err
+	}
+
+	if collection == "" {
+		return 0, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock := d.rlockCollection(collection)
+	defer unlock()
+
+	_, names, err := d.recordNames(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names), nil
+}
+
+// Exists reports whether a record exists in the database. Like for Read,
+// a soft-deleted or expired record does not exist, even if its file is
+// still on disk.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to check.
+//
+// Returns:
+// - bool: True if the record exists.
+// - error: An error if existence cannot be determined (e.g. permission denied).
+func (d *Driver) Exists(collection, resource string) (bool, error) {
+	if err := d.checkOpen(); err != nil {
+		return false, err
+	}
+
+	if collection == "" {
+		return false, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return false, err
+	}
+
+	if resource == "" {
+		return false, ErrResourceMissing
+	}
+
+	if err := validateName(resource); err != nil {
+		return false, err
+	}
+
+	unlock := d.rlockRecord(collection, resource)
+	defer unlock()
+
+	resourcePath, err := d.locateRecord(collection, resource)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to check resource: %s (%s)", resourcePath, err)
+	}
+
+	bytes, err := d.readRecord(resourcePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, ErrEncrypted) {
+		return false, fmt.Errorf("error reading file: %s (%s)", resourcePath, err)
+	}
+
+	return err != nil || !d.isHidden(collection, bytes, false), nil
+}
+
+// CollectionExists reports whether a collection exists in the database.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: True if the collection exists.
+// - error: An error if existence cannot be determined (e.g. permission denied).
+func (d *Driver) CollectionExists(collection string) (bool, error) {
+	if err := d.checkOpen(); err != nil {
+		return false, err
+	}
+
+	if collection == "" {
+		return false, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return false, err
+	}
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	fi, err := d.storage.Stat(collectionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to check collection: %s (%s)", collectionPath, err)
+	}
+
+	return fi.IsDir(), nil
+}
+
+// Collections lists the names of all collections in the database.
+//
+// Regular files, hidden entries (names starting with ".") and the reserved
+// "_schema", "_index" and "_corrupt" directories are skipped.
+//
+// Returns:
+// - []string: The collection names, empty for a new database.
+// - error: An error if the database directory cannot be read.
+func (d *Driver) Collections() ([]string, error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	entries, err := d.storage.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory: %s (%s)", d.dir, err)
+	}
+
+	collections := []string{}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || reservedCollection(entry.Name()) {
+			continue
+		}
+
+		collections = append(collections, entry.Name())
+	}
+
+	return collections, nil
+}
+
+// Delete removes a record from the database.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to delete.
+//
+// Returns:
+// - error: An error if the delete operation fails.
+func (d *Driver) Delete(collection, resource string) error {
+	return d.DeleteContext(context.Background(), collection, resource)
+}
+
+// DeleteExisting removes a record like Delete, but reports a missing
+// record by returning false rather than an error wrapping ErrNotFound.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to delete.
+//
+// Returns:
+// - bool: True if the record existed and was removed.
+// - error: An error if the delete operation fails.
+func (d *Driver) DeleteExisting(collection, resource string) (bool, error) {
+	err := d.DeleteContext(context.Background(), collection, resource)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// DeleteContext is like Delete but aborts with ctx.Err() if the context is
+// done before the lock is taken or before the record is
+// removed.
+func (d *Driver) DeleteContext(ctx context.Context, collection, resource string) (err error) {
+	defer d.stats.observe(statDelete, collection, time.Now(), &err)
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	if collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	if resource == "" {
+		return ErrResourceMissing
+	}
+
+	if err := validateName(resource); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := d.lockRecord(collection, resource)
+	defer unlock()
+
+	resourcePath := filepath.Join(d.dir, collection, resource)
+
+	// A resource may also be a nested directory rather than a record,
+	// unless the collection is sharded, as it may then be a shard.
+	if fi, err := d.storage.Stat(resourcePath); err == nil && fi.Mode().IsDir() && d.shardDepthFor(collection) == 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.skipChange(OpDelete, collection, resource, nil) {
+			return nil
+		}
+		if err := d.storage.RemoveAll(resourcePath); err != nil {
+			return err
+		}
+		d.cache.invalidate(collection, resource)
+		d.logChange(OpDelete, collection, resource, nil)
+		d.notify(OpDelete, collection, resource)
+		return nil
+	}
+
+	recordPath, err := d.locateRecord(collection, resource)
+	if err != nil {
+		return lookupError("resource", recordPath, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if d.skipChange(OpDelete, collection, resource, nil) {
+		return nil
+	}
+
+	d.watchers.markSelfChange(recordPath)
+
+	if err := d.storage.Remove(recordPath); err != nil {
+		return err
 	}
 
-	if _, err := os.Stat(dir); err == nil {
-		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
-		return &driver, nil
-	}
+	d.reindex(collection, resource, nil)
+	d.cache.invalidate(collection, resource)
+	d.logChange(OpDelete, collection, resource, nil)
+	d.notify(OpDelete, collection, resource)
 
-	opts.Logger.Debug("Creating the database at '%s'...\n", dir)
-	return &driver, os.MkdirAll(dir, 0755)
+	return nil
 }
 
-// getOrCreateMutex returns a mutex for the specified collection.
+// DropCollection removes a collection and all of its records.
 //
-// The mutex is used to ensure that only one goroutine at a time
-// writes to a collection.
+// The collection's mutex is forgotten as well, so a collection created
+// later under the same name starts clean. As with ReleaseCollection, the
+// caller must ensure no other operation on the collection is in flight.
 //
 // Parameters:
-// - collection: The name of the collection.
+// - collection: The name of the collection to drop.
 //
 // Returns:
-// - *sync.Mutex: The mutex for the collection.
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
-	// Lock the mutex to ensure that only one goroutine at a
-	// time can access the map.
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+// - error: An error if the collection does not exist or cannot be removed.
+func (d *Driver) DropCollection(collection string) error {
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
 
-	// Attempt to get the mutex for the collection from the map.
-	m, ok := d.mutexes[collection]
+	if collection == "" {
+		return ErrCollectionMissing
+	}
 
-	// If the mutex does not exist, create a new mutex and add
-	// it to the map.
-	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+	if err := validateCollection(collection); err != nil {
+		return err
 	}
 
-	return m
+	if d.dryRun {
+		return d.skipRecords(collection)
+	}
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	// Runs before the deferred unlock, so the mutex is forgotten while it
+	// is still held.
+	defer func() {
+		d.mutex.Lock()
+		delete(d.mutexes, collection)
+		d.mutex.Unlock()
+	}()
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	fi, err := d.storage.Stat(collectionPath)
+	if err != nil {
+		return lookupError("collection", collectionPath, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("not a collection: %s", collectionPath)
+	}
+
+	if err := d.storage.RemoveAll(collectionPath); err != nil {
+		return fmt.Errorf("unable to remove collection: %s (%s)", collectionPath, err)
+	}
 
+	if err := d.dropIndexes(collection); err != nil {
+		return err
+	}
+
+	d.forgetConfig(collection)
+	d.cache.invalidateCollection(collection)
+
+	return nil
 }
 
-// Write writes the data to the database.
+// Truncate removes every record of a collection but keeps the collection.
+//
+// Temporary files left by interrupted writes are removed as well. A delete
+// event is sent for each removed record.
 //
 // Parameters:
-// - collection: The name of the collection to write to.
-// - resource: The name of the resource to write.
-// - v: The data to write.
+// - collection: The name of the collection to empty.
 //
 // Returns:
-// - error: An error if the write operation fails.
-func (d *Driver) Write(collection string, v interface{}) error {
-	if collection == "" {
-		return fmt.Errorf("Missing collection - no place to save records")
+// - error: An error if the collection does not exist or a file cannot be
+// removed.
+func (d *Driver) Truncate(collection string) error {
+	if err := d.checkWritable(collection); err != nil {
+		return err
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	if collection == "" {
+		return ErrCollectionMissing
+	}
 
-	dir := filepath.Join(d.dir, collection)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := validateCollection(collection); err != nil {
 		return err
 	}
 
-	data, err := util.ToMap(v)
+	if d.dryRun {
+		return d.skipRecords(collection)
+	}
 
-	id := util.GenerateObjectId()
-	data["_id"] = id
+	unlock := d.lockCollection(collection)
+	defer unlock()
 
-	if err != nil {
-		return err
+	collectionPath := filepath.Join(d.dir, collection)
+
+	if _, err := d.storage.Stat(collectionPath); err != nil {
+		return lookupError("collection", collectionPath, err)
 	}
 
-	bytes, err := json.MarshalIndent(data, "", "\t")
+	var names []string
+	err := d.walkShards(collectionPath, d.shardDepthFor(collection), func(name string, entry os.DirEntry) {
+		if !entry.IsDir() && d.isRecordName(collection, strings.TrimSuffix(entry.Name(), ".tmp")) {
+			names = append(names, name)
+		}
+	})
 	if err != nil {
 		return err
 	}
 
-	bytes = append(bytes, byte('\n'))
+	defer d.cache.invalidateCollection(collection)
+	defer d.clearIndexes(collection)
 
-	tempPath := filepath.Join(dir, id+".json.tmp")
-	if err := os.WriteFile(tempPath, bytes, 0644); err != nil {
-		return err
-	}
-	finalPath := filepath.Join(dir, id+".json")
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		return err
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		d.watchers.markSelfChange(path)
+
+		if err := d.storage.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove file: %s (%s)", path, err)
+		}
+
+		if d.isRecordName(collection, name) {
+			d.logChange(OpDelete, collection, d.recordID(collection, name), nil)
+			d.notify(OpDelete, collection, d.recordID(collection, name))
+		}
 	}
 
 	return nil
 }
 
-// Read retrieves a record from the database.
+// Update updates a record in the database.
+//
+// v is converted to a JSON object first, so its json struct tags apply,
+// and the object is merged into the record:
+//   - Fields absent from the object are never touched. This includes
+//     fields left out by "omitempty" and fields tagged "-", so a struct
+//     with "omitempty" fields makes a partial update of the fields set.
+//   - Fields present with a zero value ("", 0) or null are ignored if the
+//     record holds a value, so structs without "omitempty" do not clobber
+//     stored data; use UpdateReplace to store them. false is not ignored.
+//   - Objects are merged recursively, and arrays follow
+//     Options.ArrayMergeStrategy.
+//   - Fields set to Delete, at any depth, are removed from the record.
 //
 // Parameters:
-// - collection: The name of the collection to read from.
-// - resource: The name of the resource to read.
-// - v: The variable to unmarshal the record into.
+// - collection: The name of the collection to update.
+// - resource: The name of the resource to update.
+// - v: The data to update.
 //
 // Returns:
-// - error: An error if the read operation fails.
-func (d *Driver) Read(collection, resource string, v interface{}) error {
-	d.log.Debug("Reading record: %s from collection: %s", resource, collection)
+// - error: An error if the update operation fails.
+func (d *Driver) Update(collection, resource string, v interface{}) error {
+	return d.UpdateContext(context.Background(), collection, resource, v)
+}
+
+// UpdateContext is like Update but aborts with ctx.Err() if the context is
+// done before the lock is taken or before the record is
+// persisted.
+func (d *Driver) UpdateContext(ctx context.Context, collection, resource string, v interface{}) error {
+	return d.updateRecord(ctx, collection, resource, v, false, d.mergeUpdate)
+}
+
+// Upsert updates a record like Update if it exists, and writes it like
+// WriteWithID otherwise.
+//
+// The lock of the record is held from the existence check to the write, so
+// concurrent upserts of the same record never both create it. A
+// soft-deleted or expired record is absent, like for Read: a new record is
+// written over its file.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+// - v: The data to merge into the record, or the new record.
+//
+// Returns:
+// - bool: True if the record was created, false if it was updated.
+// - error: An error if the record cannot be read or written.
+func (d *Driver) Upsert(collection, resource string, v interface{}) (created bool, err error) {
+	start := time.Now()
+	defer func() {
+		op := statUpdate
+		if created {
+			op = statWrite
+		}
+		d.stats.observe(op, collection, start, &err)
+	}()
+
+	if err := d.checkWritable(collection); err != nil {
+		return false, err
+	}
 
 	if collection == "" {
-		return fmt.Errorf("missing collection - unable to read!")
+		return false, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return false, err
 	}
 
 	if resource == "" {
-		return fmt.Errorf("missing resource - unable to read record (no name)!")
+		return false, ErrResourceMissing
 	}
 
-	resourcePath := filepath.Join(d.dir, collection, resource+".json")
+	if err := validateName(resource); err != nil {
+		return false, err
+	}
 
-	d.log.Debug("Reading record: %s from path: %s", resource, resourcePath)
+	unlock := d.lockRecord(collection, resource)
+	defer unlock()
 
-	if _, err := util.Stat(resourcePath); err != nil {
-		return fmt.Errorf("unable to find resource: %s (%s)", resourcePath, err)
+	path, err := d.locateRecord(collection, resource)
+	if os.IsNotExist(err) {
+		if err := d.writeWithID(collection, resource, v, false); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to check resource: %s (%s)", path, err)
 	}
 
-	bytes, err := os.ReadFile(resourcePath)
+	bytes, err := d.readRecord(path)
 	if err != nil {
-		return fmt.Errorf("error reading file: %s (%s)", resourcePath, err)
+		return false, fmt.Errorf("error reading file: %s (%s)", path, err)
 	}
 
-	d.log.Debug("Read bytes from file: %s", string(bytes))
+	d.stats.addBytes(collection, len(bytes), 0)
 
-	if err := json.Unmarshal(bytes, &v); err != nil {
-		return fmt.Errorf("error unmarshalling json: %s", err)
+	// A hidden record is absent, so a new record takes its place.
+	if d.isHidden(collection, bytes, false) {
+		if err := d.writeWithID(collection, resource, v, true); err != nil {
+			return false, err
+		}
+		return true, nil
 	}
 
-	d.log.Debug("Unmarshalled record: %+v", v)
+	return false, d.applyUpdate(context.Background(), collection, resource, path, bytes, v, d.mergeUpdate)
+}
 
+// mergeUpdate merges the data of an update into a record, as Update does.
+//
+// Parameters:
+// - newData: The data of the update.
+// - existing: The decoded record, changed in place.
+//
+// Returns:
+// - error: Always nil.
+func (d *Driver) mergeUpdate(newData, existing map[string]interface{}) error {
+	util.UpdateMapWith(newData, existing, d.arrayMerge)
 	return nil
 }
 
-// ReadAll retrieves all the records from the specified collection.
+// UpdateMany merges v into every record of a collection that satisfies a
+// predicate, like Update does for a single record.
+//
+// The collection lock is held for the whole batch, so no other write
+// interleaves with it. Each record is written atomically, so a crash
+// leaves every record either updated or intact, but the batch as a whole
+// is not atomic: if a record fails to update, e.g. because it does not
+// pass validation, the records updated before it stay updated.
+// Soft-deleted and expired records are skipped.
 //
 // Parameters:
 // - collection: The name of the collection.
+// - match: The predicate deciding whether a record is updated. It receives the decoded record, including its "_id" field.
+// - v: The data to merge into each matching record.
 //
 // Returns:
-// - []string: The list of records.
-// - error: An error if the operation fails.
-func (d *Driver) ReadAll(collection string) ([]string, error) {
-	if collection == "" {
-		return nil, fmt.Errorf("missing collection")
+// - int: The number of records updated.
+// - error: An error if the collection cannot be read or a record cannot be updated.
+func (d *Driver) UpdateMany(collection string, match func(map[string]interface{}) bool, v interface{}) (updated int, err error) {
+	defer d.stats.observe(statUpdate, collection, time.Now(), &err)
+
+	if err := d.checkWritable(collection); err != nil {
+		return 0, err
 	}
 
-	collectionPath := filepath.Join(d.dir, collection)
+	if match == nil {
+		return 0, fmt.Errorf("missing match predicate")
+	}
 
-	if _, err := util.Stat(collectionPath); err != nil {
-		return nil, fmt.Errorf("unable to find collection: %s (%s)", collectionPath, err)
+	if collection == "" {
+		return 0, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return 0, err
 	}
 
-	entries, err := os.ReadDir(collectionPath)
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read directory: %s (%s)", collectionPath, err)
+		return 0, err
 	}
 
-	var records []string
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return updated, fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
 
-	for _, file := range entries {
-		path := filepath.Join(collectionPath, file.Name())
+		d.stats.addBytes(collection, len(bytes), 0)
 
-		bytes, err := os.ReadFile(path)
+		if d.isHidden(collection, bytes, false) {
+			continue
+		}
+
+		record, err := d.decodeRecord(collection, bytes)
 		if err != nil {
-			return nil, fmt.Errorf("error reading file: %s (%s)", path, err)
+			return updated, fmt.Errorf("error unmarshalling json: %s (%w)", path, err)
 		}
 
-		records = append(records, string(bytes))
+		if !match(record) {
+			continue
+		}
+
+		if err := d.applyUpdate(context.Background(), collection, d.recordID(collection, name), path, bytes, v, d.mergeUpdate); err != nil {
+			return updated, err
+		}
+
+		updated++
 	}
 
-	return records, err
+	return updated, nil
 }
 
-// Delete removes a record from the database.
+// UpdateReplace updates a record in the database, overwriting every
+// supplied field.
+//
+// Unlike Update, which merges and ignores zero values ("", 0) so that
+// partial structs do not clobber stored data, UpdateReplace assigns each
+// top-level field of v as is, which makes it possible to clear a field or
+// set it to its zero value. Fields absent from v (e.g. omitted via
+// "omitempty") are left intact. Note that every exported struct field is
+// considered supplied. Fields set to Delete are removed from the record.
+//
+// Parameters:
+// - collection: The name of the collection to update.
+// - resource: The name of the resource to update.
+// - v: The fields to replace.
+//
+// Returns:
+// - error: An error if the update operation fails.
+func (d *Driver) UpdateReplace(collection, resource string, v interface{}) error {
+	return d.updateRecord(context.Background(), collection, resource, v, false, func(newData, existing map[string]interface{}) error {
+		for k, value := range newData {
+			if value == Delete {
+				delete(existing, k)
+				continue
+			}
+			existing[k] = util.DropDeleted(value)
+		}
+		return nil
+	})
+}
+
+// Increment atomically adds delta to a numeric field of a record.
+//
+// The record is read, changed and written back while holding the lock of
+// the record, so concurrent increments are never lost, unlike a Read
+// followed by an Update. A missing field counts as 0. An integer field
+// incremented by a whole delta is added exactly, so large counters keep
+// every digit on disk even where the returned float64 is rounded.
 //
 // Parameters:
 // - collection: The name of the collection.
-// - resource: The name of the resource to delete.
+// - resource: The name of the resource.
+// - field: The field to increment, as a dotted path for nested fields (e.g. "Stats.Views").
+// - delta: The amount to add, negative to decrement.
 //
 // Returns:
-// - error: An error if the delete operation fails.
-func (d *Driver) Delete(collection, resource string) error {
+// - float64: The new value of the field.
+// - error: An error if the field exists but is not a number, or the update fails.
+func (d *Driver) Increment(collection, resource, field string, delta float64) (float64, error) {
+	if field == "" {
+		return 0, fmt.Errorf("missing field")
+	}
+
+	var value float64
+
+	err := d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, false, func(_, existing map[string]interface{}) error {
+		current, ok := util.GetField(existing, field)
+		if !ok {
+			current = json.Number("0")
+		}
+
+		if sum, ok := addInteger(current, delta); ok {
+			value, _ = sum.Float64()
+			return util.SetField(existing, field, sum)
+		}
+
+		number, ok := current.(float64)
+		if n, isNumber := current.(json.Number); isNumber {
+			var err error
+			number, err = n.Float64()
+			ok = err == nil
+		}
+		if !ok {
+			return fmt.Errorf("field '%s' is not a number: %v", field, current)
+		}
+
+		value = number + delta
+
+		return util.SetField(existing, field, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// addInteger adds delta to an integer stored as a json.Number exactly, so
+// large counters keep every digit.
+//
+// Parameters:
+// - current: The stored value.
+// - delta: The amount to add.
+//
+// Returns:
+// - json.Number: The sum.
+// - bool: False if current is not an integer json.Number or delta is not whole.
+func addInteger(current interface{}, delta float64) (json.Number, bool) {
+	n, ok := current.(json.Number)
+	if !ok || math.IsInf(delta, 0) || delta != math.Trunc(delta) {
+		return "", false
+	}
+
+	sum, ok := new(big.Int).SetString(string(n), 10)
+	if !ok {
+		return "", false
+	}
+
+	whole, _ := new(big.Float).SetFloat64(delta).Int(nil)
+
+	return json.Number(sum.Add(sum, whole).String()), true
+}
+
+// Replace replaces a record in the database with v.
+//
+// Where Update merges v into the stored record, Replace stores v as the
+// entire new document, so fields missing from v are removed. Only the
+// "_id" field, and "_created_at" if timestamps are enabled, are preserved,
+// and "_version" is incremented.
+// The record is written atomically, like Write.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to replace.
+// - v: The new document.
+//
+// Returns:
+// - error: An error if the record does not exist or the operation fails.
+func (d *Driver) Replace(collection, resource string, v interface{}) (err error) {
+	defer d.stats.observe(statUpdate, collection, time.Now(), &err)
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
 
 	if collection == "" {
-		return fmt.Errorf("missing collection")
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
 	}
 
 	if resource == "" {
-		return fmt.Errorf("missing resource")
+		return ErrResourceMissing
 	}
 
-	mutex := d.getOrCreateMutex(collection)
+	if err := validateName(resource); err != nil {
+		return err
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	unlock := d.lockRecord(collection, resource)
+	defer unlock()
 
-	resourcePath := filepath.Join(d.dir, collection, resource)
+	resourcePath, err := d.locateRecord(collection, resource)
+	if err != nil {
+		return lookupError("resource", resourcePath, err)
+	}
+
+	data, err := util.ToMap(v)
+	if err != nil {
+		return fmt.Errorf("error converting data to map: %s", err)
+	}
+	util.DropDeleted(data)
+	data["_id"] = resource
 
-	if _, err := util.Stat(resourcePath); err != nil {
-		return fmt.Errorf("unable to find resource: %s (%s)", resourcePath, err)
+	bytes, err := d.readRecord(resourcePath)
+	if err != nil {
+		return fmt.Errorf("error reading file: %s (%s)", resourcePath, err)
 	}
 
-	switch fi, err := util.Stat(resourcePath); {
+	existing, err := d.decodeRecord(collection, bytes)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling json: %w", err)
+	}
 
-	case fi == nil, err != nil:
-		return fmt.Errorf("unable to find resource: %s (%s)", resourcePath, err)
+	data[versionField] = recordVersion(existing) + 1
+	d.stampUpdated(data, existing[createdAtField])
 
-	case fi.Mode().IsDir():
-		return os.RemoveAll(resource)
+	if err := d.validate(collection, data); err != nil {
+		return err
+	}
 
-	case fi.Mode().IsRegular():
-		return os.RemoveAll(resource + ".json")
+	if err := d.checkUnique(collection, resource, data, nil); err != nil {
+		return err
+	}
 
+	bytes, err = d.marshalRecord(collection, data, d.keyOrderOf(collection, v))
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %w", err)
 	}
 
-	return nil
+	if d.skipChange(OpUpdate, collection, resource, bytes) {
+		return nil
+	}
 
-}
+	if err := d.writeRecord(collection, resourcePath, bytes); err != nil {
+		return err
+	}
 
-// Update updates a record in the database.
+	d.stats.addBytes(collection, 0, len(bytes))
 
-// Update updates a record in the database.
+	d.reindex(collection, resource, data)
+	d.cache.invalidate(collection, resource)
+	d.logChange(OpUpdate, collection, resource, data)
+	d.notify(OpUpdate, collection, resource)
+
+	return nil
+}
+
+// updateRecord reads a record, applies v to it with merge and writes the
+// result back. A hidden record is reported as missing, like by Read.
 //
 // Parameters:
+// - ctx: The context of the operation.
 // - collection: The name of the collection to update.
 // - resource: The name of the resource to update.
 // - v: The data to update.
+// - includeDeleted: Whether soft-deleted records can be updated.
+// - merge: The function applying the new data onto the existing record; an error aborts the update.
 //
 // Returns:
 // - error: An error if the update operation fails.
-func (d *Driver) Update(collection, resource string, v interface{}) error {
+func (d *Driver) updateRecord(ctx context.Context, collection, resource string, v interface{}, includeDeleted bool, merge func(newData, existing map[string]interface{}) error) (err error) {
+	defer d.stats.observe(statUpdate, collection, time.Now(), &err)
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
 	if collection == "" {
 		d.log.Debug("Collection is empty")
-		return fmt.Errorf("missing collection")
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
 	}
 
 	if resource == "" {
 		d.log.Debug("Resource is empty")
-		return fmt.Errorf("missing resource")
+		return ErrResourceMissing
 	}
 
-	mutex := d.getOrCreateMutex(collection)
+	if err := validateName(resource); err != nil {
+		return err
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	resourcePath := filepath.Join(d.dir, collection, resource+".json")
+	unlock := d.lockRecord(collection, resource)
+	defer unlock()
 
-	if _, err := util.Stat(resourcePath); err != nil {
+	resourcePath, err := d.locateRecord(collection, resource)
+	if err != nil {
 		d.log.Debug("Resource does not exist at %s (%s)", resourcePath, err)
-		return fmt.Errorf("unable to find resource: %s (%s)", resourcePath, err)
+		return lookupError("resource", resourcePath, err)
 	}
 
-	bytes, err := os.ReadFile(resourcePath)
+	bytes, err := d.readRecord(resourcePath)
 	if err != nil {
 		d.log.Debug("Error reading file: %s (%s)", resourcePath, err)
 		return fmt.Errorf("error reading file: %s (%s)", resourcePath, err)
 	}
 
-	var existing map[string]interface{}
-	if err := json.Unmarshal(bytes, &existing); err != nil {
+	d.stats.addBytes(collection, len(bytes), 0)
+
+	if d.isHidden(collection, bytes, includeDeleted) {
+		return fmt.Errorf("%w: resource %s/%s (record is deleted or expired)", ErrNotFound, collection, resource)
+	}
+
+	return d.applyUpdate(ctx, collection, resource, resourcePath, bytes, v, merge)
+}
+
+// applyUpdate merges v into a record read by the caller, which holds the
+// lock of the record, and writes it back.
+//
+// Parameters:
+// - ctx: The context of the operation.
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+// - resourcePath: The path of the record file.
+// - bytes: The encoded record.
+// - v: The data to merge.
+// - merge: Merges the data into the decoded record.
+//
+// Returns:
+// - error: An error if the record cannot be merged, validated or written.
+func (d *Driver) applyUpdate(ctx context.Context, collection, resource, resourcePath string, bytes []byte, v interface{}, merge func(newData, existing map[string]interface{}) error) error {
+	existing, err := d.decodeRecord(collection, bytes)
+	if err != nil {
 		d.log.Debug("Error unmarshalling json: %s", err)
-		return fmt.Errorf("error unmarshalling json: %s", err)
+		return fmt.Errorf("error unmarshalling json: %w", err)
 	}
 
 	newData, err := util.ToMap(v)
@@ -332,23 +2775,54 @@ func (d *Driver) Update(collection, resource string, v interface{}) error {
 		return fmt.Errorf("error converting data to map: %s", err)
 	}
 
-	util.UpdateMap(newData, existing)
+	// Fields keep their order on disk; new fields follow in the order of v.
+	order := d.recordKeyOrder(collection, bytes).merge(d.keyOrderOf(collection, v))
+
+	created := existing[createdAtField]
+	version := recordVersion(existing)
+
+	if err := merge(newData, existing); err != nil {
+		return err
+	}
+
+	existing[versionField] = version + 1
+	d.stampUpdated(existing, created)
 
-	if err := os.Remove(resourcePath); err != nil {
-		d.log.Debug("Error removing file: %s (%s)", resourcePath, err)
-		return fmt.Errorf("error removing file: %s (%s)", resourcePath, err)
+	if err := d.validate(collection, existing); err != nil {
+		return err
+	}
+
+	if err := d.checkUnique(collection, resource, existing, nil); err != nil {
+		return err
 	}
 
-	bytes, err = json.MarshalIndent(existing, "", "\t")
+	bytes, err = d.marshalRecord(collection, existing, order)
 	if err != nil {
 		d.log.Debug("Error marshalling json: %s", err)
-		return fmt.Errorf("error marshalling json: %s", err)
+		return fmt.Errorf("error marshalling json: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(resourcePath, bytes, 0644); err != nil {
+	if d.skipChange(OpUpdate, collection, resource, bytes) {
+		return nil
+	}
+
+	// Write to a temporary file and rename it over the record so a crash
+	// never leaves the record missing or half written.
+	if err := d.writeRecord(collection, resourcePath, bytes); err != nil {
 		d.log.Debug("Error writing to file: %s (%s)", resourcePath, err)
 		return err
 	}
 
+	d.stats.addBytes(collection, 0, len(bytes))
+
+	d.reindex(collection, resource, existing)
+	d.cache.invalidate(collection, resource)
+	d.logChange(OpUpdate, collection, resource, existing)
+	d.notify(OpUpdate, collection, resource)
+
 	return nil
 }
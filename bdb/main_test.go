@@ -0,0 +1,1351 @@
+package bdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+type testAddress struct {
+	City    string
+	State   string
+	Country string
+	Pincode json.Number
+}
+
+type testUser struct {
+	Name    string
+	Age     json.Number
+	Contact string
+	Company string
+	Address testAddress
+}
+
+// newTestDriver opens a database in a temporary directory, closed when the
+// test ends. Only errors are logged.
+func newTestDriver(t testing.TB, opts *Options) *Driver {
+	t.Helper()
+
+	return openTestDriver(t, t.TempDir(), opts)
+}
+
+// openTestDriver opens the database in dir, closed when the test ends.
+func openTestDriver(t testing.TB, dir string, opts *Options) *Driver {
+	t.Helper()
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = lumber.NewConsoleLogger(lumber.ERROR)
+	}
+
+	db, err := New(dir, opts)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestUpdateNestedNumber(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	user := testUser{Name: "John", Age: "23", Address: testAddress{City: "bangalore", Pincode: "515671"}}
+	if err := db.WriteWithID("users", "john", user); err != nil {
+		t.Fatal(err)
+	}
+
+	update := map[string]interface{}{"Address": map[string]interface{}{"Pincode": json.Number("410013")}}
+	if err := db.Update("users", "john", update); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "john", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Address.Pincode != "410013" {
+		t.Errorf("Pincode = %s, want 410013", got.Address.Pincode)
+	}
+	if got.Address.City != "bangalore" || got.Name != "John" {
+		t.Errorf("other fields changed: %+v", got)
+	}
+}
+
+func TestUpdateLargeInteger(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("c", "a", map[string]interface{}{"n": int64(1 << 60)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("c", "a", map[string]interface{}{"n": int64(1<<60 + 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct{ N int64 }
+	if err := db.Read("c", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.N != 1<<60+1 {
+		t.Errorf("n = %d, want %d", got.N, int64(1<<60+1))
+	}
+}
+
+func TestIncrementLargeInteger(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("c", "a", map[string]interface{}{"n": int64(1 << 60), "f": 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment("c", "a", "n", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Increment("c", "a", "f", 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Increment("c", "a", "missing", -2); err != nil || v != -2 {
+		t.Errorf("missing = %v, %v, want -2", v, err)
+	}
+
+	var got struct {
+		N int64
+		F float64
+	}
+	if err := db.Read("c", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.N != 1<<60+1 {
+		t.Errorf("n = %d, want %d", got.N, int64(1<<60+1))
+	}
+	if got.F != 2.5 {
+		t.Errorf("f = %v, want 2.5", got.F)
+	}
+}
+
+func TestStrictInsertRejectsExistingID(t *testing.T) {
+	db := newTestDriver(t, &Options{StrictInsert: true})
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.WriteWithID("users", "a", map[string]interface{}{"name": "second"})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("second write: got %v, want ErrDuplicateKey", err)
+	}
+
+	var got map[string]interface{}
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["name"] != "first" {
+		t.Errorf("name = %v, want first", got["name"])
+	}
+}
+
+// plainStorage hides the Linker implementation of a Storage.
+type plainStorage struct {
+	Storage
+}
+
+func TestStrictInsertKeepsFileCreatedMeanwhile(t *testing.T) {
+	storages := map[string]func() Storage{
+		"disk":     func() Storage { return DiskStorage{} },
+		"memory":   func() Storage { return NewMemStorage() },
+		"no links": func() Storage { return plainStorage{DiskStorage{}} },
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if name == "memory" {
+				dir = "/db"
+			}
+			db := openTestDriver(t, dir, &Options{StrictInsert: true, Storage: storage()})
+
+			// Another process creates the record after the driver checked
+			// that the ID is free.
+			path := db.recordPath("users", "a")
+			if err := db.mkdirAll(filepath.Dir(path)); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.storage.WriteFile(path, []byte(`{"_id":"a","name":"other"}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := db.createRecord("users", path, []byte(`{"_id":"a","name":"ours"}`))
+			if !errors.Is(err, ErrDuplicateKey) {
+				t.Fatalf("got %v, want ErrDuplicateKey", err)
+			}
+
+			data, err := db.storage.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "other") {
+				t.Errorf("record replaced: %s", data)
+			}
+			if _, err := db.storage.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteReturnsID(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	id, err := db.Write("users", testUser{Name: "John", Age: "23"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" {
+		t.Fatal("Write returned an empty ID")
+	}
+
+	var got testUser
+	if err := db.Read("users", id, &got); err != nil {
+		t.Fatalf("Read %s: %s", id, err)
+	}
+	if got.Name != "John" {
+		t.Errorf("Name = %s, want John", got.Name)
+	}
+}
+
+// failingMkdirStorage fails to create directories.
+type failingMkdirStorage struct {
+	Storage
+}
+
+func (failingMkdirStorage) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrPermission}
+}
+
+func TestWriteReturnsIDOnFailure(t *testing.T) {
+	db := newTestDriver(t, &Options{Storage: failingMkdirStorage{DiskStorage{}}})
+
+	id, err := db.Write("users", testUser{Name: "John"})
+	if err == nil {
+		t.Fatal("Write: got nil error")
+	}
+	if id == "" {
+		t.Error("Write returned an empty ID with the error")
+	}
+}
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestDeleteRemovesRecordFile(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	// Files named like the record in the working directory must survive.
+	cwd := t.TempDir()
+	chdir(t, cwd)
+	for _, name := range []string{"a", "a.json"} {
+		if err := os.WriteFile(filepath.Join(cwd, name), []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	path := db.recordPath("users", "a")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("record file missing after write: %s", err)
+	}
+
+	if err := db.Delete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("record file still present: %v", err)
+	}
+	for _, name := range []string{"a", "a.json"} {
+		if data, err := os.ReadFile(filepath.Join(cwd, name)); err != nil || string(data) != "keep" {
+			t.Errorf("%s in the working directory changed: %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestReadAllSkipsTemporaryFiles(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crashed write leaves a half-written temporary file behind.
+	stray := db.recordPath("users", "b") + ".tmp"
+	if err := os.WriteFile(stray, []byte(`{"Name": "Ja`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := db.ReadAll("users")
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if len(records) != 1 || !strings.Contains(records[0], "John") {
+		t.Errorf("ReadAll = %v, want only the record of John", records)
+	}
+
+	if n, err := db.Count("users"); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v; want 1", n, err)
+	}
+}
+
+func TestReadAllSortedByID(t *testing.T) {
+	options := map[string]*Options{
+		"default":            nil,
+		"concurrent reads":   {ReadConcurrency: 4},
+		"sharded collection": {ShardDepth: 1},
+	}
+
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDriver(t, opts)
+
+			ids := []string{"m", "b", "z", "a", "k10", "k2"}
+			for _, id := range ids {
+				if err := db.WriteWithID("users", id, map[string]interface{}{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := "a b k10 k2 m z"
+			for i := 0; i < 3; i++ {
+				records, err := db.ReadAll("users")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got := make([]string, len(records))
+				for j, record := range records {
+					var v struct {
+						ID string `json:"_id"`
+					}
+					if err := json.Unmarshal([]byte(record), &v); err != nil {
+						t.Fatal(err)
+					}
+					got[j] = v.ID
+				}
+				if strings.Join(got, " ") != want {
+					t.Fatalf("ReadAll order = %v, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+// sequenceIDs returns an ID generator returning ids in turn, then the last
+// one forever.
+func sequenceIDs(ids ...string) func() string {
+	var mutex sync.Mutex
+	return func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		id := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return id
+	}
+}
+
+func TestWriteRetriesIDCollision(t *testing.T) {
+	db := newTestDriver(t, &Options{IDGenerator: sequenceIDs("a", "a", "b")})
+
+	if id, err := db.Write("users", testUser{Name: "first"}); err != nil || id != "a" {
+		t.Fatalf("first Write = %s, %v", id, err)
+	}
+	id, err := db.Write("users", testUser{Name: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "b" {
+		t.Errorf("second Write used ID %s, want b", id)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "first" {
+		t.Errorf("existing record overwritten: %+v", got)
+	}
+}
+
+func TestWriteFailsWithoutFreeID(t *testing.T) {
+	db := newTestDriver(t, &Options{IDGenerator: sequenceIDs("a")})
+
+	if _, err := db.Write("users", testUser{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Write("users", testUser{Name: "second"}); err == nil {
+		t.Fatal("Write with only taken IDs: got nil error")
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "first" {
+		t.Errorf("existing record overwritten: %+v", got)
+	}
+}
+
+// failingStorage fails writes and renames on demand, after writing half
+// of the data like a crash would.
+type failingStorage struct {
+	Storage
+	failWrite  bool
+	failRename bool
+}
+
+func (s *failingStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if s.failWrite {
+		s.Storage.WriteFile(path, data[:len(data)/2], perm)
+		return errors.New("disk full")
+	}
+	return s.Storage.WriteFile(path, data, perm)
+}
+
+func (s *failingStorage) Rename(oldPath, newPath string) error {
+	if s.failRename {
+		return errors.New("rename failed")
+	}
+	return s.Storage.Rename(oldPath, newPath)
+}
+
+func TestUpdateFailureKeepsRecord(t *testing.T) {
+	failures := map[string]func(s *failingStorage){
+		"write":  func(s *failingStorage) { s.failWrite = true },
+		"rename": func(s *failingStorage) { s.failRename = true },
+	}
+
+	for name, fail := range failures {
+		t.Run(name, func(t *testing.T) {
+			storage := &failingStorage{Storage: DiskStorage{}}
+			db := newTestDriver(t, &Options{Storage: storage})
+
+			if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23"}); err != nil {
+				t.Fatal(err)
+			}
+
+			fail(storage)
+			if err := db.Update("users", "a", map[string]interface{}{"Name": "Jane"}); err == nil {
+				t.Fatal("Update: got nil error")
+			}
+			*storage = failingStorage{Storage: DiskStorage{}}
+
+			var got testUser
+			if err := db.Read("users", "a", &got); err != nil {
+				t.Fatalf("Read after the failed update: %s", err)
+			}
+			if got.Name != "John" || got.Age != "23" {
+				t.Errorf("record = %+v, want the original", got)
+			}
+		})
+	}
+}
+
+func TestDropCollection(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, nil)
+
+	for _, id := range []string{"a", "b"} {
+		if err := db.WriteWithID("users", id, testUser{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DropCollection("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users")); !os.IsNotExist(err) {
+		t.Errorf("collection directory still present: %v", err)
+	}
+	if err := db.DropCollection("users"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dropping a missing collection: got %v, want ErrNotFound", err)
+	}
+
+	// A later write recreates the collection, without the old records.
+	if err := db.WriteWithID("users", "c", testUser{Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Count("users"); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v; want 1", n, err)
+	}
+}
+
+// mutexCount returns the number of collection mutexes of a driver.
+func mutexCount(db *Driver) int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return len(db.mutexes)
+}
+
+func TestDropCollectionForgetsMutex(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	before := mutexCount(db)
+	for i := 0; i < 100; i++ {
+		collection := "tmp" + strconv.Itoa(i)
+		if err := db.WriteWithID(collection, "a", map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DropCollection(collection); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := mutexCount(db); n > before {
+		t.Errorf("%d collection mutexes after dropping the collections, want %d", n, before)
+	}
+}
+
+func TestReleaseCollection(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	before := mutexCount(db)
+
+	// A held mutex is kept.
+	unlock := db.lockCollection("users")
+	db.ReleaseCollection("users")
+	if n := mutexCount(db); n != before {
+		t.Errorf("%d collection mutexes after releasing a held one, want %d", n, before)
+	}
+	unlock()
+
+	db.ReleaseCollection("users")
+	if n := mutexCount(db); n != before-1 {
+		t.Errorf("%d collection mutexes after release, want %d", n, before-1)
+	}
+
+	// Releasing an unknown collection does nothing.
+	db.ReleaseCollection("unknown")
+}
+
+func TestTruncate(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDriver(t, dir, nil)
+
+	if err := db.Truncate("users"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("truncating a missing collection: got %v, want ErrNotFound", err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := db.WriteWithID("users", id, testUser{Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stray := db.recordPath("users", "d") + ".tmp"
+	if err := os.WriteFile(stray, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Truncate("users"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Count("users"); err != nil || n != 0 {
+		t.Errorf("Count = %d, %v; want 0", n, err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("temporary file still present: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "users")); err != nil || !fi.IsDir() {
+		t.Errorf("collection directory removed: %v", err)
+	}
+
+	// Truncating an empty collection does nothing.
+	if err := db.Truncate("users"); err != nil {
+		t.Errorf("truncating an empty collection: %s", err)
+	}
+}
+
+func TestRejectsPathTraversal(t *testing.T) {
+	root := t.TempDir()
+	db := openTestDriver(t, filepath.Join(root, "db"), nil)
+
+	victim := filepath.Join(root, "victim.json")
+	if err := os.WriteFile(victim, []byte(`{"keep": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"../victim", "..", "a/b", `a\b`, "a\x00b", filepath.Join(root, "victim")}
+	for _, name := range names {
+		var v map[string]interface{}
+		ops := map[string]func() error{
+			"Write collection": func() error {
+				_, err := db.Write(name, map[string]interface{}{})
+				return err
+			},
+			"WriteWithID": func() error {
+				return db.WriteWithID("users", name, map[string]interface{}{})
+			},
+			"Read": func() error {
+				return db.Read("users", name, &v)
+			},
+			"Read collection": func() error {
+				return db.Read(name, "a", &v)
+			},
+			"ReadAll": func() error {
+				_, err := db.ReadAll(name)
+				return err
+			},
+			"Update": func() error {
+				return db.Update("users", name, map[string]interface{}{"keep": false})
+			},
+			"Delete": func() error {
+				return db.Delete("users", name)
+			},
+			"Delete collection": func() error {
+				return db.Delete(name, "a")
+			},
+			"CreateIndex": func() error {
+				return db.CreateIndex(name, "Name")
+			},
+			"AddUniqueConstraint": func() error {
+				return db.AddUniqueConstraint(name, "Name")
+			},
+			"FindByIndex": func() error {
+				_, err := db.FindByIndex(name, "Name", "John")
+				return err
+			},
+		}
+
+		for op, call := range ops {
+			if err := call(); !errors.Is(err, ErrInvalidName) {
+				t.Errorf("%s %q: got %v, want ErrInvalidName", op, name, err)
+			}
+		}
+	}
+
+	if data, err := os.ReadFile(victim); err != nil || string(data) != `{"keep": true}` {
+		t.Errorf("file outside the database changed: %q, %v", data, err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("files created outside the database: %v", entries)
+	}
+}
+
+func TestReservedCollection(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "john", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("users", "Name"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"_schema", "_index", "_corrupt"} {
+		var v map[string]interface{}
+		ops := map[string]func() error{
+			"Write": func() error {
+				_, err := db.Write(name, map[string]interface{}{})
+				return err
+			},
+			"WriteWithID": func() error {
+				return db.WriteWithID(name, "users", map[string]interface{}{})
+			},
+			"Read": func() error {
+				return db.Read(name, "users", &v)
+			},
+			"ReadAll": func() error {
+				_, err := db.ReadAll(name)
+				return err
+			},
+			"Delete": func() error {
+				return db.Delete(name, "users")
+			},
+			"DropCollection": func() error {
+				return db.DropCollection(name)
+			},
+			"Truncate": func() error {
+				return db.Truncate(name)
+			},
+			"Move": func() error {
+				return db.Move("users", "john", name, "john")
+			},
+		}
+
+		for op, call := range ops {
+			if err := call(); !errors.Is(err, ErrInvalidName) {
+				t.Errorf("%s %q: got %v, want ErrInvalidName", op, name, err)
+			}
+		}
+	}
+
+	ids, err := db.FindByIndex("users", "Name", "John")
+	if err != nil || len(ids) != 1 {
+		t.Errorf("index damaged: %v, %v", ids, err)
+	}
+}
+
+// deniedStorage fails every stat with a permission error.
+type deniedStorage struct {
+	Storage
+	denied bool
+}
+
+func (s *deniedStorage) Stat(path string) (os.FileInfo, error) {
+	if s.denied {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrPermission}
+	}
+	return s.Storage.Stat(path)
+}
+
+func TestErrNotFound(t *testing.T) {
+	storage := &deniedStorage{Storage: DiskStorage{}}
+	db := newTestDriver(t, &Options{Storage: storage})
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var v testUser
+	missing := map[string]error{
+		"Read":   db.Read("users", "missing", &v),
+		"Update": db.Update("users", "missing", map[string]interface{}{"Name": "x"}),
+		"Delete": db.Delete("users", "missing"),
+	}
+	for op, err := range missing {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of a missing record: got %v, want ErrNotFound", op, err)
+		}
+	}
+
+	storage.denied = true
+	denied := map[string]error{
+		"Read":   db.Read("users", "a", &v),
+		"Update": db.Update("users", "a", map[string]interface{}{"Name": "x"}),
+		"Delete": db.Delete("users", "a"),
+	}
+	for op, err := range denied {
+		if err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%s with a permission failure: got %v, want an error other than ErrNotFound", op, err)
+		}
+	}
+}
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	for name, granularity := range map[string]LockGranularity{"collection": LockCollection, "record": LockRecord} {
+		t.Run(name, func(t *testing.T) {
+			testConcurrentReadsAndWrites(t, newTestDriver(t, &Options{LockGranularity: granularity}))
+		})
+	}
+}
+
+// testConcurrentReadsAndWrites updates a record from several goroutines
+// while others read the collection, checking that no read sees a partial
+// write and no update is lost.
+func testConcurrentReadsAndWrites(t *testing.T, db *Driver) {
+	for _, id := range []string{"a", "b"} {
+		if err := db.WriteWithID("users", id, testUser{Name: "John", Age: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const writers, readers, rounds = 4, 8, 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*rounds+readers*rounds*3)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				age := json.Number(strconv.Itoa(w*rounds + i + 1))
+				if err := db.Update("users", "a", map[string]interface{}{"Age": age}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				var user testUser
+				if err := db.Read("users", "a", &user); err != nil {
+					errs <- err
+				} else if user.Name != "John" || user.Age == "" {
+					errs <- fmt.Errorf("partial record read: %+v", user)
+				}
+				if records, err := db.ReadAll("users"); err != nil || len(records) != 2 {
+					errs <- fmt.Errorf("ReadAll = %d records, %v", len(records), err)
+				}
+				if n, err := db.Count("users"); err != nil || n != 2 {
+					errs <- fmt.Errorf("Count = %d, %v", n, err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if v := readVersion(t, db, "users", "a"); v != 1+writers*rounds {
+		t.Errorf("version = %d, want %d: updates were lost", v, 1+writers*rounds)
+	}
+}
+
+func TestLargeIntegerRoundTrip(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	type account struct {
+		AccountID int64
+		Balance   json.Number
+	}
+
+	// 2^53 + 1 is the first integer a float64 cannot hold.
+	want := account{AccountID: 1<<53 + 1, Balance: "12345678901234567890.123456789"}
+	id, err := db.Write("accounts", want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got account
+	if err := db.Read("accounts", id, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("after Write: %+v, want %+v", got, want)
+	}
+
+	want.AccountID = 1<<62 + 3
+	if err := db.Update("accounts", id, map[string]interface{}{"AccountID": want.AccountID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Read("accounts", id, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("after Update: %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteRejectsNonObjects(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	values := map[string]interface{}{
+		"slice":  []string{"a", "b"},
+		"string": "text",
+		"nil":    nil,
+		"number": 42,
+	}
+	for name, v := range values {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("Write with a %s panicked: %v", name, r)
+				}
+			}()
+
+			if _, err := db.Write("users", v); err == nil {
+				t.Errorf("Write with a %s: got nil error", name)
+			}
+			if err := db.WriteWithID("users", "a", v); err == nil {
+				t.Errorf("WriteWithID with a %s: got nil error", name)
+			}
+		}()
+	}
+
+	if n, _ := db.Count("users"); n != 0 {
+		t.Errorf("Count = %d after rejected writes, want 0", n)
+	}
+}
+
+func TestNewProbesWritableDirectory(t *testing.T) {
+	dir := t.TempDir()
+	storage := &failingStorage{Storage: DiskStorage{}, failWrite: true}
+
+	_, err := New(dir, &Options{Storage: storage, Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err == nil || !strings.Contains(err.Error(), "not writable") || !strings.Contains(err.Error(), dir) {
+		t.Errorf("New on an unwritable directory: got %v, want an error naming it", err)
+	}
+
+	// The probe can be skipped for file systems that do not support it.
+	db := openTestDriver(t, dir, &Options{Storage: storage, SkipWriteProbe: true})
+	var v map[string]interface{}
+	if err := db.Read("users", "a", &v); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read: got %v, want ErrNotFound", err)
+	}
+}
+
+// listFiles returns the files and directories under dir with their sizes.
+func listFiles(t *testing.T, dir string) map[string]int64 {
+	t.Helper()
+
+	files := map[string]int64{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files[path] = fi.Size()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db = openTestDriver(t, dir, &Options{ReadOnly: true})
+	before := listFiles(t, dir)
+
+	update := map[string]interface{}{"Name": "Jane"}
+	ops := map[string]func() error{
+		"Write": func() error {
+			_, err := db.Write("users", update)
+			return err
+		},
+		"WriteWithID": func() error { return db.WriteWithID("users", "b", update) },
+		"Update":      func() error { return db.Update("users", "a", update) },
+		"Replace":     func() error { return db.Replace("users", "a", update) },
+		"Delete":      func() error { return db.Delete("users", "a") },
+		"Upsert": func() error {
+			_, err := db.Upsert("users", "a", update)
+			return err
+		},
+		"Patch":          func() error { return db.Patch("users", "a", "/Name", "Jane") },
+		"SoftDelete":     func() error { return db.SoftDelete("users", "a") },
+		"Rename":         func() error { return db.Rename("users", "a", "b") },
+		"Truncate":       func() error { return db.Truncate("users") },
+		"DropCollection": func() error { return db.DropCollection("users") },
+	}
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	if after := listFiles(t, dir); !reflect.DeepEqual(after, before) {
+		t.Errorf("files changed by a read-only driver:\nbefore %v\nafter  %v", before, after)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil || got.Name != "John" {
+		t.Errorf("Read = %+v, %v", got, err)
+	}
+	if n, err := db.Count("users"); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v; want 1", n, err)
+	}
+}
+
+func TestReadOnlyRequiresExistingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	if _, err := New(dir, &Options{ReadOnly: true, Logger: lumber.NewConsoleLogger(lumber.ERROR)}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("New: got %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("read-only New created the directory: %v", err)
+	}
+}
+
+func TestReadRequiresPointer(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var user testUser
+	var nilUser *testUser
+	var users []testUser
+	targets := map[string]interface{}{
+		"value":       user,
+		"nil":         nil,
+		"nil pointer": nilUser,
+	}
+	for name, target := range targets {
+		if err := db.Read("users", "a", target); !errors.Is(err, ErrNotAPointer) {
+			t.Errorf("Read into a %s: got %v, want ErrNotAPointer", name, err)
+		}
+		if err := db.ReadProjected("users", "a", []string{"Name"}, target); !errors.Is(err, ErrNotAPointer) {
+			t.Errorf("ReadProjected into a %s: got %v, want ErrNotAPointer", name, err)
+		}
+	}
+	if err := db.ReadAllInto("users", users); !errors.Is(err, ErrNotAPointer) {
+		t.Errorf("ReadAllInto a slice: got %v, want ErrNotAPointer", err)
+	} else if !strings.Contains(err.Error(), "[]bdb.testUser") {
+		t.Errorf("error %q does not name the type", err)
+	}
+
+	if err := db.Read("users", "a", &user); err != nil || user.Name != "John" {
+		t.Errorf("Read into a pointer = %+v, %v", user, err)
+	}
+	if err := db.ReadAllInto("users", &users); err != nil || len(users) != 1 {
+		t.Errorf("ReadAllInto a pointer = %v, %v", users, err)
+	}
+}
+
+func TestUpdateMany(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	employees := map[string]testUser{
+		"e1": {Name: "a", Company: "acme"},
+		"e2": {Name: "b", Company: "acme"},
+		"e3": {Name: "c", Company: "other"},
+		"e4": {Name: "d", Company: "acme"},
+	}
+	for id, employee := range employees {
+		if err := db.WriteWithID("employees", id, employee); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SoftDelete("employees", "e4"); err != nil {
+		t.Fatal(err)
+	}
+
+	atAcme := func(record map[string]interface{}) bool {
+		return record["Company"] == "acme"
+	}
+	n, err := db.UpdateMany("employees", atAcme, map[string]interface{}{"Badge": "gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("UpdateMany updated %d records, want 2", n)
+	}
+
+	for id, want := range map[string]interface{}{"e1": "gold", "e2": "gold", "e3": nil} {
+		var got map[string]interface{}
+		if err := db.Read("employees", id, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got["Badge"] != want {
+			t.Errorf("%s: Badge = %v, want %v", id, got["Badge"], want)
+		}
+		if got["Name"] != employees[id].Name {
+			t.Errorf("%s: Name = %v, want %s", id, got["Name"], employees[id].Name)
+		}
+	}
+
+	if n, err := db.UpdateMany("employees", func(map[string]interface{}) bool { return false }, map[string]interface{}{"Badge": "x"}); err != nil || n != 0 {
+		t.Errorf("UpdateMany matching nothing = %d, %v", n, err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	events, unsubscribe, err := db.Watch("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	created, err := db.Upsert("users", "a", testUser{Name: "John", Age: "23"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("insert path: created = false")
+	}
+
+	created, err = db.Upsert("users", "a", map[string]interface{}{"Company": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Error("update path: created = true")
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "John" || got.Age != "23" || got.Company != "acme" {
+		t.Errorf("record = %+v, want the update merged into it", got)
+	}
+	if v := readVersion(t, db, "users", "a"); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+
+	for _, op := range []Op{OpCreate, OpUpdate} {
+		if event := nextEvent(t, events); event.Op != op {
+			t.Errorf("event %s, want %s", event.Op, op)
+		}
+	}
+}
+
+func TestConcurrentUpsertsCreateOnce(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	const goroutines = 20
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	creates := 0
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			created, err := db.Upsert("counters", "a", map[string]interface{}{"last": i + 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if created {
+				mutex.Lock()
+				creates++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if creates != 1 {
+		t.Errorf("%d upserts created the record, want 1", creates)
+	}
+	if v := readVersion(t, db, "counters", "a"); v != goroutines {
+		t.Errorf("version = %d, want %d", v, goroutines)
+	}
+}
+
+func TestReadProjected(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	user := testUser{Name: "John", Age: "23", Company: "acme", Address: testAddress{City: "bangalore", Pincode: "515671"}}
+	if err := db.WriteWithID("users", "a", user); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := db.ReadProjected("users", "a", []string{"Name", "Company", "Missing"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"Name": "John", "Company": "acme"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projection = %v, want %v", got, want)
+	}
+
+	// Dotted paths keep nested fields, and fields left out keep their value.
+	out := testUser{Contact: "kept"}
+	if err := db.ReadProjected("users", "a", []string{"Address.City", "_id"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Address.City != "bangalore" || out.Address.Pincode != "" || out.Name != "" || out.Contact != "kept" {
+		t.Errorf("nested projection = %+v", out)
+	}
+}
+
+// recCodec is a custom codec storing JSON records with a ".rec" extension.
+type recCodec struct {
+	JSONCodec
+}
+
+func (recCodec) Ext() string {
+	return ".rec"
+}
+
+func TestDirAndPath(t *testing.T) {
+	root := t.TempDir()
+
+	db := openTestDriver(t, root+"/./db/", nil)
+	dir := filepath.Join(root, "db")
+	if db.Dir() != dir {
+		t.Errorf("Dir = %s, want %s", db.Dir(), dir)
+	}
+	if got, want := db.Path("users", "a"), filepath.Join(dir, "users", "a.json"); got != want {
+		t.Errorf("Path = %s, want %s", got, want)
+	}
+
+	// The path of an existing record is where it is stored, whatever the
+	// options say about new records.
+	if err := db.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db = openTestDriver(t, dir, &Options{Compress: true})
+	if got, want := db.Path("users", "a"), filepath.Join(dir, "users", "a.json"); got != want {
+		t.Errorf("Path of a plain record = %s, want %s", got, want)
+	}
+	if got, want := db.Path("users", "b"), filepath.Join(dir, "users", "b.json"+gzipExt); got != want {
+		t.Errorf("Path of a new compressed record = %s, want %s", got, want)
+	}
+
+	codecs := map[string]struct {
+		codec Codec
+		ext   string
+	}{
+		"yaml":   {YAMLCodec{}, ".yaml"},
+		"custom": {recCodec{}, ".rec"},
+	}
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDriver(t, &Options{Codec: c.codec})
+
+			path := db.Path("users", "a")
+			if want := filepath.Join(db.Dir(), "users", "a"+c.ext); path != want {
+				t.Errorf("Path = %s, want %s", path, want)
+			}
+			if err := db.WriteWithID("users", "a", map[string]interface{}{"n": 1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("record not stored at its Path: %s", err)
+			}
+		})
+	}
+}
+
+func TestMaxRecordBytes(t *testing.T) {
+	db := newTestDriver(t, &Options{MaxRecordBytes: 64})
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"Name": "John"}); err != nil {
+		t.Fatal(err)
+	}
+	before := listFiles(t, db.Dir())
+
+	large := map[string]interface{}{"Name": strings.Repeat("x", 100)}
+	if _, err := db.Write("users", large); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Write: got %v, want ErrRecordTooLarge", err)
+	}
+	if err := db.WriteWithID("users", "b", large); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("WriteWithID: got %v, want ErrRecordTooLarge", err)
+	}
+	if err := db.Update("users", "a", large); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Update: got %v, want ErrRecordTooLarge", err)
+	}
+
+	if after := listFiles(t, db.Dir()); !reflect.DeepEqual(after, before) {
+		t.Errorf("files changed by oversized records:\nbefore %v\nafter  %v", before, after)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil || got.Name != "John" {
+		t.Errorf("Read = %+v, %v", got, err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := db.WriteWithID("users", id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"x", "y"} {
+		if err := db.WriteWithID("orders", id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+	err := db.Walk(func(collection, id string, raw []byte) error {
+		var v map[string]interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if v["_id"] != id {
+			t.Errorf("record %s/%s has _id %v", collection, id, v["_id"])
+		}
+		counts[collection]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"users": 3, "orders": 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Walk counts = %v, want %v", counts, want)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = db.Walk(func(collection, id string, raw []byte) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Errorf("Walk = %v after %d records, want stop after 1", err, visited)
+	}
+}
+
+// BenchmarkReadAll reads a collection of a few thousand records one file
+// after the other and with a pool of workers.
+func BenchmarkReadAll(b *testing.B) {
+	const records = 3000
+
+	dir := b.TempDir()
+	db := openTestDriver(b, dir, nil)
+	for i := 0; i < records; i++ {
+		if err := db.WriteWithID("users", strconv.Itoa(i), testUser{Name: "John"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	db.Close()
+
+	for _, bc := range []struct {
+		name        string
+		concurrency int
+	}{{"sequential", 1}, {"concurrent", 8}} {
+		db := openTestDriver(b, dir, &Options{ReadConcurrency: bc.concurrency})
+
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				all, err := db.ReadAll("users")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(all) != records {
+					b.Fatalf("read %d records, want %d", len(all), records)
+				}
+			}
+		})
+		db.Close()
+	}
+}
@@ -0,0 +1,81 @@
+//go:build unix
+
+package bdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+func TestFileModes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	db := openTestDriver(t, dir, &Options{FileMode: 0600, DirMode: 0750})
+
+	if err := db.WriteWithID("secrets", "a", map[string]interface{}{"key": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMode := func(path string, want os.FileMode) {
+		t.Helper()
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("mode of %s = %o, want %o", path, got, want)
+		}
+	}
+
+	checkMode(dir, 0750)
+	checkMode(filepath.Join(dir, "secrets"), 0750)
+	checkMode(db.recordPath("secrets", "a"), 0600)
+
+	// The temporary file of an update keeps the mode once renamed.
+	if err := db.Update("secrets", "a", map[string]interface{}{"key": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	checkMode(db.recordPath("secrets", "a"), 0600)
+}
+
+func TestDefaultFileModes(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(db.recordPath("users", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default 0644 is subject to the umask, so only check that no
+	// permission beyond it is granted.
+	if extra := fi.Mode().Perm() &^ 0644; extra != 0 {
+		t.Errorf("mode %o grants more than 0644", fi.Mode().Perm())
+	}
+}
+
+func TestNewRejectsReadOnlyDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions do not apply to root")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	_, err := New(dir, &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("New on a read-only directory: got %v, want a not writable error", err)
+	}
+
+	// A read-only driver does not need to write.
+	openTestDriver(t, dir, &Options{ReadOnly: true})
+}
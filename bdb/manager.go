@@ -0,0 +1,90 @@
+package bdb
+
+import (
+	"sync"
+
+	"github.com/jcelliott/lumber"
+)
+
+// Manager opens many databases with the same options, e.g. one per tenant,
+// sharing a single Logger and Codec between them, and closes them all at
+// once.
+type Manager struct {
+	opts Options
+
+	mutex   sync.Mutex
+	drivers []*Driver
+}
+
+// NewManager creates a manager opening databases with the given options.
+//
+// The default Logger and Codec are created once, here, rather than by
+// every Open. Each driver still has a cache of its own, of
+// Options.CacheSize records.
+//
+// Parameters:
+// - options: The options of the databases (optional).
+//
+// Returns:
+// - *Manager: The new manager.
+func NewManager(options *Options) *Manager {
+	m := &Manager{}
+
+	if options != nil {
+		m.opts = *options
+	}
+	if m.opts.Logger == nil {
+		m.opts.Logger = lumber.NewConsoleLogger((lumber.INFO))
+	}
+	if m.opts.Codec == nil {
+		m.opts.Codec = JSONCodec{}
+	}
+
+	return m
+}
+
+// Open opens the database in dir with the options of the manager, like
+// New, and tracks the driver so CloseAll releases it.
+//
+// Parameters:
+// - dir: The directory where the database is stored.
+//
+// Returns:
+// - *Driver: The database driver.
+// - error: An error if the database cannot be created or is locked.
+func (m *Manager) Open(dir string) (*Driver, error) {
+	opts := m.opts
+
+	driver, err := New(dir, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.drivers = append(m.drivers, driver)
+
+	return driver, nil
+}
+
+// CloseAll closes every driver returned by Open, once per call to Open.
+// The manager can open databases again afterwards.
+//
+// Returns:
+// - error: The first error met; the remaining drivers are still closed.
+func (m *Manager) CloseAll() error {
+	m.mutex.Lock()
+	drivers := m.drivers
+	m.drivers = nil
+	m.mutex.Unlock()
+
+	var first error
+	for _, driver := range drivers {
+		if err := driver.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,24 @@
+package bdb
+
+import "github.com/babu10103/bdb/util"
+
+// ArrayMergeStrategy selects how updates merge an array into the array
+// already stored in a field.
+type ArrayMergeStrategy = util.ArrayMergeStrategy
+
+const (
+	// ArrayReplace replaces the stored array. It is the default.
+	ArrayReplace = util.ArrayReplace
+	// ArrayAppend appends the new elements to the stored array.
+	ArrayAppend = util.ArrayAppend
+	// ArrayUnion appends the new elements the stored array does not hold
+	// yet, comparing elements by their JSON encoding.
+	ArrayUnion = util.ArrayUnion
+)
+
+// Delete is the value that removes a field when an update sets it, e.g.
+// map[string]interface{}{"Company": bdb.Delete}, as Update otherwise never
+// removes fields. Being a string, it can also be set on string struct
+// fields. Operations storing a whole record, such as Write, Replace or the
+// inserts of Upsert, leave the fields set to it out.
+const Delete = util.DeleteValue
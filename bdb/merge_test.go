@@ -0,0 +1,220 @@
+package bdb
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArrayMergeStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy ArrayMergeStrategy
+		want     string
+	}{
+		{"replace", ArrayReplace, `["b","c",{"id":1}]`},
+		{"append", ArrayAppend, `["a","b",{"id":1},"b","c",{"id":1}]`},
+		{"union", ArrayUnion, `["a","b",{"id":1},"c"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDriver(t, &Options{ArrayMergeStrategy: tt.strategy})
+
+			record := map[string]interface{}{
+				"Tags":    []interface{}{"a", "b", map[string]interface{}{"id": 1}},
+				"Profile": map[string]interface{}{"Tags": []interface{}{"a", "b", map[string]interface{}{"id": 1}}},
+			}
+			if err := db.WriteWithID("users", "a", record); err != nil {
+				t.Fatal(err)
+			}
+
+			update := map[string]interface{}{
+				"Tags":    []interface{}{"b", "c", map[string]interface{}{"id": 1}},
+				"Profile": map[string]interface{}{"Tags": []interface{}{"b", "c", map[string]interface{}{"id": 1}}},
+			}
+			if err := db.Update("users", "a", update); err != nil {
+				t.Fatal(err)
+			}
+
+			var got struct {
+				Tags    json.RawMessage
+				Profile struct{ Tags json.RawMessage }
+			}
+			if err := db.Read("users", "a", &got); err != nil {
+				t.Fatal(err)
+			}
+			if compactJSON(t, got.Tags) != tt.want {
+				t.Errorf("Tags = %s, want %s", got.Tags, tt.want)
+			}
+			if compactJSON(t, got.Profile.Tags) != tt.want {
+				t.Errorf("nested Tags = %s, want %s", got.Profile.Tags, tt.want)
+			}
+		})
+	}
+}
+
+// compactJSON returns data without insignificant whitespace.
+func compactJSON(t *testing.T, data []byte) string {
+	t.Helper()
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	compact, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(compact)
+}
+
+func TestDeleteField(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	user := testUser{Name: "John", Age: "23", Company: "acme", Address: testAddress{City: "bangalore", State: "ka"}}
+	if err := db.WriteWithID("users", "a", user); err != nil {
+		t.Fatal(err)
+	}
+
+	update := map[string]interface{}{
+		"Company": Delete,
+		"Address": map[string]interface{}{"State": Delete},
+		"Missing": Delete,
+	}
+	if err := db.Update("users", "a", update); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["Company"]; ok {
+		t.Errorf("Company still present: %v", got)
+	}
+	if _, ok := got["Missing"]; ok {
+		t.Errorf("deleting a missing field added it: %v", got)
+	}
+	address, _ := got["Address"].(map[string]interface{})
+	if _, ok := address["State"]; ok {
+		t.Errorf("Address.State still present: %v", address)
+	}
+	if got["Name"] != "John" || got["Age"] != float64(23) || address["City"] != "bangalore" {
+		t.Errorf("other fields changed: %v", got)
+	}
+
+	// Delete works on string struct fields too.
+	if err := db.Update("users", "a", struct{ Contact, Name string }{Delete, "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["Contact"]; ok || got["Name"] != "Jane" {
+		t.Errorf("record = %v, want Contact removed and Name Jane", got)
+	}
+}
+
+func TestUpdateOmitempty(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23", Contact: "555", Company: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contact is left out by omitempty, so it is never touched; Company is
+	// present with its zero value, which Update ignores.
+	type partial struct {
+		Name    string `json:",omitempty"`
+		Contact string `json:",omitempty"`
+		Company string
+	}
+	if err := db.Update("users", "a", partial{Name: "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Jane" || got.Contact != "555" || got.Company != "acme" || got.Age != "23" {
+		t.Errorf("after Update = %+v, want only Name changed", got)
+	}
+
+	// UpdateReplace stores the zero value of a present key, but still
+	// leaves absent keys alone.
+	if err := db.UpdateReplace("users", "a", partial{Name: "Joe"}); err != nil {
+		t.Fatal(err)
+	}
+	got = testUser{}
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Joe" || got.Contact != "555" || got.Company != "" {
+		t.Errorf("after UpdateReplace = %+v, want Company cleared and Contact kept", got)
+	}
+}
+
+func TestDeleteMarkerNotStored(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "replaced", map[string]interface{}{"Name": "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	record := map[string]interface{}{
+		"Name":    "Jane",
+		"Company": Delete,
+		"Address": map[string]interface{}{"City": "Pune", "State": Delete},
+	}
+
+	ops := []struct {
+		name  string
+		write func() (string, error)
+	}{
+		{"Write", func() (string, error) { return db.Write("users", record) }},
+		{"WriteWithID", func() (string, error) { return "new", db.WriteWithID("users", "new", record) }},
+		{"Replace", func() (string, error) { return "replaced", db.Replace("users", "replaced", record) }},
+		{"Upsert", func() (string, error) {
+			created, err := db.Upsert("users", "upserted", record)
+			if err == nil && !created {
+				t.Error("Upsert did not create the record")
+			}
+			return "upserted", err
+		}},
+		{"WriteWithTTL", func() (string, error) { return db.WriteWithTTL("users", record, time.Hour) }},
+		{"Tx.Write", func() (string, error) {
+			tx := db.Begin("users")
+			id, err := tx.Write(record)
+			if err != nil {
+				return "", err
+			}
+			return id, tx.Commit()
+		}},
+	}
+
+	for _, op := range ops {
+		id, err := op.write()
+		if err != nil {
+			t.Fatalf("%s: %s", op.name, err)
+		}
+
+		data, err := os.ReadFile(db.Path("users", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "bdb:delete") {
+			t.Errorf("%s stored the Delete marker: %s", op.name, data)
+		}
+
+		got := readRaw(t, db, "users", id)
+		address, _ := got["Address"].(map[string]interface{})
+		if _, ok := got["Company"]; ok || address["State"] != nil || got["Name"] != "Jane" || address["City"] != "Pune" {
+			t.Errorf("%s stored %v", op.name, got)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package bdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/babu10103/bdb/util"
+)
+
+const (
+	// createdAtField holds the RFC 3339 time a record was written, if
+	// Options.Timestamps is set.
+	createdAtField = "_created_at"
+	// updatedAtField holds the RFC 3339 time a record was last updated or
+	// replaced, if Options.Timestamps is set.
+	updatedAtField = "_updated_at"
+)
+
+// Meta describes a stored record.
+type Meta struct {
+	// CreatedAt is the time the record was written, zero if it carries no
+	// timestamp.
+	CreatedAt time.Time
+	// UpdatedAt is the time the record was last updated or replaced, zero
+	// if it never was or carries no timestamp.
+	UpdatedAt time.Time
+	// Size is the size of the record file in bytes, as stored on disk.
+	Size int64
+}
+
+// Metadata returns the timestamps and size of a record.
+//
+// Timestamps are only stamped while Options.Timestamps is set, so records
+// written before, or without it, have zero times.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+//
+// Returns:
+// - Meta: The metadata of the record.
+// - error: An error if the record cannot be read or holds an invalid timestamp.
+func (d *Driver) Metadata(collection, resource string) (Meta, error) {
+	if err := d.checkOpen(); err != nil {
+		return Meta{}, err
+	}
+
+	if collection == "" {
+		return Meta{}, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return Meta{}, err
+	}
+
+	if resource == "" {
+		return Meta{}, ErrResourceMissing
+	}
+
+	if err := validateName(resource); err != nil {
+		return Meta{}, err
+	}
+
+	path, err := d.locateRecord(collection, resource)
+	if err != nil {
+		return Meta{}, lookupError("resource", path, err)
+	}
+
+	fi, err := d.storage.Stat(path)
+	if err != nil {
+		return Meta{}, lookupError("resource", path, err)
+	}
+
+	bytes, err := d.readRecord(path)
+	if err != nil {
+		return Meta{}, fmt.Errorf("error reading file: %s (%s)", path, err)
+	}
+
+	var record map[string]interface{}
+	if err := d.codecFor(collection).Unmarshal(bytes, &record); err != nil {
+		return Meta{}, fmt.Errorf("error unmarshalling json: %s", err)
+	}
+
+	meta := Meta{Size: fi.Size()}
+
+	for field, t := range map[string]*time.Time{createdAtField: &meta.CreatedAt, updatedAtField: &meta.UpdatedAt} {
+		value, ok := record[field].(string)
+		if !ok {
+			continue
+		}
+		if *t, err = time.Parse(time.RFC3339, value); err != nil {
+			return Meta{}, fmt.Errorf("invalid %s in record: %s (%s)", field, path, err)
+		}
+	}
+
+	return meta, nil
+}
+
+// stampCreated sets the creation time of a new record, if timestamps are
+// enabled.
+//
+// Parameters:
+// - record: The record about to be written.
+func (d *Driver) stampCreated(record map[string]interface{}) {
+	if !d.timestamps {
+		return
+	}
+
+	record[createdAtField] = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// stampUpdated sets the update time of a changed record and carries over
+// its creation time, if timestamps are enabled.
+//
+// Parameters:
+// - record: The record about to be written.
+// - created: The creation time stored before the change, nil if none.
+func (d *Driver) stampUpdated(record map[string]interface{}, created interface{}) {
+	if !d.timestamps {
+		return
+	}
+
+	if created != nil {
+		record[createdAtField] = created
+	} else {
+		delete(record, createdAtField)
+	}
+
+	record[updatedAtField] = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// ReadAllOrdered retrieves the records of a collection ordered by creation
+// time, oldest or newest first.
+//
+// The creation time of a record is its "_created_at" field, stamped when
+// Options.Timestamps is set, or else the time embedded in its ID if the ID
+// was generated by util.GenerateObjectId, as Write does by default. Records
+// created at the same time are ordered by ID, in the same direction. If
+// any record has neither, e.g. as it was written by WriteWithID without
+// timestamps, the records are ordered by ID instead, like ReadAll, reversed
+// for newest first. Soft-deleted and expired records are left out.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - newestFirst: Whether the most recently created records come first.
+//
+// Returns:
+// - []string: The records.
+// - error: An error if the records cannot be read or decoded.
+func (d *Driver) ReadAllOrdered(collection string, newestFirst bool) (records []string, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	records, err = d.readAll(context.Background(), collection, false)
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		record  string
+		id      string
+		created time.Time
+	}
+
+	entries := make([]entry, len(records))
+	timed := true
+
+	for i, record := range records {
+		data, err := d.decodeRecord(collection, []byte(record))
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %w", err)
+		}
+
+		e := entry{record: record}
+		e.id, _ = data["_id"].(string)
+
+		var ok bool
+		if value, isString := data[createdAtField].(string); isString {
+			e.created, err = time.Parse(time.RFC3339, value)
+			ok = err == nil
+		}
+		if !ok {
+			e.created, ok = util.ObjectIdTime(e.id)
+		}
+
+		timed = timed && ok
+		entries[i] = e
+	}
+
+	// records are sorted by ID already, so only the timed order needs a
+	// sort; a stable one keeps the ID order for equal times.
+	if timed {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].created.Before(entries[j].created)
+		})
+	}
+
+	for i, e := range entries {
+		if newestFirst {
+			records[len(entries)-1-i] = e.record
+		} else {
+			records[i] = e.record
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,156 @@
+package bdb
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	db := newTestDriver(t, &Options{Timestamps: true})
+
+	before := time.Now()
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := db.Metadata("users", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CreatedAt.Before(before.Truncate(time.Second)) || created.CreatedAt.After(time.Now()) {
+		t.Errorf("CreatedAt = %s, want the time of the write", created.CreatedAt)
+	}
+	if !created.UpdatedAt.IsZero() {
+		t.Errorf("UpdatedAt = %s before any update, want zero", created.UpdatedAt)
+	}
+
+	fi, err := os.Stat(db.recordPath("users", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Size != fi.Size() {
+		t.Errorf("Size = %d, want %d", created.Size, fi.Size())
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := db.Update("users", "a", map[string]interface{}{"Name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := db.Metadata("users", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("CreatedAt changed by Update: %s, was %s", updated.CreatedAt, created.CreatedAt)
+	}
+	if !updated.UpdatedAt.After(created.CreatedAt) {
+		t.Errorf("UpdatedAt = %s, want after %s", updated.UpdatedAt, created.CreatedAt)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := db.Replace("users", "a", testUser{Name: "Joe"}); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := db.Metadata("users", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replaced.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("CreatedAt changed by Replace: %s, was %s", replaced.CreatedAt, created.CreatedAt)
+	}
+	if !replaced.UpdatedAt.After(updated.UpdatedAt) {
+		t.Errorf("UpdatedAt = %s after Replace, want after %s", replaced.UpdatedAt, updated.UpdatedAt)
+	}
+}
+
+func TestMetadataWithoutTimestamps(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := db.Metadata("users", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.CreatedAt.IsZero() || !meta.UpdatedAt.IsZero() || meta.Size == 0 {
+		t.Errorf("Metadata = %+v, want zero times and the size", meta)
+	}
+}
+
+// orderedIDs returns the IDs of the records ReadAllOrdered returns.
+func orderedIDs(t *testing.T, db *Driver, collection string, newestFirst bool) []string {
+	t.Helper()
+
+	records, err := db.ReadAllOrdered(collection, newestFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, len(records))
+	for i, record := range records {
+		var v struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal([]byte(record), &v); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+func TestReadAllOrderedByGeneratedID(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := db.Write("events", map[string]interface{}{"n": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if got := orderedIDs(t, db, "events", true); !reflect.DeepEqual(got, []string{ids[2], ids[1], ids[0]}) {
+		t.Errorf("newest first = %v, want %v reversed", got, ids)
+	}
+	if got := orderedIDs(t, db, "events", false); !reflect.DeepEqual(got, ids) {
+		t.Errorf("oldest first = %v, want %v", got, ids)
+	}
+}
+
+func TestReadAllOrderedByTimestamp(t *testing.T) {
+	db := newTestDriver(t, &Options{Timestamps: true})
+
+	// The IDs sort the other way round from the creation times.
+	for _, id := range []string{"c", "b", "a"} {
+		if err := db.WriteWithID("events", id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if got := orderedIDs(t, db, "events", true); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("newest first = %v, want [a b c]", got)
+	}
+}
+
+func TestReadAllOrderedFallsBackToIDs(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	for _, id := range []string{"b", "c", "a"} {
+		if err := db.WriteWithID("events", id, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := orderedIDs(t, db, "events", true); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("newest first = %v, want [c b a]", got)
+	}
+}
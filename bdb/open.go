@@ -0,0 +1,290 @@
+package bdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// lockFileName is the advisory lock file, relative to the database
+// directory, held by the process that has the database open.
+const lockFileName = ".bdb.lock"
+
+// ErrLocked is returned by New when another process has the database open.
+var ErrLocked = errors.New("database is locked by another process")
+
+// ErrOptionsConflict is returned by New when the database is already open
+// in the process with options it cannot share.
+var ErrOptionsConflict = errors.New("database is already open with different options")
+
+// ErrClosed is returned by the operations of a closed driver.
+var ErrClosed = errors.New("database is closed")
+
+var (
+	// openMutex serializes New and Close, so concurrent calls for the same
+	// directory neither race to create it nor open it twice.
+	openMutex sync.Mutex
+	// openDrivers are the drivers open on disk, by absolute directory.
+	openDrivers = map[string]*Driver{}
+)
+
+// New creates a new database driver.
+//
+// A database on disk is meant to be used by a single process at a time:
+// New takes an advisory lock on the "<dir>/.bdb.lock" file, released by
+// Close, and fails with ErrLocked if another process holds it. Read-only
+// drivers take a shared lock instead, so several processes may read a
+// database nobody writes to. The lock is advisory only, and not taken on
+// platforms without flock(2).
+//
+// Within a process, New returns the driver already open on the same
+// directory, and each call must be matched by a call to Close. The options
+// must then agree with the open driver on how records are stored and
+// whether they can be changed: ReadOnly, DryRun, Codec, Compress,
+// EncryptionKey, Checksum, ShardDepth, StrictInsert, MaxRecordBytes,
+// FileMode and DirMode. New fails with ErrOptionsConflict otherwise; the
+// other options, such as Logger or CacheSize, are those of the open driver.
+// Other storages, such as NewMemStorage, are not locked and get a new
+// driver each time.
+//
+// Parameters:
+// - dir: The directory where the database is stored.
+// - options: Additional options for the database (optional).
+//
+// Returns:
+// - *Driver: The newly created database driver.
+// - error: An error if the database cannot be created, is locked or is open with conflicting options.
+func New(dir string, options *Options) (*Driver, error) {
+	if options != nil && options.Storage != nil {
+		if _, ok := options.Storage.(DiskStorage); !ok {
+			return open(dir, options)
+		}
+	}
+
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve database directory: %s (%s)", dir, err)
+	}
+
+	openMutex.Lock()
+	defer openMutex.Unlock()
+
+	if driver, ok := openDrivers[key]; ok {
+		if err := driver.checkOptions(options); err != nil {
+			return nil, err
+		}
+		driver.refs++
+		driver.log.Debug("Using '%s' (database already open)\n", driver.dir)
+		return driver, nil
+	}
+
+	driver, err := open(dir, options)
+	if err != nil {
+		return driver, err
+	}
+
+	driver.refs = 1
+	openDrivers[key] = driver
+
+	return driver, nil
+}
+
+// checkOptions checks that the options of a New call can share the driver
+// already open on the directory.
+//
+// Parameters:
+// - options: The options passed to New, nil for the defaults.
+//
+// Returns:
+// - error: ErrOptionsConflict, naming the options that differ, or nil.
+func (d *Driver) checkOptions(options *Options) error {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
+
+	var conflicts []string
+	check := func(name string, same bool) {
+		if !same {
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	check("ReadOnly", opts.ReadOnly == d.readOnly)
+	check("DryRun", opts.DryRun == d.dryRun)
+	check("Codec", opts.Codec.Ext() == d.codec.Ext())
+	check("Compress", opts.Compress == d.compress)
+	check("EncryptionKey", bytes.Equal(opts.EncryptionKey, d.key))
+	check("Checksum", opts.Checksum == d.checksum)
+	check("ShardDepth", opts.ShardDepth == d.shardDepth)
+	check("StrictInsert", opts.StrictInsert == d.strictInsert)
+	check("MaxRecordBytes", opts.MaxRecordBytes == d.maxRecord)
+	check("FileMode", opts.FileMode == d.fileMode)
+	check("DirMode", opts.DirMode == d.dirMode)
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s (%s)", ErrOptionsConflict, d.dir, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// closers holds the functions Close calls to stop what runs in the
+// background, such as watchers and reapers.
+type closers struct {
+	mutex  sync.Mutex
+	nextID int
+	funcs  map[int]func()
+	done   bool
+}
+
+// Close releases the driver. Once every New call that returned it has been
+// matched by a Close, the driver is closed: reapers started with
+// StartReaper are stopped, the channels of Watch and WatchFS are closed,
+// the lock on the database directory is released, and every other
+// operation returns ErrClosed. Operations already in progress are not
+// waited for. Stats remains available, so final metrics can still be
+// collected. Closing a closed driver does nothing.
+//
+// Returns:
+// - error: An error if the lock file cannot be closed.
+func (d *Driver) Close() error {
+	openMutex.Lock()
+	defer openMutex.Unlock()
+
+	if d.closed.Load() {
+		return nil
+	}
+
+	if d.refs > 1 {
+		d.refs--
+		return nil
+	}
+
+	for key, driver := range openDrivers {
+		if driver == d {
+			delete(openDrivers, key)
+		}
+	}
+	d.refs = 0
+
+	d.closed.Store(true)
+
+	d.closers.mutex.Lock()
+	funcs := d.closers.funcs
+	d.closers.funcs = nil
+	d.closers.done = true
+	d.closers.mutex.Unlock()
+
+	for _, fn := range funcs {
+		fn()
+	}
+
+	d.log.Debug("Closed '%s'\n", d.dir)
+
+	return d.unlockDir()
+}
+
+// onClose registers a function for Close to call.
+//
+// Parameters:
+// - fn: The function, e.g. stopping a watcher.
+//
+// Returns:
+// - func(): The function unregistering fn, to call once it has run otherwise; a no-op if fn was not registered.
+// - error: ErrClosed if the driver was closed meanwhile, in which case fn is not registered.
+func (d *Driver) onClose(fn func()) (func(), error) {
+	d.closers.mutex.Lock()
+	defer d.closers.mutex.Unlock()
+
+	if d.closers.done {
+		return func() {}, ErrClosed
+	}
+
+	if d.closers.funcs == nil {
+		d.closers.funcs = make(map[int]func())
+	}
+
+	id := d.closers.nextID
+	d.closers.nextID++
+	d.closers.funcs[id] = fn
+
+	return func() {
+		d.closers.mutex.Lock()
+		defer d.closers.mutex.Unlock()
+
+		delete(d.closers.funcs, id)
+	}, nil
+}
+
+// checkOpen returns ErrClosed once the driver is closed.
+//
+// Returns:
+// - error: ErrClosed, or nil if the driver is open.
+func (d *Driver) checkOpen() error {
+	if d.closed.Load() {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// lockDir takes the advisory lock on the database directory, if it is
+// stored on disk: exclusive for a writable driver, shared for a read-only
+// one. A read-only driver does not create the lock file, so it does not
+// lock a database that has never been opened for writing.
+//
+// Returns:
+// - error: ErrLocked if another process holds the lock, or an error if the lock file cannot be opened.
+func (d *Driver) lockDir() error {
+	if _, ok := d.storage.(DiskStorage); !ok {
+		return nil
+	}
+
+	path := filepath.Join(d.dir, lockFileName)
+
+	flag := os.O_RDWR | os.O_CREATE
+	if d.readOnly {
+		flag = os.O_RDONLY
+	}
+
+	file, err := os.OpenFile(path, flag, d.filePerm())
+	if d.readOnly && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open lock file: %s (%s)", path, err)
+	}
+
+	if err := flock(file, !d.readOnly); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: %s (%s)", ErrLocked, path, err)
+	}
+
+	d.lockFile = file
+
+	return nil
+}
+
+// unlockDir releases the advisory lock on the database directory, if held.
+//
+// Returns:
+// - error: An error if the lock file cannot be closed.
+func (d *Driver) unlockDir() error {
+	if d.lockFile == nil {
+		return nil
+	}
+
+	file := d.lockFile
+	d.lockFile = nil
+
+	// Closing the file releases the lock.
+	return file.Close()
+}
@@ -0,0 +1,59 @@
+package bdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewSharesOpenDriver(t *testing.T) {
+	dir := t.TempDir()
+
+	first := openTestDriver(t, dir, nil)
+	second := openTestDriver(t, dir, nil)
+	if first != second {
+		t.Fatal("New returned a second driver for an open directory")
+	}
+
+	// The driver stays open until every New is matched by a Close.
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteWithID("users", "a", map[string]interface{}{}); err != nil {
+		t.Errorf("write after the first Close: %s", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteWithID("users", "b", map[string]interface{}{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("write after the last Close: got %v, want ErrClosed", err)
+	}
+
+	third := openTestDriver(t, dir, nil)
+	if third == first {
+		t.Error("New returned the closed driver")
+	}
+}
+
+func TestNewRejectsConflictingOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, &Options{Checksum: true})
+
+	conflicting := map[string]*Options{
+		"defaults": nil,
+		"ReadOnly": {Checksum: true, ReadOnly: true},
+		"Codec":    {Checksum: true, Codec: YAMLCodec{}},
+		"key":      {Checksum: true, EncryptionKey: make([]byte, 32)},
+	}
+	for name, opts := range conflicting {
+		if _, err := New(dir, opts); !errors.Is(err, ErrOptionsConflict) {
+			t.Errorf("%s: got %v, want ErrOptionsConflict", name, err)
+		}
+	}
+
+	// Options that do not change how records are stored may differ.
+	shared := openTestDriver(t, dir, &Options{Checksum: true, CacheSize: 10})
+	if shared != db {
+		t.Error("New returned a second driver for compatible options")
+	}
+}
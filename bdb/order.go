@@ -0,0 +1,294 @@
+package bdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// keyOrder is the order of the keys of a JSON object, and of the objects
+// nested in it, as found in a document.
+type keyOrder struct {
+	keys []string
+	// children holds the order of the objects under each key. For an array,
+	// it is the order of the objects it holds, merged.
+	children map[string]*keyOrder
+}
+
+// keyOrderOf returns the key order v marshals to, if
+// Options.PreserveFieldOrder is set and records are stored as JSON.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - v: The value about to be stored, e.g. a struct or a json.RawMessage.
+//
+// Returns:
+// - *keyOrder: The key order, nil if it is not preserved or v cannot be marshalled.
+func (d *Driver) keyOrderOf(collection string, v interface{}) *keyOrder {
+	if !d.preserveOrder {
+		return nil
+	}
+
+	if _, ok := d.codecFor(collection).(JSONCodec); !ok {
+		return nil
+	}
+
+	data, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil
+		}
+	}
+
+	return parseKeyOrder(data)
+}
+
+// recordKeyOrder returns the key order of a stored record, if
+// Options.PreserveFieldOrder is set and records are stored as JSON.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - data: The encoded record.
+//
+// Returns:
+// - *keyOrder: The key order, nil if it is not preserved.
+func (d *Driver) recordKeyOrder(collection string, data []byte) *keyOrder {
+	return d.keyOrderOf(collection, json.RawMessage(data))
+}
+
+// marshalRecord encodes a record with the configured codec, writing the
+// keys in the given order. Keys the order does not know, such as new fields
+// or "_id", follow in alphabetical order. Orders only apply to JSONCodec.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - record: The record.
+// - order: The key order, nil for alphabetical order.
+//
+// Returns:
+// - []byte: The encoded record.
+// - error: ErrRecordTooLarge if the record exceeds Options.MaxRecordBytes, or an error if it cannot be encoded.
+func (d *Driver) marshalRecord(collection string, record map[string]interface{}, order *keyOrder) ([]byte, error) {
+	if err := d.stampChecksum(record); err != nil {
+		return nil, err
+	}
+
+	data, err := d.encodeRecord(collection, record, order)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.maxRecord > 0 && int64(len(data)) > d.maxRecord {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrRecordTooLarge, len(data), d.maxRecord)
+	}
+
+	return data, nil
+}
+
+// encodeRecord encodes a record for marshalRecord, without checking its size.
+func (d *Driver) encodeRecord(collection string, record map[string]interface{}, order *keyOrder) ([]byte, error) {
+	codec := d.codecFor(collection)
+	jsonCodec, ok := codec.(JSONCodec)
+	if order == nil || !ok {
+		return codec.Marshal(record)
+	}
+
+	var compact bytes.Buffer
+	if err := writeOrdered(&compact, record, order); err != nil {
+		return nil, err
+	}
+
+	if jsonCodec.Compact {
+		return compact.Bytes(), nil
+	}
+
+	// Indent like JSONCodec, the only codec orders are kept for.
+	var out bytes.Buffer
+	if err := json.Indent(&out, compact.Bytes(), "", jsonCodec.indent()); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+
+	return out.Bytes(), nil
+}
+
+// writeOrdered writes value as compact JSON, writing the keys of objects
+// in the given order.
+func writeOrdered(buf *bytes.Buffer, value interface{}, order *keyOrder) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		buf.WriteByte('{')
+		for i, key := range order.sorted(value) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			name, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+
+			if err := writeOrdered(buf, value[key], order.child(key)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeOrdered(buf, item, order); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	default:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+
+	return nil
+}
+
+// parseKeyOrder reads the key order of a JSON document.
+//
+// Parameters:
+// - data: The JSON document.
+//
+// Returns:
+// - *keyOrder: The key order, nil if data is not a valid JSON object.
+func parseKeyOrder(data []byte) *keyOrder {
+	order, err := readKeyOrder(json.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		return nil
+	}
+
+	return order
+}
+
+// readKeyOrder reads the key order of the next JSON value of a decoder.
+// Scalars have no order and return nil.
+func readKeyOrder(decoder *json.Decoder) (*keyOrder, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		order := &keyOrder{children: map[string]*keyOrder{}}
+
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := token.(string)
+
+			child, err := readKeyOrder(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			order.keys = append(order.keys, key)
+			if child != nil {
+				order.children[key] = child
+			}
+		}
+
+		_, err := decoder.Token()
+		return order, err
+
+	case json.Delim('['):
+		var items *keyOrder
+
+		for decoder.More() {
+			child, err := readKeyOrder(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = items.merge(child)
+		}
+
+		_, err := decoder.Token()
+		return items, err
+	}
+
+	return nil, nil
+}
+
+// merge returns the order of o followed by the keys only other knows.
+// Either may be nil.
+func (o *keyOrder) merge(other *keyOrder) *keyOrder {
+	if o == nil {
+		return other
+	}
+	if other == nil {
+		return o
+	}
+
+	merged := &keyOrder{keys: append([]string(nil), o.keys...), children: map[string]*keyOrder{}}
+	for key, child := range o.children {
+		merged.children[key] = child
+	}
+
+	known := make(map[string]bool, len(o.keys))
+	for _, key := range o.keys {
+		known[key] = true
+	}
+
+	for _, key := range other.keys {
+		if !known[key] {
+			merged.keys = append(merged.keys, key)
+		}
+		merged.children[key] = merged.children[key].merge(other.children[key])
+	}
+
+	return merged
+}
+
+// child returns the order of the object under key, nil if unknown.
+func (o *keyOrder) child(key string) *keyOrder {
+	if o == nil {
+		return nil
+	}
+
+	return o.children[key]
+}
+
+// sorted returns the keys of object, those known to o first and in its
+// order, then the others alphabetically.
+func (o *keyOrder) sorted(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	seen := make(map[string]bool, len(object))
+
+	if o != nil {
+		for _, key := range o.keys {
+			if _, ok := object[key]; ok && !seen[key] {
+				keys = append(keys, key)
+				seen[key] = true
+			}
+		}
+	}
+
+	rest := make([]string, 0, len(object)-len(keys))
+	for key := range object {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(keys, rest...)
+}
@@ -0,0 +1,81 @@
+package bdb
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fileKeys returns the top-level keys of a JSON record file, in file order.
+func fileKeys(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	if _, err := dec.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.(string))
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func TestPreserveFieldOrder(t *testing.T) {
+	db := newTestDriver(t, &Options{PreserveFieldOrder: true})
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23"}); err != nil {
+		t.Fatal(err)
+	}
+	path := db.recordPath("users", "a")
+
+	want := []string{"Name", "Age", "Contact", "Company", "Address", "_id", "_version"}
+	if keys := fileKeys(t, path); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys after Write = %v, want %v", keys, want)
+	}
+
+	// The order on disk is kept; new fields follow in the order of the update.
+	update := struct {
+		Zip   string
+		Age   json.Number
+		Alias string
+	}{"x", "24", "jj"}
+	if err := db.Update("users", "a", update); err != nil {
+		t.Fatal(err)
+	}
+
+	want = []string{"Name", "Age", "Contact", "Company", "Address", "_id", "_version", "Zip", "Alias"}
+	if keys := fileKeys(t, path); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys after Update = %v, want %v", keys, want)
+	}
+}
+
+func TestSortedFieldsByDefault(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Address", "Age", "Company", "Contact", "Name", "_id", "_version"}
+	if keys := fileKeys(t, db.recordPath("users", "a")); !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,617 @@
+package bdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// Patch sets a single value inside a record, addressed by an RFC 6901 JSON
+// Pointer such as "/Address/City".
+//
+// Missing or null objects along the pointer are created. Array elements are
+// addressed by index, and "-" appends to an array. The record is read,
+// changed and written back while holding its lock, like Update.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+// - pointer: The JSON Pointer of the value to set.
+// - value: The value, stored as it marshals to JSON.
+//
+// Returns:
+// - error: An error if the pointer is invalid, goes through a value that is neither an object nor an array, or the update fails.
+func (d *Driver) Patch(collection, resource, pointer string, value interface{}) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 || tokens[0] == "_id" {
+		return fmt.Errorf("invalid pointer: %q (cannot replace the record or its id)", pointer)
+	}
+
+	normalized, err := toJSONValue(value)
+	if err != nil {
+		return fmt.Errorf("error converting value: %s", err)
+	}
+
+	return d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, false, func(_, existing map[string]interface{}) error {
+		return setPointer(existing, tokens, pointer, normalized)
+	})
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens,
+// unescaping "~1" to "/" and "~0" to "~".
+//
+// Parameters:
+// - pointer: The JSON Pointer, empty for the whole document.
+//
+// Returns:
+// - []string: The reference tokens.
+// - error: An error if the pointer does not start with "/" or holds an invalid escape.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer: %q (must start with '/')", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, fmt.Errorf("invalid pointer: %q (bad escape in '%s')", pointer, token)
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses a reference token addressing an array element.
+//
+// Parameters:
+// - token: The reference token.
+// - length: The length of the array.
+// - allowEnd: Whether "-", or the length itself, may address the end of the array.
+//
+// Returns:
+// - int: The index.
+// - error: An error if the token is not an index within the array.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	// Leading zeros are not allowed by RFC 6901.
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index: '%s'", token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || (index == length && !allowEnd) {
+		return 0, fmt.Errorf("invalid array index: '%s'", token)
+	}
+
+	return index, nil
+}
+
+// setPointer sets the value a pointer addresses in a document, creating
+// the missing objects along it.
+//
+// Parameters:
+// - doc: The document, changed in place.
+// - tokens: The reference tokens of the pointer, at least one.
+// - pointer: The pointer, for error messages.
+// - value: The value to set.
+//
+// Returns:
+// - error: An error if the pointer goes through a scalar or an invalid array index.
+func setPointer(doc map[string]interface{}, tokens []string, pointer string, value interface{}) error {
+	var parent interface{} = doc
+
+	for i, token := range tokens[:len(tokens)-1] {
+		var child interface{}
+
+		switch container := parent.(type) {
+		case map[string]interface{}:
+			child = container[token]
+			if child == nil {
+				child = map[string]interface{}{}
+				container[token] = child
+			}
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return fmt.Errorf("invalid pointer: %q (%s)", pointer, err)
+			}
+			child = container[index]
+			if child == nil {
+				child = map[string]interface{}{}
+				container[index] = child
+			}
+		default:
+			return fmt.Errorf("invalid pointer: %q (/%s is not an object or array)", pointer, strings.Join(tokens[:i], "/"))
+		}
+
+		parent = child
+	}
+
+	last := tokens[len(tokens)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[last] = value
+		return nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(container), true)
+		if err != nil {
+			return fmt.Errorf("invalid pointer: %q (%s)", pointer, err)
+		}
+		if index == len(container) {
+			return setPointer(doc, tokens[:len(tokens)-1], pointer, append(container, value))
+		}
+		container[index] = value
+		return nil
+	default:
+		return fmt.Errorf("invalid pointer: %q (/%s is not an object or array)", pointer, strings.Join(tokens[:len(tokens)-1], "/"))
+	}
+}
+
+// toJSONValue converts a value into the generic form of a decoded record,
+// keeping numbers as json.Number.
+//
+// Parameters:
+// - v: The value.
+//
+// Returns:
+// - interface{}: The converted value.
+// - error: An error if the value cannot be marshalled.
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJSONValue(data)
+}
+
+// ErrPatchTestFailed is returned by ApplyJSONPatch when a "test" operation
+// does not match the record.
+var ErrPatchTestFailed = errors.New("json patch test failed")
+
+// patchOperation is an operation of an RFC 6902 JSON Patch document.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch document, the format of
+// HTTP PATCH requests with the "application/json-patch+json" media type,
+// to a record.
+//
+// The operations ("add", "remove", "replace", "move", "copy" and "test")
+// are applied in order while holding the lock of the record, and the
+// result is written atomically. If an operation is invalid or a "test"
+// fails, nothing is written and the record is left unchanged. Operations
+// may not replace the whole record or change its "_id" field.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+// - patch: The JSON Patch document, an array of operations.
+//
+// Returns:
+// - error: ErrPatchTestFailed if a test fails, or an error if the patch is invalid or the update fails.
+func (d *Driver) ApplyJSONPatch(collection, resource string, patch []byte) error {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("invalid json patch: %s", err)
+	}
+
+	return d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, false, func(_, existing map[string]interface{}) error {
+		for i, op := range ops {
+			if err := applyOperation(existing, op); err != nil {
+				return fmt.Errorf("json patch operation %d (%s): %w", i, op.Op, err)
+			}
+		}
+		return nil
+	})
+}
+
+// applyOperation applies a JSON Patch operation to a record.
+//
+// Parameters:
+// - doc: The record, changed in place.
+// - op: The operation.
+//
+// Returns:
+// - error: An error if the operation is invalid or, for "test", does not match.
+func applyOperation(doc map[string]interface{}, op patchOperation) error {
+	if op.Path == nil {
+		return fmt.Errorf("missing path")
+	}
+
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return err
+	}
+
+	var from []string
+	if op.Op == "move" || op.Op == "copy" {
+		if op.From == nil {
+			return fmt.Errorf("missing from")
+		}
+		if from, err = parsePointer(*op.From); err != nil {
+			return err
+		}
+	}
+
+	var value interface{}
+	if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+		if op.Value == nil {
+			return fmt.Errorf("missing value")
+		}
+		if value, err = decodeJSONValue(op.Value); err != nil {
+			return fmt.Errorf("invalid value: %s", err)
+		}
+	}
+
+	if op.Op != "test" && (len(path) == 0 || path[0] == "_id") {
+		return fmt.Errorf("invalid path: %q (cannot replace the record or its id)", *op.Path)
+	}
+
+	switch op.Op {
+	case "add":
+		return patchPointer(doc, path, addMember(value))
+
+	case "remove":
+		return patchPointer(doc, path, removeMember(nil))
+
+	case "replace":
+		return patchPointer(doc, path, replaceMember(value))
+
+	case "move":
+		if len(from) == 0 || from[0] == "_id" {
+			return fmt.Errorf("invalid from: %q (cannot move the record or its id)", *op.From)
+		}
+		if len(from) < len(path) && strings.HasPrefix(*op.Path, *op.From+"/") {
+			return fmt.Errorf("invalid from: %q (cannot move a value into itself)", *op.From)
+		}
+		var moved interface{}
+		if err := patchPointer(doc, from, removeMember(&moved)); err != nil {
+			return err
+		}
+		return patchPointer(doc, path, addMember(moved))
+
+	case "copy":
+		source, err := getPointer(doc, from)
+		if err != nil {
+			return err
+		}
+		// The copy must not share maps or slices with its source.
+		copied, err := toJSONValue(source)
+		if err != nil {
+			return err
+		}
+		return patchPointer(doc, path, addMember(copied))
+
+	case "test":
+		actual, err := getPointer(doc, path)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrPatchTestFailed, err)
+		}
+		if !jsonEqual(actual, value) {
+			return fmt.Errorf("%w: value at %q differs", ErrPatchTestFailed, *op.Path)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+}
+
+// getPointer returns the value a pointer addresses in a document.
+//
+// Parameters:
+// - doc: The document.
+// - tokens: The reference tokens of the pointer.
+//
+// Returns:
+// - interface{}: The value.
+// - error: An error if the value does not exist.
+func getPointer(doc interface{}, tokens []string) (interface{}, error) {
+	current := doc
+
+	for i, token := range tokens {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: /%s", strings.Join(tokens[:i+1], "/"))
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			current = container[index]
+		default:
+			return nil, fmt.Errorf("path not found: /%s", strings.Join(tokens[:i+1], "/"))
+		}
+	}
+
+	return current, nil
+}
+
+// patchPointer calls change on the object or array holding the value a
+// pointer addresses, storing the containers it returns back along the
+// pointer, as changing the length of an array makes a new slice.
+//
+// Parameters:
+// - doc: The document, changed in place.
+// - tokens: The reference tokens of the pointer, at least one.
+// - change: Changes the container at the last token and returns it.
+//
+// Returns:
+// - error: An error if a container along the pointer does not exist, or change fails.
+func patchPointer(doc map[string]interface{}, tokens []string, change func(container interface{}, token string) (interface{}, error)) error {
+	var walk func(node interface{}, tokens []string) (interface{}, error)
+	walk = func(node interface{}, rest []string) (interface{}, error) {
+		if len(rest) == 1 {
+			return change(node, rest[0])
+		}
+
+		path := "/" + strings.Join(tokens[:len(tokens)-len(rest)+1], "/")
+
+		switch container := node.(type) {
+		case map[string]interface{}:
+			child, ok := container[rest[0]]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", path)
+			}
+			changed, err := walk(child, rest[1:])
+			if err != nil {
+				return nil, err
+			}
+			container[rest[0]] = changed
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(rest[0], len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			changed, err := walk(container[index], rest[1:])
+			if err != nil {
+				return nil, err
+			}
+			container[index] = changed
+			return container, nil
+		default:
+			return nil, fmt.Errorf("path not found: %s", path)
+		}
+	}
+
+	_, err := walk(doc, tokens)
+	return err
+}
+
+// addMember returns the change of the "add" operation: it sets an object
+// member, or inserts an array element.
+//
+// Parameters:
+// - value: The value to add.
+//
+// Returns:
+// - func(interface{}, string) (interface{}, error): The change, for patchPointer.
+func addMember(value interface{}) func(interface{}, string) (interface{}, error) {
+	return func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("parent of '%s' is not an object or array", token)
+		}
+	}
+}
+
+// removeMember returns the change of the "remove" operation: it deletes
+// an existing object member or array element.
+//
+// Parameters:
+// - removed: Receives the removed value, if not nil.
+//
+// Returns:
+// - func(interface{}, string) (interface{}, error): The change, for patchPointer.
+func removeMember(removed *interface{}) func(interface{}, string) (interface{}, error) {
+	return func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: member '%s'", token)
+			}
+			if removed != nil {
+				*removed = value
+			}
+			delete(container, token)
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			if removed != nil {
+				*removed = container[index]
+			}
+			return append(container[:index], container[index+1:]...), nil
+		default:
+			return nil, fmt.Errorf("parent of '%s' is not an object or array", token)
+		}
+	}
+}
+
+// replaceMember returns the change of the "replace" operation: it sets an
+// existing object member or array element.
+//
+// Parameters:
+// - value: The new value.
+//
+// Returns:
+// - func(interface{}, string) (interface{}, error): The change, for patchPointer.
+func replaceMember(value interface{}) func(interface{}, string) (interface{}, error) {
+	return func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("path not found: member '%s'", token)
+			}
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			container[index] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("parent of '%s' is not an object or array", token)
+		}
+	}
+}
+
+// decodeJSONValue decodes a JSON value, keeping numbers as json.Number.
+//
+// Parameters:
+// - data: The encoded value.
+//
+// Returns:
+// - interface{}: The decoded value.
+// - error: An error if data is not valid JSON.
+func decodeJSONValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// jsonEqual reports whether two values are equal as JSON values, as RFC
+// 6902 "test" requires: numbers are compared by value and objects
+// regardless of member order.
+//
+// Parameters:
+// - a: The first value.
+// - b: The second value.
+//
+// Returns:
+// - bool: True if the values are equal.
+func jsonEqual(a, b interface{}) bool {
+	na, err := util.Normalize(a)
+	if err != nil {
+		return false
+	}
+
+	nb, err := util.Normalize(b)
+	if err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(na, nb)
+}
+
+// MergePatch applies an RFC 7386 JSON Merge Patch, the format of HTTP
+// PATCH requests with the "application/merge-patch+json" media type, to a
+// record.
+//
+// Unlike Update, members of the patch set to null remove the field, and
+// every other value, including zero values and arrays, replaces the
+// stored one; objects are merged recursively. The patch must be an object
+// and may not change the "_id" field. The record is written atomically.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource.
+// - patch: The merge patch, a JSON object.
+//
+// Returns:
+// - error: An error if the patch is not a JSON object or the update fails.
+func (d *Driver) MergePatch(collection, resource string, patch []byte) error {
+	decoded, err := decodeJSONValue(patch)
+	if err != nil {
+		return fmt.Errorf("invalid merge patch: %s", err)
+	}
+
+	changes, ok := decoded.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid merge patch: not a JSON object")
+	}
+
+	if _, ok := changes["_id"]; ok {
+		return fmt.Errorf("invalid merge patch: cannot change the record id")
+	}
+
+	return d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, false, func(_, existing map[string]interface{}) error {
+		mergePatch(existing, changes)
+		return nil
+	})
+}
+
+// mergePatch merges an RFC 7386 merge patch object into a target object.
+//
+// Parameters:
+// - target: The target object, changed in place.
+// - patch: The patch object.
+//
+// Returns:
+// - map[string]interface{}: target.
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		if object, ok := value.(map[string]interface{}); ok {
+			child, ok := target[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+			}
+			target[key] = mergePatch(child, object)
+			continue
+		}
+
+		target[key] = value
+	}
+
+	return target
+}
@@ -0,0 +1,234 @@
+package bdb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// readRaw reads a record into a generic map, without the fields the driver
+// adds.
+func readRaw(t *testing.T, db *Driver, collection, id string) map[string]interface{} {
+	t.Helper()
+
+	var record map[string]interface{}
+	if err := db.Read(collection, id, &record); err != nil {
+		t.Fatal(err)
+	}
+	delete(record, "_id")
+	delete(record, versionField)
+	return record
+}
+
+func TestPatch(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	user := testUser{Name: "John", Age: "23", Address: testAddress{City: "bangalore", Pincode: "515671"}}
+	if err := db.WriteWithID("users", "a", user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Patch("users", "a", "/Address/Pincode", json.Number("410013")); err != nil {
+		t.Fatal(err)
+	}
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := user
+	want.Address.Pincode = "410013"
+	if got != want {
+		t.Errorf("after Patch = %+v, want %+v", got, want)
+	}
+
+	// Missing objects are created, and "-" appends to an array.
+	if err := db.Patch("users", "a", "/Profile/Links", []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Patch("users", "a", "/Profile/Links/-", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Patch("users", "a", "/Profile/Links/0", "z"); err != nil {
+		t.Fatal(err)
+	}
+	profile := readRaw(t, db, "users", "a")["Profile"]
+	if want := map[string]interface{}{"Links": []interface{}{"z", "y"}}; !reflect.DeepEqual(profile, want) {
+		t.Errorf("Profile = %v, want %v", profile, want)
+	}
+
+	invalid := map[string]string{
+		"no leading slash": "Address/City",
+		"into a scalar":    "/Name/First",
+		"bad index":        "/Profile/Links/9",
+	}
+	for name, pointer := range invalid {
+		if err := db.Patch("users", "a", pointer, "v"); err == nil {
+			t.Errorf("%s (%s): got nil error", name, pointer)
+		}
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	start := map[string]interface{}{
+		"Name":    "John",
+		"Tags":    []interface{}{"a", "b"},
+		"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		want  map[string]interface{}
+	}{
+		{
+			"add",
+			`[{"op": "add", "path": "/Company", "value": "acme"}, {"op": "add", "path": "/Tags/1", "value": "x"}]`,
+			map[string]interface{}{
+				"Name": "John", "Company": "acme", "Tags": []interface{}{"a", "x", "b"},
+				"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+			},
+		},
+		{
+			"remove",
+			`[{"op": "remove", "path": "/Address/Pincode"}, {"op": "remove", "path": "/Tags/0"}]`,
+			map[string]interface{}{
+				"Name": "John", "Tags": []interface{}{"b"},
+				"Address": map[string]interface{}{"City": "bangalore"},
+			},
+		},
+		{
+			"replace",
+			`[{"op": "replace", "path": "/Name", "value": "Jane"}]`,
+			map[string]interface{}{
+				"Name": "Jane", "Tags": []interface{}{"a", "b"},
+				"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+			},
+		},
+		{
+			"move",
+			`[{"op": "move", "from": "/Address/City", "path": "/City"}]`,
+			map[string]interface{}{
+				"Name": "John", "Tags": []interface{}{"a", "b"}, "City": "bangalore",
+				"Address": map[string]interface{}{"Pincode": "515671"},
+			},
+		},
+		{
+			"copy",
+			`[{"op": "copy", "from": "/Address", "path": "/Billing"}]`,
+			map[string]interface{}{
+				"Name": "John", "Tags": []interface{}{"a", "b"},
+				"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+				"Billing": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+			},
+		},
+		{
+			"test",
+			`[{"op": "test", "path": "/Name", "value": "John"}, {"op": "replace", "path": "/Name", "value": "Joe"}]`,
+			map[string]interface{}{
+				"Name": "Joe", "Tags": []interface{}{"a", "b"},
+				"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDriver(t, nil)
+			if err := db.WriteWithID("users", "a", start); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.ApplyJSONPatch("users", "a", []byte(tt.patch)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readRaw(t, db, "users", "a"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchRollsBack(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	start := map[string]interface{}{"Name": "John", "Company": "acme"}
+	if err := db.WriteWithID("users", "a", start); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := map[string]string{
+		"failed test":    `[{"op": "replace", "path": "/Name", "value": "Jane"}, {"op": "test", "path": "/Company", "value": "other"}]`,
+		"missing path":   `[{"op": "replace", "path": "/Name", "value": "Jane"}, {"op": "remove", "path": "/Missing"}]`,
+		"unknown op":     `[{"op": "replace", "path": "/Name", "value": "Jane"}, {"op": "frobnicate", "path": "/Name"}]`,
+		"change the id":  `[{"op": "replace", "path": "/_id", "value": "b"}]`,
+		"not an array":   `{"op": "remove", "path": "/Name"}`,
+		"whole document": `[{"op": "replace", "path": "", "value": {}}]`,
+	}
+	for name, patch := range failing {
+		err := db.ApplyJSONPatch("users", "a", []byte(patch))
+		if err == nil {
+			t.Errorf("%s: got nil error", name)
+		}
+		if name == "failed test" && !errors.Is(err, ErrPatchTestFailed) {
+			t.Errorf("%s: got %v, want ErrPatchTestFailed", name, err)
+		}
+	}
+
+	if got := readRaw(t, db, "users", "a"); !reflect.DeepEqual(got, start) {
+		t.Errorf("record changed by failed patches: %v", got)
+	}
+	if v := readVersion(t, db, "users", "a"); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	start := map[string]interface{}{
+		"Name":    "John",
+		"Age":     23,
+		"Company": "acme",
+		"Tags":    []interface{}{"a", "b"},
+		"Address": map[string]interface{}{"City": "bangalore", "Pincode": "515671", "Geo": map[string]interface{}{"Lat": 1, "Lng": 2}},
+	}
+	if err := db.WriteWithID("users", "a", start); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := `{
+		"Age": 0,
+		"Company": null,
+		"Tags": ["c"],
+		"Address": {"Pincode": null, "State": "ka", "Geo": {"Lng": 3}},
+		"Missing": null,
+		"Extra": {"Deep": {"Value": null, "Kept": true}}
+	}`
+	if err := db.MergePatch("users", "a", []byte(patch)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"Name":    "John",
+		"Age":     float64(0),
+		"Tags":    []interface{}{"c"},
+		"Address": map[string]interface{}{"City": "bangalore", "State": "ka", "Geo": map[string]interface{}{"Lat": float64(1), "Lng": float64(3)}},
+		"Extra":   map[string]interface{}{"Deep": map[string]interface{}{"Kept": true}},
+	}
+	if got := readRaw(t, db, "users", "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	invalid := map[string]string{
+		"array":         `[1]`,
+		"scalar":        `"x"`,
+		"change the id": `{"_id": "b"}`,
+		"remove the id": `{"_id": null}`,
+	}
+	for name, patch := range invalid {
+		if err := db.MergePatch("users", "a", []byte(patch)); err == nil {
+			t.Errorf("%s: got nil error", name)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package bdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// Query retrieves the records of a collection that satisfy a predicate.
+//
+// The predicate receives the full decoded document, including its "_id"
+// field.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - match: The predicate deciding whether a record is included.
+//
+// Returns:
+// - []map[string]interface{}: The matching records.
+// - error: An error if the operation fails.
+func (d *Driver) Query(collection string, match func(map[string]interface{}) bool) ([]map[string]interface{}, error) {
+	if match == nil {
+		return nil, fmt.Errorf("missing match predicate")
+	}
+
+	records, err := d.ReadAll(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []map[string]interface{}{}
+
+	for _, record := range records {
+		var doc map[string]interface{}
+		if err := d.codecFor(collection).Unmarshal([]byte(record), &doc); err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %s", err)
+		}
+
+		if match(doc) {
+			results = append(results, doc)
+		}
+	}
+
+	return results, nil
+}
+
+// QuerySorted retrieves one page of the records of a collection that
+// satisfy a predicate, sorted by a field.
+//
+// Values are compared by JSON type first, in the order null, booleans,
+// numbers and strings, then by value. Records where the field is missing or
+// holds an array or object come last, whatever the direction. Records
+// comparing equal keep their ID order.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - match: The predicate deciding whether a record is included, nil to include every record.
+// - sortField: The field, or dotted path into nested objects, to sort by.
+// - ascending: Whether to sort in ascending rather than descending order.
+// - offset: The number of matching records to skip.
+// - limit: The maximum number of records to return, 0 for no limit.
+//
+// Returns:
+// - []map[string]interface{}: The records of the page, empty if offset is past the end.
+// - error: An error if the page is invalid or the operation fails.
+func (d *Driver) QuerySorted(collection string, match func(map[string]interface{}) bool, sortField string, ascending bool, offset, limit int) ([]map[string]interface{}, error) {
+	if sortField == "" {
+		return nil, fmt.Errorf("missing sort field")
+	}
+
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+
+	if match == nil {
+		match = func(map[string]interface{}) bool { return true }
+	}
+
+	results, err := d.Query(collection, match)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, aOK := sortValue(results[i], sortField)
+		b, bOK := sortValue(results[j], sortField)
+		if !aOK || !bOK {
+			return aOK && !bOK
+		}
+
+		if ascending {
+			return compareScalars(a, b) < 0
+		}
+		return compareScalars(a, b) > 0
+	})
+
+	if offset >= len(results) {
+		return []map[string]interface{}{}, nil
+	}
+
+	results = results[offset:]
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// sortValue returns the value QuerySorted sorts a record by.
+//
+// Parameters:
+// - record: The decoded record.
+// - field: The field, or dotted path.
+//
+// Returns:
+// - interface{}: The value.
+// - bool: False if the field is missing or not a scalar.
+func sortValue(record map[string]interface{}, field string) (interface{}, bool) {
+	value, ok := util.GetField(record, field)
+	if !ok {
+		return nil, false
+	}
+
+	switch value.(type) {
+	case nil, bool, float64, string:
+		return value, true
+	}
+
+	return nil, false
+}
+
+// compareScalars compares two decoded scalars, by JSON type first, then by
+// value.
+//
+// Parameters:
+// - a: The first value, nil, a bool, a float64 or a string.
+// - b: The second value, of the same kinds.
+//
+// Returns:
+// - int: -1 if a sorts before b, 1 if after, 0 if they are equal.
+func compareScalars(a, b interface{}) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	var less, greater bool
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		less, greater = !av && bv, av && !bv
+	case float64:
+		bv := b.(float64)
+		less, greater = av < bv, av > bv
+	case string:
+		bv := b.(string)
+		less, greater = av < bv, av > bv
+	}
+
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+
+	return 0
+}
+
+// Find retrieves the records of a collection whose field equals value.
+//
+// The field may be a dotted path into nested objects, e.g. "Address.City".
+// Values are compared after normalizing value through JSON, so an int
+// matches the float64 decoded from a record.
+//
+// Parameters:
+// - d: The database driver.
+// - collection: The name of the collection.
+// - field: The field (or dotted path) to compare.
+// - value: The value to match.
+//
+// Returns:
+// - []T: The matching records, empty if nothing matches.
+// - error: An error if the operation fails.
+func Find[T any](d *Driver, collection string, field string, value interface{}) ([]T, error) {
+	if field == "" {
+		return nil, fmt.Errorf("missing field")
+	}
+
+	want, err := util.Normalize(value)
+	if err != nil {
+		return nil, fmt.Errorf("error normalizing value: %s", err)
+	}
+
+	records, err := d.ReadAll(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []T{}
+
+	for _, record := range records {
+		var doc map[string]interface{}
+		if err := d.codecFor(collection).Unmarshal([]byte(record), &doc); err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %s", err)
+		}
+
+		got, ok := util.GetField(doc, field)
+		if !ok || !reflect.DeepEqual(got, want) {
+			continue
+		}
+
+		var v T
+		if err := d.codecFor(collection).Unmarshal([]byte(record), &v); err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %s", err)
+		}
+		results = append(results, v)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,65 @@
+package bdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recordIDs returns the IDs of decoded records.
+func recordIDs(records []map[string]interface{}) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i], _ = record["_id"].(string)
+	}
+	return ids
+}
+
+func TestQuerySorted(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	employees := map[string]map[string]interface{}{
+		"e1": {"Company": "acme", "Age": 30},
+		"e2": {"Company": "acme", "Age": 45},
+		"e3": {"Company": "acme", "Age": 25},
+		"e4": {"Company": "acme", "Age": 45},
+		"e5": {"Company": "acme"},
+		"e6": {"Company": "other", "Age": 60},
+		"e7": {"Company": "acme", "Age": "unknown"},
+	}
+	for id, employee := range employees {
+		if err := db.WriteWithID("employees", id, employee); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atAcme := func(record map[string]interface{}) bool {
+		return record["Company"] == "acme"
+	}
+
+	tests := []struct {
+		name          string
+		ascending     bool
+		offset, limit int
+		want          []string
+	}{
+		// Strings sort after numbers; missing fields come last either way,
+		// and ties keep their ID order.
+		{"descending", false, 0, 0, []string{"e7", "e2", "e4", "e1", "e3", "e5"}},
+		{"ascending", true, 0, 0, []string{"e3", "e1", "e2", "e4", "e7", "e5"}},
+		{"page", false, 1, 3, []string{"e2", "e4", "e1"}},
+		{"past the end", false, 10, 2, []string{}},
+	}
+	for _, tt := range tests {
+		records, err := db.QuerySorted("employees", atAcme, "Age", tt.ascending, tt.offset, tt.limit)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if ids := recordIDs(records); !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+
+	if _, err := db.QuerySorted("employees", nil, "Age", true, -1, 0); err == nil {
+		t.Error("negative offset: got nil error")
+	}
+}
@@ -0,0 +1,107 @@
+package bdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned when a record is inserted into a collection
+// already holding the number of records its quota allows.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// SetQuota limits the number of records a collection can hold. Once it is
+// full, Write, WriteWithID, the inserts of Upsert and the other operations
+// adding a record to it return ErrQuotaExceeded; updates and deletes are
+// not limited.
+//
+// The quota is stored in the configuration of the collection, keeping its
+// other settings, so it applies again after a restart. Records already
+// above a lowered quota are kept. Soft-deleted and expired records count
+// until they are purged, like with Count.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - maxRecords: The number of records allowed, 0 to remove the quota.
+//
+// Returns:
+// - error: An error if the quota is negative or cannot be stored.
+func (d *Driver) SetQuota(collection string, maxRecords int) error {
+	if err := d.checkOpen(); err != nil {
+		return err
+	}
+
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	if collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	if maxRecords < 0 {
+		return fmt.Errorf("invalid quota: %d", maxRecords)
+	}
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	cfg, err := d.loadConfig(collection)
+	if err != nil {
+		return err
+	}
+	cfg = cfg.clone()
+	cfg.MaxRecords = maxRecords
+
+	if err := d.storeConfig(collection, cfg); err != nil {
+		return err
+	}
+
+	d.log.Debug("Set the quota of '%s' to %d records", collection, maxRecords)
+
+	return nil
+}
+
+// hasQuota reports whether a collection has a quota.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - bool: True if the configuration of the collection sets MaxRecords.
+func (d *Driver) hasQuota(collection string) bool {
+	return d.collectionConfig(collection).MaxRecords > 0
+}
+
+// checkQuota checks that a record can be added to a collection. The caller
+// must hold the collection lock, which lockRecord takes for collections
+// with a quota.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - error: ErrQuotaExceeded if the collection is full, or an error if it cannot be counted.
+func (d *Driver) checkQuota(collection string) error {
+	max := d.collectionConfig(collection).MaxRecords
+	if max <= 0 {
+		return nil
+	}
+
+	_, names, err := d.recordNames(collection)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(names) >= max {
+		return fmt.Errorf("%w: collection '%s' holds %d of %d records", ErrQuotaExceeded, collection, len(names), max)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package bdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	if err := db.SetQuota("users", 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.WriteWithID("users", fmt.Sprint(i), map[string]interface{}{"n": i}); err != nil {
+			t.Fatalf("insert %d: %s", i, err)
+		}
+	}
+
+	if _, err := db.Write("users", map[string]interface{}{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Write: got %v, want ErrQuotaExceeded", err)
+	}
+	if err := db.WriteWithID("users", "x", map[string]interface{}{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteWithID: got %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := db.Upsert("users", "x", map[string]interface{}{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Upsert: got %v, want ErrQuotaExceeded", err)
+	}
+
+	// Updates of a full collection are not limited.
+	if err := db.Update("users", "0", map[string]interface{}{"n": 10}); err != nil {
+		t.Errorf("Update: %s", err)
+	}
+	if n, err := db.Count("users"); err != nil || n != 3 {
+		t.Errorf("Count = %d, %v; want 3", n, err)
+	}
+
+	// The quota applies again after a restart, and deleting frees a slot.
+	db.Close()
+	db = openTestDriver(t, dir, nil)
+	if err := db.WriteWithID("users", "x", map[string]interface{}{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteWithID after reopening: got %v, want ErrQuotaExceeded", err)
+	}
+	if err := db.Delete("users", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("users", "x", map[string]interface{}{}); err != nil {
+		t.Errorf("WriteWithID after Delete: %s", err)
+	}
+
+	// A quota of 0 removes the limit.
+	if err := db.SetQuota("users", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Write("users", map[string]interface{}{}); err != nil {
+		t.Errorf("Write without a quota: %s", err)
+	}
+}
+
+func TestSetQuotaRejectsNegative(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.SetQuota("users", -1); err == nil {
+		t.Error("SetQuota accepted a negative quota")
+	}
+}
@@ -0,0 +1,416 @@
+package bdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// schemaDir is the reserved directory, relative to the database directory,
+// holding the schema of each collection as "<collection>.json".
+const schemaDir = "_schema"
+
+// SchemaViolation describes a field that does not match a schema.
+type SchemaViolation struct {
+	// Field is the dotted path of the field, e.g. "Address.City" or
+	// "Tags[0]", empty for the record itself.
+	Field   string
+	Message string
+}
+
+// SchemaError is returned when a record does not match the schema of its
+// collection.
+type SchemaError struct {
+	Collection string
+	Violations []SchemaViolation
+}
+
+// Error lists the violations.
+func (e *SchemaError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		field := v.Field
+		if field == "" {
+			field = "(record)"
+		}
+		parts = append(parts, field+": "+v.Message)
+	}
+
+	return fmt.Sprintf("record does not match the schema of '%s': %s", e.Collection, strings.Join(parts, "; "))
+}
+
+// jsonSchema is a parsed JSON Schema document.
+type jsonSchema struct {
+	doc      map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// schemas caches the schemas of the collections, loaded lazily from disk.
+type schemas struct {
+	mutex  sync.Mutex
+	loaded map[string]*jsonSchema
+}
+
+// SetSchema registers the JSON Schema that records of a collection must
+// match on Write, Update and Replace.
+//
+// The schema is stored in the reserved "_schema" directory, so it applies
+// again after a restart. Existing records are not checked. Passing a nil
+// schema removes it.
+//
+// The supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum and exclusiveMaximum; other
+// keywords, such as $ref or allOf, are ignored. The "_id" field is always
+// allowed by additionalProperties.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - schema: The JSON Schema document.
+//
+// Returns:
+// - error: An error if the schema is invalid or cannot be stored.
+func (d *Driver) SetSchema(collection string, schema []byte) error {
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	if collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.dir, schemaDir, collection+".json")
+
+	d.schemas.mutex.Lock()
+	defer d.schemas.mutex.Unlock()
+
+	if d.schemas.loaded == nil {
+		d.schemas.loaded = make(map[string]*jsonSchema)
+	}
+
+	if schema == nil {
+		if err := d.storage.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove schema: %s (%s)", path, err)
+		}
+		d.schemas.loaded[collection] = nil
+		return nil
+	}
+
+	s, err := parseSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if err := d.writeFileAtomic(path, schema); err != nil {
+		return fmt.Errorf("unable to store schema: %s (%s)", path, err)
+	}
+
+	d.schemas.loaded[collection] = s
+
+	return nil
+}
+
+// schemaFor returns the schema of a collection, loading it from disk the
+// first time.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - *jsonSchema: The schema, nil if the collection has none.
+// - error: An error if the stored schema cannot be read or parsed.
+func (d *Driver) schemaFor(collection string) (*jsonSchema, error) {
+	d.schemas.mutex.Lock()
+	defer d.schemas.mutex.Unlock()
+
+	if s, ok := d.schemas.loaded[collection]; ok {
+		return s, nil
+	}
+
+	path := filepath.Join(d.dir, schemaDir, collection+".json")
+
+	var s *jsonSchema
+
+	data, err := d.storage.ReadFile(path)
+	if err == nil {
+		if s, err = parseSchema(data); err != nil {
+			return nil, fmt.Errorf("%s (%s)", err, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read schema: %s (%s)", path, err)
+	}
+
+	if d.schemas.loaded == nil {
+		d.schemas.loaded = make(map[string]*jsonSchema)
+	}
+	d.schemas.loaded[collection] = s
+
+	return s, nil
+}
+
+// validate checks a record against the schema of its collection, if any,
+// then runs the collection's validator from Options.Validators, if any.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - record: The record about to be stored, including its "_id".
+//
+// Returns:
+// - error: A *SchemaError listing the violations, the error of the
+// validator, or an error if the schema cannot be loaded.
+func (d *Driver) validate(collection string, record map[string]interface{}) error {
+	s, err := d.schemaFor(collection)
+	if err != nil {
+		return err
+	}
+
+	validator := d.validators[collection]
+	if s == nil && validator == nil {
+		return nil
+	}
+
+	// Schemas and validators see numbers as float64, whether the record
+	// holds json.Number or not.
+	normalized, err := util.Normalize(record)
+	if err != nil {
+		return err
+	}
+	record, _ = normalized.(map[string]interface{})
+
+	if s != nil {
+		var violations []SchemaViolation
+		s.check(s.doc, record, "", &violations)
+
+		if len(violations) > 0 {
+			return &SchemaError{Collection: collection, Violations: violations}
+		}
+	}
+
+	if validator != nil {
+		return validator(record)
+	}
+
+	return nil
+}
+
+// parseSchema parses a JSON Schema document and compiles its patterns.
+func parseSchema(data []byte) (*jsonSchema, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %s", err)
+	}
+
+	s := &jsonSchema{doc: doc, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compile(doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %s", err)
+	}
+
+	return s, nil
+}
+
+// compile compiles the patterns of a schema and its subschemas.
+func (s *jsonSchema) compile(doc map[string]interface{}) error {
+	if pattern, ok := doc["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		s.patterns[pattern] = re
+	}
+
+	if properties, ok := doc["properties"].(map[string]interface{}); ok {
+		for _, sub := range properties {
+			if sub, ok := sub.(map[string]interface{}); ok {
+				if err := s.compile(sub); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, key := range []string{"items", "additionalProperties"} {
+		if sub, ok := doc[key].(map[string]interface{}); ok {
+			if err := s.compile(sub); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// check validates value against doc, appending violations for field.
+func (s *jsonSchema) check(doc map[string]interface{}, value interface{}, field string, violations *[]SchemaViolation) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, SchemaViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := doc["type"]; ok && !matchesType(t, value) {
+		fail("must be of type %v", t)
+		return
+	}
+
+	if enum, ok := doc["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	if c, ok := doc["const"]; ok && !reflect.DeepEqual(c, value) {
+		fail("must be %v", c)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		s.checkObject(doc, value, field, violations)
+
+	case []interface{}:
+		if n, ok := doc["minItems"].(float64); ok && float64(len(value)) < n {
+			fail("must have at least %v items", n)
+		}
+		if n, ok := doc["maxItems"].(float64); ok && float64(len(value)) > n {
+			fail("must have at most %v items", n)
+		}
+		if items, ok := doc["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				s.check(items, item, fmt.Sprintf("%s[%d]", field, i), violations)
+			}
+		}
+
+	case string:
+		length := float64(len([]rune(value)))
+		if n, ok := doc["minLength"].(float64); ok && length < n {
+			fail("must be at least %v characters long", n)
+		}
+		if n, ok := doc["maxLength"].(float64); ok && length > n {
+			fail("must be at most %v characters long", n)
+		}
+		if pattern, ok := doc["pattern"].(string); ok && !s.patterns[pattern].MatchString(value) {
+			fail("must match pattern %s", pattern)
+		}
+
+	case float64:
+		if n, ok := doc["minimum"].(float64); ok && value < n {
+			fail("must be >= %v", n)
+		}
+		if n, ok := doc["maximum"].(float64); ok && value > n {
+			fail("must be <= %v", n)
+		}
+		if n, ok := doc["exclusiveMinimum"].(float64); ok && value <= n {
+			fail("must be > %v", n)
+		}
+		if n, ok := doc["exclusiveMaximum"].(float64); ok && value >= n {
+			fail("must be < %v", n)
+		}
+	}
+}
+
+// checkObject validates the properties of an object.
+func (s *jsonSchema) checkObject(doc map[string]interface{}, value map[string]interface{}, field string, violations *[]SchemaViolation) {
+	join := func(key string) string {
+		if field == "" {
+			return key
+		}
+		return field + "." + key
+	}
+
+	if required, ok := doc["required"].([]interface{}); ok {
+		for _, key := range required {
+			if key, ok := key.(string); ok {
+				if _, present := value[key]; !present {
+					*violations = append(*violations, SchemaViolation{Field: join(key), Message: "is required"})
+				}
+			}
+		}
+	}
+
+	properties, _ := doc["properties"].(map[string]interface{})
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if sub, ok := properties[key].(map[string]interface{}); ok {
+			s.check(sub, value[key], join(key), violations)
+			continue
+		}
+
+		if _, ok := properties[key]; ok || (field == "" && key == "_id") {
+			continue
+		}
+
+		switch additional := doc["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*violations = append(*violations, SchemaViolation{Field: join(key), Message: "is not allowed"})
+			}
+		case map[string]interface{}:
+			s.check(additional, value[key], join(key), violations)
+		}
+	}
+}
+
+// matchesType reports whether value has the JSON type, or one of the JSON
+// types, named by t.
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []interface{}:
+		for _, name := range t {
+			if name, ok := name.(string); ok && matchesTypeName(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// matchesTypeName reports whether value has the named JSON type.
+func matchesTypeName(name string, value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case float64:
+		return name == "number" || (name == "integer" && value == math.Trunc(value))
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+
+	return false
+}
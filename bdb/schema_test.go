@@ -0,0 +1,75 @@
+package bdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var errNegativeAge = errors.New("negative age")
+
+// rejectNegativeAge is a validator rejecting records with a negative Age,
+// and records without an ID.
+func rejectNegativeAge(record map[string]interface{}) error {
+	if _, ok := record["_id"].(string); !ok {
+		return errors.New("missing _id")
+	}
+	if age, ok := record["Age"].(float64); ok && age < 0 {
+		return errNegativeAge
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	db := newTestDriver(t, &Options{Validators: map[string]func(map[string]interface{}) error{
+		"users": rejectNegativeAge,
+	}})
+
+	if _, err := db.Write("users", testUser{Name: "old", Age: "-1"}); !errors.Is(err, errNegativeAge) {
+		t.Errorf("Write: got %v, want the validator error", err)
+	}
+	if n, _ := db.Count("users"); n != 0 {
+		t.Errorf("Count after the rejected write = %d, want 0", n)
+	}
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("users", "a", map[string]interface{}{"Age": -5}); !errors.Is(err, errNegativeAge) {
+		t.Errorf("Update: got %v, want the validator error", err)
+	}
+	if err := db.Replace("users", "a", testUser{Name: "John", Age: "-5"}); !errors.Is(err, errNegativeAge) {
+		t.Errorf("Replace: got %v, want the validator error", err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Age != "23" {
+		t.Errorf("Age = %s after rejected changes, want 23", got.Age)
+	}
+
+	// Other collections are not validated.
+	if _, err := db.Write("admins", testUser{Age: "-1"}); err != nil {
+		t.Errorf("Write to another collection: %s", err)
+	}
+}
+
+func TestSchemaRejectsInvalidRecord(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	schema := `{"type": "object", "required": ["Name"], "properties": {"Name": {"type": "string"}}}`
+	if err := db.SetSchema("users", []byte(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.Write("users", map[string]interface{}{"Age": 3})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Write: got %v, want a *SchemaError", err)
+	}
+	if !strings.Contains(err.Error(), "Name") {
+		t.Errorf("error %q does not name the missing field", err)
+	}
+}
@@ -0,0 +1,215 @@
+package bdb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxShardDepth is the deepest sharding supported, one level per byte of
+// the 32-bit hash of the IDs.
+const maxShardDepth = 4
+
+// shardDepthFor returns the number of shard directory levels the records
+// of a collection are stored under.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - int: The shard depth of the collection, or Options.ShardDepth.
+func (d *Driver) shardDepthFor(collection string) int {
+	return d.configShardDepth(d.collectionConfig(collection))
+}
+
+// shardDir returns the shard directory of a record at a given depth.
+//
+// Directories are named after the bytes of the FNV-1a hash of the ID, two
+// hex digits per level, e.g. "3f/a1" at depth 2. The hash rather than a
+// prefix of the ID is used since generated IDs start with a timestamp, so
+// they would mostly share the same prefix.
+//
+// Parameters:
+// - resource: The ID of the record.
+// - depth: The number of levels.
+//
+// Returns:
+// - string: The directory relative to the collection directory, empty at depth 0.
+func shardDir(resource string, depth int) string {
+	if depth <= 0 {
+		return ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(resource))
+	sum := h.Sum(nil)
+
+	dirs := make([]string, depth)
+	for i := range dirs {
+		dirs[i] = fmt.Sprintf("%02x", sum[i])
+	}
+
+	return filepath.Join(dirs...)
+}
+
+// isShardName reports whether a directory name is the name of a shard
+// directory.
+//
+// Parameters:
+// - name: The directory name.
+//
+// Returns:
+// - bool: True if the name is two lowercase hex digits.
+func isShardName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// walkShards calls fn for every entry of the directory of a collection and
+// of its shard directories, up to depth levels, in directory order.
+//
+// Parameters:
+// - collectionPath: The path of the collection directory.
+// - depth: The number of shard levels to descend into.
+// - fn: Receives the path of each entry relative to the collection directory, and the entry.
+//
+// Returns:
+// - error: An error if a directory cannot be read.
+func (d *Driver) walkShards(collectionPath string, depth int, fn func(name string, entry os.DirEntry)) error {
+	var walk func(rel string, level int) error
+	walk = func(rel string, level int) error {
+		dir := filepath.Join(collectionPath, rel)
+
+		entries, err := d.storage.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read directory: %s (%s)", dir, err)
+		}
+
+		for _, entry := range entries {
+			name := filepath.Join(rel, entry.Name())
+			fn(name, entry)
+
+			if entry.IsDir() && level < depth && isShardName(entry.Name()) {
+				if err := walk(name, level+1); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return walk("", 0)
+}
+
+// Reshard moves the records of a collection to the shard directories of a
+// new depth, and stores the depth in the configuration of the collection,
+// overriding Options.ShardDepth.
+//
+// The collection lock is held meanwhile. Records found at any depth are
+// moved, so a Reshard interrupted by a crash is completed by running it
+// again; until then, the records not moved yet cannot be found.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - depth: The new depth, from 0 (flat) to 4.
+//
+// Returns:
+// - int: The number of records moved.
+// - error: ErrDryRun with Options.DryRun, or an error if the depth is invalid or a record cannot be moved.
+func (d *Driver) Reshard(collection string, depth int) (moved int, err error) {
+	if err := d.checkWritable(collection); err != nil {
+		return 0, err
+	}
+
+	if collection == "" {
+		return 0, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	if depth < 0 || depth > maxShardDepth {
+		return 0, fmt.Errorf("invalid shard depth: %d", depth)
+	}
+
+	if d.dryRun {
+		return 0, ErrDryRun
+	}
+
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	if _, err := d.storage.Stat(collectionPath); err != nil {
+		return 0, lookupError("collection", collectionPath, err)
+	}
+
+	var names, dirs []string
+	err = d.walkShards(collectionPath, maxShardDepth, func(name string, entry os.DirEntry) {
+		if entry.IsDir() {
+			if filepath.Dir(name) == "." && !isShardName(entry.Name()) {
+				return
+			}
+			dirs = append(dirs, name)
+		} else if d.isRecordName(collection, entry.Name()) {
+			names = append(names, name)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range names {
+		target := filepath.Join(shardDir(d.recordID(collection, name), depth), filepath.Base(name))
+		if target == name {
+			continue
+		}
+
+		path := filepath.Join(collectionPath, target)
+		if err := d.mkdirAll(filepath.Dir(path)); err != nil {
+			return moved, err
+		}
+
+		if err := d.storage.Rename(filepath.Join(collectionPath, name), path); err != nil {
+			return moved, fmt.Errorf("unable to move record: %s (%s)", name, err)
+		}
+
+		moved++
+	}
+
+	// Remove the shard directories left empty, deepest first; removing a
+	// directory that is not empty fails and leaves it alone.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		d.storage.Remove(filepath.Join(collectionPath, dir))
+	}
+
+	cfg, err := d.loadConfig(collection)
+	if err != nil {
+		return moved, err
+	}
+	cfg = cfg.clone()
+	cfg.ShardDepth = &depth
+
+	if err := d.storeConfig(collection, cfg); err != nil {
+		return moved, err
+	}
+
+	d.log.Debug("Resharded '%s' to depth %d, %d records moved", collection, depth, moved)
+
+	return moved, nil
+}
@@ -0,0 +1,33 @@
+package bdb
+
+import (
+	"strconv"
+	"testing"
+)
+
+// BenchmarkShardedRead reads the records of a large collection one after
+// the other, stored flat and sharded.
+func BenchmarkShardedRead(b *testing.B) {
+	const records = 10000
+
+	for _, bc := range []struct {
+		name  string
+		depth int
+	}{{"flat", 0}, {"depth1", 1}, {"depth2", 2}} {
+		db := newTestDriver(b, &Options{ShardDepth: bc.depth})
+		for i := 0; i < records; i++ {
+			if err := db.WriteWithID("users", strconv.Itoa(i), testUser{Name: "John"}); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.Run(bc.name, func(b *testing.B) {
+			var v testUser
+			for i := 0; i < b.N; i++ {
+				if err := db.Read("users", strconv.Itoa(i%records), &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+//go:build go1.21
+
+package bdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// slogLevelTrace is the slog level of Logger.Trace messages.
+	slogLevelTrace = slog.LevelDebug - 4
+	// slogLevelFatal is the slog level of Logger.Fatal messages.
+	slogLevelFatal = slog.LevelError + 4
+)
+
+// slogLogger adapts a *slog.Logger to the Logger interface.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// SlogLogger returns a Logger writing to a *slog.Logger, so the logs of the
+// driver flow into a structured logging pipeline.
+//
+// Messages are formatted like with lumber and logged with a
+// "component=bdb" attribute. Trace and Fatal messages, which slog has no
+// level for, are logged at DEBUG-4 and ERROR+4; Fatal does not exit.
+//
+// The module supports Go 1.19, which has no log/slog: SlogLogger is only
+// built with Go 1.21 or later.
+//
+// Parameters:
+// - l: The slog logger, nil for slog.Default().
+//
+// Returns:
+// - Logger: The logger, to set as Options.Logger.
+func SlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return slogLogger{logger: l.With("component", "bdb")}
+}
+
+// Fatal logs a message at ERROR+4.
+func (l slogLogger) Fatal(format string, args ...interface{}) {
+	l.log(slogLevelFatal, format, args)
+}
+
+// Error logs a message at ERROR.
+func (l slogLogger) Error(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args)
+}
+
+// Warn logs a message at WARN.
+func (l slogLogger) Warn(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args)
+}
+
+// Info logs a message at INFO.
+func (l slogLogger) Info(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args)
+}
+
+// Trace logs a message at DEBUG-4.
+func (l slogLogger) Trace(format string, args ...interface{}) {
+	l.log(slogLevelTrace, format, args)
+}
+
+// Debug logs a message at DEBUG.
+func (l slogLogger) Debug(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, format, args)
+}
+
+// log formats and logs a message, unless level is disabled.
+func (l slogLogger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	l.logger.Log(ctx, level, strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+}
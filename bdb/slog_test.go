@@ -0,0 +1,34 @@
+//go:build go1.21
+
+package bdb
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+
+	logger := SlogLogger(slog.New(handler))
+	logger.Debug("hidden %d", 1)
+	logger.Info("wrote %s\n", "users/a")
+	logger.Fatal("broken")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+
+	for _, want := range []string{"level=INFO", `msg="wrote users/a"`, "component=bdb"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("line %q lacks %s", lines[0], want)
+		}
+	}
+	if !strings.Contains(lines[1], "level=ERROR+4") {
+		t.Errorf("Fatal line %q is not at ERROR+4", lines[1])
+	}
+}
@@ -0,0 +1,109 @@
+package bdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// deletedField marks a soft-deleted record.
+	deletedField = "_deleted"
+	// deletedAtField holds the RFC 3339 time a record was soft-deleted.
+	deletedAtField = "_deleted_at"
+)
+
+// visibility decodes the fields that hide a record from reads.
+type visibility struct {
+	Deleted   bool   `json:"_deleted"`
+	ExpiresAt string `json:"_expires_at"`
+}
+
+// SoftDelete marks a record as deleted without removing its file.
+//
+// The record gets a "_deleted" flag and a "_deleted_at" timestamp. Read
+// then reports it as missing, and ReadAll, Query and Find skip it, until
+// Undelete clears the flag; ReadAllIncludingDeleted still returns it.
+// Exists reports it as missing too, Update, Patch and the other partial
+// updates return ErrNotFound, and Upsert writes a new record in its place.
+// Replace, which stores a new document, undeletes it. The hard Delete
+// removes the file for good, whether the record is soft-deleted or not.
+// Soft-deleting a deleted record keeps its original "_deleted_at".
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to delete.
+//
+// Returns:
+// - error: An error if the record does not exist or cannot be updated.
+func (d *Driver) SoftDelete(collection, resource string) error {
+	return d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, true, func(_, existing map[string]interface{}) error {
+		if deleted, _ := existing[deletedField].(bool); deleted {
+			return nil
+		}
+
+		existing[deletedField] = true
+		existing[deletedAtField] = time.Now().UTC().Format(time.RFC3339Nano)
+
+		return nil
+	})
+}
+
+// Undelete restores a record removed by SoftDelete.
+//
+// It is the counterpart of SoftDelete; it is not named Restore, which
+// restores a backup archive.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - resource: The name of the resource to restore.
+//
+// Returns:
+// - error: An error if the record does not exist, is not deleted or cannot be updated.
+func (d *Driver) Undelete(collection, resource string) error {
+	return d.updateRecord(context.Background(), collection, resource, map[string]interface{}{}, true, func(_, existing map[string]interface{}) error {
+		if deleted, _ := existing[deletedField].(bool); !deleted {
+			return fmt.Errorf("record is not deleted: %s/%s", collection, resource)
+		}
+
+		delete(existing, deletedField)
+		delete(existing, deletedAtField)
+
+		return nil
+	})
+}
+
+// ReadAllIncludingDeleted retrieves all the records from the specified
+// collection, including soft-deleted ones, for admin views. Expired records
+// are still left out.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - []string: The list of records.
+// - error: An error if the operation fails.
+func (d *Driver) ReadAllIncludingDeleted(collection string) (records []string, err error) {
+	defer d.stats.observe(statRead, collection, time.Now(), &err)
+
+	return d.readAll(context.Background(), collection, true)
+}
+
+// isHidden reports whether an encoded record is soft-deleted or expired,
+// and so must be treated as absent by reads.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - bytes: The encoded record.
+// - includeDeleted: Whether soft-deleted records are visible.
+//
+// Returns:
+// - bool: True if the record is hidden.
+func (d *Driver) isHidden(collection string, bytes []byte, includeDeleted bool) bool {
+	var v visibility
+	if err := d.codecFor(collection).Unmarshal(bytes, &v); err != nil {
+		return false
+	}
+
+	return (v.Deleted && !includeDeleted) || isExpired(v.ExpiresAt, time.Now())
+}
@@ -0,0 +1,143 @@
+package bdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSoftDeletedRecordIsAbsent(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice", "visits": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("users", "a", &record); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read: got %v, want ErrNotFound", err)
+	}
+
+	if exists, err := db.Exists("users", "a"); err != nil || exists {
+		t.Errorf("Exists = %t, %v; want false", exists, err)
+	}
+
+	updates := map[string]func() error{
+		"Update": func() error {
+			return db.Update("users", "a", map[string]interface{}{"name": "bob"})
+		},
+		"UpdateReplace": func() error {
+			return db.UpdateReplace("users", "a", map[string]interface{}{"name": "bob"})
+		},
+		"Increment": func() error {
+			_, err := db.Increment("users", "a", "visits", 1)
+			return err
+		},
+	}
+	for name, update := range updates {
+		if err := update(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got %v, want ErrNotFound", name, err)
+		}
+	}
+
+	records, err := db.ReadAllIncludingDeleted("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("ReadAllIncludingDeleted returned %d records, want 1", len(records))
+	}
+
+	// The failed updates left the record untouched.
+	if err := db.Undelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Read("users", "a", &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["name"] != "alice" || record["visits"] != float64(1) {
+		t.Errorf("record = %v, want the original fields", record)
+	}
+}
+
+func TestSoftDeleteTwiceKeepsDeletedAt(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	deletedAt := func() interface{} {
+		t.Helper()
+		records, err := db.ReadAllIncludingDeleted("users")
+		if err != nil || len(records) != 1 {
+			t.Fatalf("ReadAllIncludingDeleted = %v, %v", records, err)
+		}
+		record, err := db.decodeRecord("users", []byte(records[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record[deletedAtField]
+	}
+
+	first := deletedAt()
+	if err := db.SoftDelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if second := deletedAt(); second != first {
+		t.Errorf("%s changed from %v to %v", deletedAtField, first, second)
+	}
+}
+
+func TestUpsertReplacesSoftDeletedRecord(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := db.Upsert("users", "a", map[string]interface{}{"email": "a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("Upsert reported an update of a soft-deleted record")
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("users", "a", &record); err != nil {
+		t.Fatalf("Read after Upsert: %s", err)
+	}
+	if _, ok := record["name"]; ok {
+		t.Errorf("fields of the deleted record were kept: %v", record)
+	}
+	if _, ok := record[deletedField]; ok {
+		t.Errorf("record still deleted: %v", record)
+	}
+}
+
+func TestReplaceUndeletes(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", map[string]interface{}{"name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDelete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Replace("users", "a", map[string]interface{}{"name": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := db.Exists("users", "a"); err != nil || !exists {
+		t.Errorf("Exists = %t, %v; want true", exists, err)
+	}
+}
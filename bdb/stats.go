@@ -0,0 +1,158 @@
+package bdb
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency buckets counted in
+// OpStats.Buckets.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// OpStats holds the counters of one kind of operation.
+type OpStats struct {
+	// Count is the number of operations, including failed ones.
+	Count int64
+	// Errors is the number of failed operations.
+	Errors int64
+	// Latency is the cumulative duration of the operations.
+	Latency time.Duration
+	// Buckets counts the operations that took at most the matching
+	// LatencyBuckets bound (cumulative, like a Prometheus histogram).
+	Buckets []int64
+}
+
+// CollectionStats holds the counters of a collection.
+type CollectionStats struct {
+	Reads   OpStats
+	Writes  OpStats
+	Updates OpStats
+	Deletes OpStats
+
+	// BytesRead is the number of encoded record bytes read.
+	BytesRead int64
+	// BytesWritten is the number of encoded record bytes written.
+	BytesWritten int64
+}
+
+// Stats is a snapshot of the driver's counters, keyed by collection.
+type Stats struct {
+	Collections map[string]CollectionStats
+}
+
+type statOp int
+
+const (
+	statRead statOp = iota
+	statWrite
+	statUpdate
+	statDelete
+)
+
+// stats holds the live counters of a driver.
+type stats struct {
+	mutex       sync.Mutex
+	collections map[string]*CollectionStats
+}
+
+// Stats returns a snapshot of the per-collection operation counters.
+//
+// Returns:
+// - Stats: The counters.
+func (d *Driver) Stats() Stats {
+	d.stats.mutex.Lock()
+	defer d.stats.mutex.Unlock()
+
+	snapshot := Stats{Collections: make(map[string]CollectionStats, len(d.stats.collections))}
+
+	for name, cs := range d.stats.collections {
+		c := *cs
+		for _, op := range []*OpStats{&c.Reads, &c.Writes, &c.Updates, &c.Deletes} {
+			op.Buckets = append([]int64(nil), op.Buckets...)
+		}
+		snapshot.Collections[name] = c
+	}
+
+	return snapshot
+}
+
+// ResetStats clears all the operation counters.
+func (d *Driver) ResetStats() {
+	d.stats.mutex.Lock()
+	defer d.stats.mutex.Unlock()
+
+	d.stats.collections = nil
+}
+
+// get returns the counters of a collection. The caller must hold s.mutex.
+func (s *stats) get(collection string) *CollectionStats {
+	if s.collections == nil {
+		s.collections = make(map[string]*CollectionStats)
+	}
+
+	cs, ok := s.collections[collection]
+	if !ok {
+		cs = &CollectionStats{}
+		s.collections[collection] = cs
+	}
+
+	return cs
+}
+
+// observe counts an operation that started at start. It is meant to be
+// deferred with a pointer to the operation's named error result.
+func (s *stats) observe(op statOp, collection string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cs := s.get(collection)
+
+	var o *OpStats
+	switch op {
+	case statRead:
+		o = &cs.Reads
+	case statWrite:
+		o = &cs.Writes
+	case statUpdate:
+		o = &cs.Updates
+	case statDelete:
+		o = &cs.Deletes
+	}
+
+	o.Count++
+	if err != nil && *err != nil {
+		o.Errors++
+	}
+	o.Latency += elapsed
+
+	if o.Buckets == nil {
+		o.Buckets = make([]int64, len(LatencyBuckets))
+	}
+	for i, bound := range LatencyBuckets {
+		if elapsed <= bound {
+			o.Buckets[i]++
+		}
+	}
+}
+
+// addBytes counts encoded record bytes read and written.
+func (s *stats) addBytes(collection string, read, written int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cs := s.get(collection)
+	cs.BytesRead += int64(read)
+	cs.BytesWritten += int64(written)
+}
@@ -0,0 +1,508 @@
+package bdb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// Storage is the file system the driver stores its files in.
+//
+// Paths are built with filepath.Join from the database directory, and
+// errors for missing files must satisfy os.IsNotExist, as the driver
+// relies on it to tell a missing record from a failure.
+type Storage interface {
+	// ReadFile returns the content of a file.
+	ReadFile(path string) ([]byte, error)
+	// WriteFile creates or truncates a file and writes data to it.
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// AppendFile appends data to a file in a single write, creating the
+	// file if needed.
+	AppendFile(path string, data []byte, perm os.FileMode) error
+	// Rename moves a file, replacing the destination if it exists.
+	Rename(oldPath, newPath string) error
+	// ReadDir lists a directory sorted by name.
+	ReadDir(path string) ([]os.DirEntry, error)
+	// Stat describes a file or directory.
+	Stat(path string) (os.FileInfo, error)
+	// MkdirAll creates a directory and any missing parents.
+	MkdirAll(path string, perm os.FileMode) error
+	// Chmod changes the mode of a file or directory.
+	Chmod(path string, mode os.FileMode) error
+	// Remove removes a file or an empty directory.
+	Remove(path string) error
+	// RemoveAll removes a path and everything it contains. A missing path
+	// is not an error.
+	RemoveAll(path string) error
+}
+
+// Linker is implemented by the Storages that can give a file a second name
+// without replacing an existing file. With Options.StrictInsert, new
+// records are put into place with Link rather than Rename, so a record
+// created meanwhile, e.g. by another process, is never replaced.
+type Linker interface {
+	// Link gives the file at oldPath a second name, newPath. It fails with
+	// an error satisfying os.IsExist if newPath exists.
+	Link(oldPath, newPath string) error
+}
+
+// DiskStorage stores the files of the database on disk. It is the default
+// Storage.
+type DiskStorage struct{}
+
+// ReadFile returns the content of a file.
+func (DiskStorage) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// WriteFile creates or truncates a file and writes data to it.
+func (DiskStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+// AppendFile appends data to a file in a single write, creating the file if
+// needed.
+func (DiskStorage) AppendFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Rename moves a file, replacing the destination if it exists.
+func (DiskStorage) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// Link creates newPath as a hard link to oldPath, failing if it exists.
+func (DiskStorage) Link(oldPath, newPath string) error {
+	return os.Link(oldPath, newPath)
+}
+
+// ReadDir lists a directory sorted by name.
+func (DiskStorage) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+// Stat describes a file or directory.
+func (DiskStorage) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// MkdirAll creates a directory and any missing parents.
+func (DiskStorage) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// Chmod changes the mode of a file or directory.
+func (DiskStorage) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+// Remove removes a file or an empty directory.
+func (DiskStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// RemoveAll removes a path and everything it contains.
+func (DiskStorage) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// MemStorage keeps the files of the database in memory, for tests and
+// ephemeral databases. Its content is lost when it is garbage collected.
+//
+// The zero value is not usable; create one with NewMemStorage. WatchFS is
+// not supported on it, as there is nothing to watch.
+type MemStorage struct {
+	mutex sync.RWMutex
+	files map[string]*memFile
+}
+
+// memFile is a file or directory of a MemStorage.
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// memFileInfo describes a memFile as it was when it was looked up.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemStorage creates an empty in-memory storage.
+//
+// Returns:
+// - *MemStorage: The storage, holding only the root directory.
+func NewMemStorage() *MemStorage {
+	root := string(filepath.Separator)
+
+	return &MemStorage{files: map[string]*memFile{root: {mode: fs.ModeDir | 0755, modTime: time.Now()}}}
+}
+
+// ReadFile returns the content of a file.
+func (m *MemStorage) ReadFile(path string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	f, err := m.file("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: errIsDir}
+	}
+
+	return append([]byte(nil), f.data...), nil
+}
+
+// WriteFile creates or truncates a file and writes data to it.
+func (m *MemStorage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.write("open", path, append([]byte(nil), data...), perm)
+}
+
+// AppendFile appends data to a file, creating the file if needed.
+func (m *MemStorage) AppendFile(path string, data []byte, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var existing []byte
+	if f, ok := m.files[filepath.Clean(path)]; ok {
+		existing = f.data
+	}
+
+	return m.write("open", path, append(append([]byte(nil), existing...), data...), perm)
+}
+
+// Rename moves a file or directory, replacing the destination if it is a
+// file.
+func (m *MemStorage) Rename(oldPath, newPath string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+
+	f, err := m.file("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	if err := m.parent("rename", newPath); err != nil {
+		return err
+	}
+	if existing, ok := m.files[newPath]; ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: errIsDir}
+	}
+
+	delete(m.files, oldPath)
+	m.files[newPath] = f
+
+	prefix := oldPath + string(filepath.Separator)
+	moved := map[string]*memFile{}
+	for path, child := range m.files {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.files, path)
+			moved[filepath.Join(newPath, strings.TrimPrefix(path, prefix))] = child
+		}
+	}
+	for path, child := range moved {
+		m.files[path] = child
+	}
+
+	return nil
+}
+
+// Link gives a file a second name, sharing its content, failing if
+// newPath exists.
+func (m *MemStorage) Link(oldPath, newPath string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+
+	f, err := m.file("link", oldPath)
+	if err != nil {
+		return err
+	}
+	if err := m.parent("link", newPath); err != nil {
+		return err
+	}
+	if _, ok := m.files[newPath]; ok {
+		return &os.LinkError{Op: "link", Old: oldPath, New: newPath, Err: fs.ErrExist}
+	}
+
+	m.files[newPath] = f
+
+	return nil
+}
+
+// ReadDir lists a directory sorted by name.
+func (m *MemStorage) ReadDir(path string) ([]os.DirEntry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	path = filepath.Clean(path)
+
+	f, err := m.file("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: path, Err: errNotDir}
+	}
+
+	entries := []os.DirEntry{}
+	for name, child := range m.files {
+		if name != path && filepath.Dir(name) == path {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(name)))
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, nil
+}
+
+// Stat describes a file or directory.
+func (m *MemStorage) Stat(path string) (os.FileInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	f, err := m.file("stat", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.info(path), nil
+}
+
+// MkdirAll creates a directory and any missing parents.
+func (m *MemStorage) MkdirAll(path string, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path = filepath.Clean(path)
+
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if f, ok := m.files[dir]; ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+			}
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return nil
+		}
+		m.files[dir] = &memFile{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// Chmod changes the mode of a file or directory.
+func (m *MemStorage) Chmod(path string, mode os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, err := m.file("chmod", path)
+	if err != nil {
+		return err
+	}
+	f.mode = f.mode.Type() | mode.Perm()
+
+	return nil
+}
+
+// Remove removes a file or an empty directory.
+func (m *MemStorage) Remove(path string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path = filepath.Clean(path)
+
+	f, err := m.file("remove", path)
+	if err != nil {
+		return err
+	}
+
+	if f.mode.IsDir() {
+		prefix := path + string(filepath.Separator)
+		for name := range m.files {
+			if strings.HasPrefix(name, prefix) {
+				return &fs.PathError{Op: "remove", Path: path, Err: errNotEmpty}
+			}
+		}
+	}
+
+	delete(m.files, path)
+
+	return nil
+}
+
+// RemoveAll removes a path and everything it contains.
+func (m *MemStorage) RemoveAll(path string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	prefix := path + string(filepath.Separator)
+
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.files, name)
+		}
+	}
+
+	return nil
+}
+
+// stat is like Storage.Stat, but falls back to path+ext (e.g. the gzip
+// extension of a record) if path does not exist. Any other error, e.g. a
+// permission failure, is returned as is.
+//
+// Parameters:
+// - path: The path to describe.
+// - ext: The extension to try if path does not exist.
+//
+// Returns:
+// - os.FileInfo: The description of path or path+ext.
+// - error: The stat error, satisfying os.IsNotExist if neither exists.
+func (d *Driver) stat(path, ext string) (os.FileInfo, error) {
+	return util.Stat(d.storage, path, ext)
+}
+
+// walk calls fn for root and every file and directory below it, in
+// lexical order, like filepath.Walk but through the configured Storage.
+//
+// Parameters:
+// - root: The path to walk.
+// - fn: Called with the path and description of each entry.
+//
+// Returns:
+// - error: The first error returned by fn or met while listing a directory.
+func (d *Driver) walk(root string, fn func(path string, fi os.FileInfo) error) error {
+	fi, err := d.storage.Stat(root)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(root, fi); err != nil || !fi.IsDir() {
+		return err
+	}
+
+	entries, err := d.storage.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := d.walk(filepath.Join(root, entry.Name()), fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// file returns an existing file or directory. The caller must hold the
+// mutex.
+func (m *MemStorage) file(op, path string) (*memFile, error) {
+	f, ok := m.files[filepath.Clean(path)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// parent checks that the parent directory of path exists. The caller must
+// hold the mutex.
+func (m *MemStorage) parent(op, path string) error {
+	f, ok := m.files[filepath.Dir(filepath.Clean(path))]
+	if !ok {
+		return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	if !f.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: path, Err: errNotDir}
+	}
+
+	return nil
+}
+
+// write stores a file, keeping the mode of an existing one. The caller
+// must hold the mutex.
+func (m *MemStorage) write(op, path string, data []byte, perm os.FileMode) error {
+	path = filepath.Clean(path)
+
+	if err := m.parent(op, path); err != nil {
+		return err
+	}
+
+	if f, ok := m.files[path]; ok {
+		if f.mode.IsDir() {
+			return &fs.PathError{Op: op, Path: path, Err: errIsDir}
+		}
+		f.data, f.modTime = data, time.Now()
+		return nil
+	}
+
+	m.files[path] = &memFile{data: data, mode: perm.Perm(), modTime: time.Now()}
+
+	return nil
+}
+
+// info describes the file stored at path. The caller must hold the mutex.
+func (f *memFile) info(path string) memFileInfo {
+	return memFileInfo{name: filepath.Base(path), size: int64(len(f.data)), mode: f.mode, modTime: f.modTime}
+}
+
+// Name returns the base name of the file.
+func (fi memFileInfo) Name() string { return fi.name }
+
+// Size returns the length of the file content.
+func (fi memFileInfo) Size() int64 { return fi.size }
+
+// Mode returns the mode of the file.
+func (fi memFileInfo) Mode() os.FileMode { return fi.mode }
+
+// ModTime returns the time the file was last written.
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+
+// IsDir reports whether the file is a directory.
+func (fi memFileInfo) IsDir() bool { return fi.mode.IsDir() }
+
+// Sys returns nil.
+func (fi memFileInfo) Sys() interface{} { return nil }
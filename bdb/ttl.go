@@ -0,0 +1,217 @@
+package bdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// expiresAtField holds the RFC 3339 time after which a record is expired.
+const expiresAtField = "_expires_at"
+
+// WriteWithTTL writes the data to the database like Write, with an
+// expiry time stored in the record's "_expires_at" field.
+//
+// Once expired, the record is treated as absent: Read and Update report it
+// as missing, Exists reports false, ReadAll, Query and Find skip it, and
+// Upsert writes a new record in its place. Its file remains on disk,
+// and is still counted by Count, until it is removed by the reaper started
+// with StartReaper or by Delete.
+//
+// Parameters:
+// - collection: The name of the collection to write to.
+// - v: The data to write.
+// - ttl: How long the record lives.
+//
+// Returns:
+// - string: The ID of the new record.
+// - error: An error if ttl is not positive or the write operation fails.
+func (d *Driver) WriteWithTTL(collection string, v interface{}, ttl time.Duration) (id string, err error) {
+	defer d.stats.observe(statWrite, collection, time.Now(), &err)
+
+	if ttl <= 0 {
+		return "", fmt.Errorf("invalid ttl: %s", ttl)
+	}
+
+	data, err := util.ToMap(v)
+	if err != nil {
+		return "", err
+	}
+	util.DropDeleted(data)
+	data[expiresAtField] = time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)
+
+	return d.insert(context.Background(), collection, data, d.keyOrderOf(collection, v))
+}
+
+// StartReaper starts a goroutine removing the expired records of every
+// collection each interval.
+//
+// Nothing is removed from read-only databases and collections, and
+// nothing is started on a closed driver or with an interval that is not
+// positive, which is logged as an error.
+//
+// Parameters:
+// - interval: The time between two scans, which must be positive.
+//
+// Returns:
+// - func(): The function stopping the reaper, also called by Close; it waits for a running scan to finish.
+func (d *Driver) StartReaper(interval time.Duration) (stop func()) {
+	if d.checkOpen() != nil {
+		return func() {}
+	}
+
+	if interval <= 0 {
+		d.log.Error("Unable to start the reaper: invalid interval %s", interval)
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d.reap()
+			}
+		}
+	}()
+
+	var once sync.Once
+	stopReaper := func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+
+	forget, err := d.onClose(stopReaper)
+	if err != nil {
+		stopReaper()
+	}
+
+	return func() {
+		forget()
+		stopReaper()
+	}
+}
+
+// reap removes the expired records of every collection, logging failures.
+func (d *Driver) reap() {
+	if d.readOnly {
+		return
+	}
+
+	collections, err := d.Collections()
+	if err != nil {
+		d.log.Error("Unable to reap expired records: %s", err)
+		return
+	}
+
+	for _, collection := range collections {
+		if d.collectionConfig(collection).ReadOnly {
+			continue
+		}
+
+		if err := d.reapCollection(collection); err != nil {
+			d.log.Error("Unable to reap expired records of '%s': %s", collection, err)
+		}
+	}
+}
+
+// reapCollection removes the expired records of a collection.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - error: An error if the collection cannot be read or a record cannot be removed.
+func (d *Driver) reapCollection(collection string) error {
+	unlock := d.lockCollection(collection)
+	defer unlock()
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, name := range names {
+		path := filepath.Join(collectionPath, name)
+
+		bytes, err := d.readRecord(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		var v visibility
+		if err := d.codecFor(collection).Unmarshal(bytes, &v); err != nil || !isExpired(v.ExpiresAt, now) {
+			continue
+		}
+
+		id := d.recordID(collection, name)
+
+		if d.skipChange(OpDelete, collection, id, nil) {
+			continue
+		}
+
+		d.watchers.markSelfChange(path)
+
+		if err := d.storage.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove file: %s (%s)", path, err)
+		}
+
+		d.log.Debug("Reaped expired record: %s/%s", collection, id)
+
+		d.reindex(collection, id, nil)
+		d.cache.invalidate(collection, id)
+		d.logChange(OpDelete, collection, id, nil)
+		d.notify(OpDelete, collection, id)
+	}
+
+	return nil
+}
+
+// stampExpiry sets the expiry time of a new record from the DefaultTTL of
+// its collection, unless it already has one.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - record: The record about to be written.
+func (d *Driver) stampExpiry(collection string, record map[string]interface{}) {
+	ttl := d.collectionConfig(collection).DefaultTTL
+	if ttl <= 0 {
+		return
+	}
+
+	if _, ok := record[expiresAtField]; !ok {
+		record[expiresAtField] = time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// isExpired reports whether an "_expires_at" value is at or before now.
+// Empty or invalid values never expire.
+func isExpired(expiresAt string, now time.Time) bool {
+	if expiresAt == "" {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return false
+	}
+
+	return !t.After(now)
+}
@@ -0,0 +1,164 @@
+package bdb
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeExpired writes a record that expired an hour ago.
+func writeExpired(t *testing.T, db *Driver, collection, id string) {
+	t.Helper()
+
+	record := map[string]interface{}{
+		"name":         "expired",
+		expiresAtField: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano),
+	}
+	if err := db.WriteWithID(collection, id, record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteWithTTL(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if _, err := db.WriteWithTTL("sessions", map[string]interface{}{}, 0); err == nil {
+		t.Error("WriteWithTTL with a zero ttl: got nil error")
+	}
+
+	id, err := db.WriteWithTTL("sessions", map[string]interface{}{"user": "a"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("sessions", id, &record); err != nil {
+		t.Fatalf("Read before expiry: %s", err)
+	}
+	if _, ok := record[expiresAtField]; !ok {
+		t.Errorf("record has no %s field: %v", expiresAtField, record)
+	}
+}
+
+func TestExpiredRecordIsAbsent(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	writeExpired(t, db, "sessions", "old")
+	if err := db.WriteWithID("sessions", "live", map[string]interface{}{"name": "live"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("sessions", "old", &record); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read: got %v, want ErrNotFound", err)
+	}
+
+	records, err := db.ReadAll("sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("ReadAll returned %d records, want 1", len(records))
+	}
+
+	if exists, err := db.Exists("sessions", "old"); err != nil || exists {
+		t.Errorf("Exists = %t, %v; want false", exists, err)
+	}
+
+	if err := db.Update("sessions", "old", map[string]interface{}{"name": "updated"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update: got %v, want ErrNotFound", err)
+	}
+
+	// The file stays until the reaper removes it.
+	if n, err := db.Count("sessions"); err != nil || n != 2 {
+		t.Errorf("Count = %d, %v; want 2", n, err)
+	}
+}
+
+func TestUpsertReplacesExpiredRecord(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	writeExpired(t, db, "sessions", "a")
+
+	created, err := db.Upsert("sessions", "a", map[string]interface{}{"user": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("Upsert reported an update of an expired record")
+	}
+
+	var record map[string]interface{}
+	if err := db.Read("sessions", "a", &record); err != nil {
+		t.Fatalf("Read after Upsert: %s", err)
+	}
+	if _, ok := record["name"]; ok {
+		t.Errorf("fields of the expired record were kept: %v", record)
+	}
+	if record["user"] != "b" || record[versionField] != float64(1) {
+		t.Errorf("record = %v, want user b at version 1", record)
+	}
+}
+
+func TestReaperRemovesExpiredRecords(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	writeExpired(t, db, "sessions", "old")
+	if err := db.WriteWithID("sessions", "live", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := db.StartReaper(10 * time.Millisecond)
+	defer stop()
+
+	path := db.recordPath("sessions", "old")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired record not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if exists, err := db.Exists("sessions", "live"); err != nil || !exists {
+		t.Errorf("live record: Exists = %t, %v", exists, err)
+	}
+}
+
+func TestStartReaperRejectsInvalidInterval(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		stop := db.StartReaper(interval)
+		stop()
+	}
+}
+
+func TestCloseStopsReaper(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openTestDriver(t, dir, nil)
+	stop := db.StartReaper(time.Millisecond)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Stopping a reaper stopped by Close does nothing.
+	stop()
+
+	// A record expiring after Close is left to the next driver.
+	db = openTestDriver(t, dir, nil)
+	writeExpired(t, db, "sessions", "old")
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := os.Stat(db.recordPath("sessions", "old")); err != nil {
+		t.Errorf("record reaped after Close: %v", err)
+	}
+
+	// Nothing is started on a closed driver.
+	db.Close()
+	db.StartReaper(time.Millisecond)()
+}
@@ -0,0 +1,375 @@
+package bdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// ErrTxDone is returned when a transaction is used after Commit or
+// Rollback.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+type txOpKind int
+
+const (
+	txWrite txOpKind = iota
+	txUpdate
+	txDelete
+)
+
+// txEventOps maps transaction operations to the events they emit.
+var txEventOps = map[txOpKind]Op{
+	txWrite:  OpCreate,
+	txUpdate: OpUpdate,
+	txDelete: OpDelete,
+}
+
+type txOp struct {
+	kind  txOpKind
+	id    string
+	data  map[string]interface{}
+	order *keyOrder
+}
+
+// txBackup holds the content of a record before a transaction touched it.
+type txBackup struct {
+	path    string
+	data    []byte
+	existed bool
+}
+
+// Tx is a transaction over a single collection.
+//
+// Operations are buffered and only applied on Commit, all or nothing.
+// Isolation is per collection: the collection lock is held while the
+// transaction is applied, whatever Options.LockGranularity is, but
+// operations on other collections are not coordinated. A Tx is not safe
+// for concurrent use.
+type Tx struct {
+	driver     *Driver
+	collection string
+	ops        []txOp
+	done       bool
+}
+
+// Begin starts a transaction on a collection.
+//
+// Parameters:
+// - collection: The name of the collection.
+//
+// Returns:
+// - *Tx: The new transaction.
+func (d *Driver) Begin(collection string) *Tx {
+	return &Tx{driver: d, collection: collection}
+}
+
+// Write buffers the creation of a new record.
+//
+// Parameters:
+// - v: The data to write.
+//
+// Returns:
+// - string: The ID the record will be stored under.
+// - error: An error if the transaction is done or v cannot be converted.
+func (tx *Tx) Write(v interface{}) (string, error) {
+	if tx.done {
+		return "", ErrTxDone
+	}
+
+	data, err := util.ToMap(v)
+	if err != nil {
+		return "", fmt.Errorf("error converting data to map: %s", err)
+	}
+	util.DropDeleted(data)
+
+	id := tx.driver.newID()
+	data["_id"] = id
+
+	tx.ops = append(tx.ops, txOp{kind: txWrite, id: id, data: data, order: tx.driver.keyOrderOf(tx.collection, v)})
+
+	return id, nil
+}
+
+// Update buffers a merge update of a record, applied like Driver.Update.
+//
+// Parameters:
+// - resource: The name of the resource to update.
+// - v: The data to update.
+//
+// Returns:
+// - error: An error if the transaction is done or v cannot be converted.
+func (tx *Tx) Update(resource string, v interface{}) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if resource == "" {
+		return ErrResourceMissing
+	}
+
+	if err := validateName(resource); err != nil {
+		return err
+	}
+
+	data, err := util.ToMap(v)
+	if err != nil {
+		return fmt.Errorf("error converting data to map: %s", err)
+	}
+
+	tx.ops = append(tx.ops, txOp{kind: txUpdate, id: resource, data: data, order: tx.driver.keyOrderOf(tx.collection, v)})
+
+	return nil
+}
+
+// Delete buffers the removal of a record.
+//
+// Parameters:
+// - resource: The name of the resource to delete.
+//
+// Returns:
+// - error: An error if the transaction is done.
+func (tx *Tx) Delete(resource string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if resource == "" {
+		return ErrResourceMissing
+	}
+
+	if err := validateName(resource); err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, txOp{kind: txDelete, id: resource})
+
+	return nil
+}
+
+// Rollback discards the buffered operations.
+//
+// Returns:
+// - error: ErrTxDone if the transaction was already committed or rolled back.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	tx.done = true
+	tx.ops = nil
+
+	return nil
+}
+
+// Commit applies the buffered operations in order.
+//
+// Before a record is first touched, its content is backed up. If any
+// operation fails, every record is restored from its backup and records
+// created by the transaction are removed. With Options.DryRun, nothing is
+// applied and ErrDryRun is returned.
+//
+// Returns:
+// - error: The error of the failed operation, ErrDryRun, or ErrTxDone.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	d := tx.driver
+
+	if err := d.checkWritable(tx.collection); err != nil {
+		return err
+	}
+
+	if d.dryRun {
+		return ErrDryRun
+	}
+
+	if tx.collection == "" {
+		return ErrCollectionMissing
+	}
+
+	if err := validateCollection(tx.collection); err != nil {
+		return err
+	}
+
+	unlock := d.lockCollection(tx.collection)
+	defer unlock()
+
+	dir := filepath.Join(d.dir, tx.collection)
+	if err := d.mkdirAll(dir); err != nil {
+		return err
+	}
+
+	backups := map[string]txBackup{}
+	records := map[string]map[string]interface{}{}
+	var touched []string
+
+	for _, op := range tx.ops {
+		path, err := d.locateRecord(tx.collection, op.id)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			tx.restore(touched, backups)
+			return fmt.Errorf("unable to check resource: %s (%s)", path, err)
+		}
+		if !exists {
+			path = d.recordPath(tx.collection, op.id)
+		}
+
+		backedUp := false
+		if _, ok := backups[op.id]; !ok {
+			original, err := d.storage.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				tx.restore(touched, backups)
+				return fmt.Errorf("error reading file: %s (%s)", path, err)
+			}
+			backups[op.id] = txBackup{path: path, data: original, existed: err == nil}
+			touched = append(touched, op.id)
+			backedUp = true
+		}
+
+		record, err := tx.apply(path, exists, op, records)
+		if err != nil {
+			// A failed operation writes nothing, so a record it was the
+			// first to touch is left as is, e.g. one another process
+			// created under a strict insert.
+			if backedUp {
+				touched = touched[:len(touched)-1]
+			}
+			tx.restore(touched, backups)
+			return err
+		}
+		records[op.id] = record
+	}
+
+	for _, id := range touched {
+		d.reindex(tx.collection, id, records[id])
+		d.cache.invalidate(tx.collection, id)
+	}
+
+	for _, op := range tx.ops {
+		d.logChange(txEventOps[op.kind], tx.collection, op.id, records[op.id])
+		d.notify(txEventOps[op.kind], tx.collection, op.id)
+	}
+
+	return nil
+}
+
+// apply performs a single buffered operation on the record at path and
+// returns the new content of the record, nil if it was deleted. Records
+// holds the records already changed by the transaction, which unique
+// constraints are checked against along with the indexes.
+func (tx *Tx) apply(path string, exists bool, op txOp, records map[string]map[string]interface{}) (map[string]interface{}, error) {
+	switch op.kind {
+	case txWrite:
+		if exists {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateKey, path)
+		}
+
+		if err := tx.driver.checkQuota(tx.collection); err != nil {
+			return nil, err
+		}
+
+		op.data[versionField] = 1
+		tx.driver.stampCreated(op.data)
+		tx.driver.stampExpiry(tx.collection, op.data)
+
+		if err := tx.driver.validate(tx.collection, op.data); err != nil {
+			return nil, err
+		}
+
+		if err := tx.driver.checkUnique(tx.collection, op.id, op.data, records); err != nil {
+			return nil, err
+		}
+
+		bytes, err := tx.driver.marshalRecord(tx.collection, op.data, op.order)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling json: %w", err)
+		}
+
+		return op.data, tx.driver.createRecord(tx.collection, path, bytes)
+
+	case txUpdate:
+		if !exists {
+			return nil, fmt.Errorf("%w: resource %s", ErrNotFound, path)
+		}
+
+		bytes, err := tx.driver.readRecord(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s (%s)", path, err)
+		}
+
+		if tx.driver.isHidden(tx.collection, bytes, false) {
+			return nil, fmt.Errorf("%w: resource %s/%s (record is deleted or expired)", ErrNotFound, tx.collection, op.id)
+		}
+
+		existing, err := tx.driver.decodeRecord(tx.collection, bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling json: %w", err)
+		}
+
+		created := existing[createdAtField]
+		version := recordVersion(existing)
+
+		util.UpdateMapWith(op.data, existing, tx.driver.arrayMerge)
+
+		existing[versionField] = version + 1
+		tx.driver.stampUpdated(existing, created)
+
+		if err := tx.driver.validate(tx.collection, existing); err != nil {
+			return nil, err
+		}
+
+		if err := tx.driver.checkUnique(tx.collection, op.id, existing, records); err != nil {
+			return nil, err
+		}
+
+		bytes, err = tx.driver.marshalRecord(tx.collection, existing, tx.driver.recordKeyOrder(tx.collection, bytes).merge(op.order))
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling json: %w", err)
+		}
+
+		return existing, tx.driver.writeRecord(tx.collection, path, bytes)
+
+	case txDelete:
+		// Like Delete, it removes soft-deleted and expired records too.
+		if !exists {
+			return nil, fmt.Errorf("%w: resource %s", ErrNotFound, path)
+		}
+
+		tx.driver.watchers.markSelfChange(path)
+
+		return nil, tx.driver.storage.Remove(path)
+	}
+
+	return nil, fmt.Errorf("unknown transaction operation: %d", op.kind)
+}
+
+// restore puts back the original content of every touched record, in
+// reverse order.
+func (tx *Tx) restore(touched []string, backups map[string]txBackup) {
+	for i := len(touched) - 1; i >= 0; i-- {
+		backup := backups[touched[i]]
+		path := backup.path
+
+		tx.driver.watchers.markSelfChange(path)
+
+		var err error
+		if backup.existed {
+			err = tx.driver.writeFileAtomic(path, backup.data)
+		} else if err = tx.driver.storage.Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+
+		if err != nil {
+			tx.driver.log.Error("Unable to roll back record: %s (%s)", path, err)
+		}
+
+		tx.driver.cache.invalidate(tx.collection, touched[i])
+	}
+}
@@ -0,0 +1,65 @@
+package bdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTxUpdateSkipsHiddenRecords(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "deleted", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDelete("users", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	writeExpired(t, db, "users", "expired")
+
+	for _, id := range []string{"deleted", "expired"} {
+		tx := db.Begin("users")
+		if err := tx.Update(id, map[string]interface{}{"Name": "Jane"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Commit updating %s record: got %v, want ErrNotFound", id, err)
+		}
+	}
+
+	records, err := db.ReadAllIncludingDeleted("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if strings.Contains(record, "Jane") {
+			t.Errorf("hidden record updated: %s", record)
+		}
+	}
+}
+
+func TestTxMarksSelfChanges(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	path := db.recordPath("users", "a")
+
+	// Pretend WatchFS is running, so the driver remembers its changes.
+	db.watchers.mutex.Lock()
+	db.watchers.fsWatchers++
+	db.watchers.mutex.Unlock()
+
+	tx := db.Begin("users")
+	if err := tx.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !db.watchers.isSelfChange(path) {
+		t.Error("delete of a transaction not marked as a change of the driver")
+	}
+}
@@ -0,0 +1,215 @@
+package bdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// corruptDir is the reserved directory VerifyAndRepair moves corrupt
+// records to, under a subdirectory named after their collection.
+const corruptDir = "_corrupt"
+
+// Report describes the outcome of an integrity scan.
+type Report struct {
+	// Collections is the number of collections scanned.
+	Collections int
+	// Records is the number of record files scanned.
+	Records int
+	// Corrupt lists the paths of the record files that cannot be read or
+	// decoded.
+	Corrupt []string
+	// TempFiles lists the paths of the temporary files left by
+	// interrupted writes; Compact removes them.
+	TempFiles []string
+	// MissingID lists the paths of the records without an "_id" field.
+	MissingID []string
+	// Quarantined lists the paths corrupt records were moved to by
+	// VerifyAndRepair.
+	Quarantined []string
+}
+
+// OK reports whether the scan found no problem.
+//
+// Returns:
+// - bool: True if no corrupt record, temporary file or record without an ID was found.
+func (r Report) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.TempFiles) == 0 && len(r.MissingID) == 0
+}
+
+// Verify scans every record of every collection and reports the problems
+// found, without changing anything.
+//
+// Each record is read and decoded with the codec of its collection.
+// Records encrypted while no key is configured cannot be checked and are
+// not reported as corrupt.
+//
+// Returns:
+// - Report: The problems found.
+// - error: An error if a collection cannot be listed.
+func (d *Driver) Verify() (Report, error) {
+	return d.verify(false)
+}
+
+// VerifyAndRepair is like Verify but moves the corrupt records out of their
+// collection, to "_corrupt/<collection>/" in the database directory, where
+// they can be inspected or restored by hand. Moved records are treated as
+// deleted: they leave the indexes and watchers are notified. Temporary
+// files are left for Compact.
+//
+// Returns:
+// - Report: The problems found and the paths the corrupt records were moved to.
+// - error: ErrDryRun with Options.DryRun, or an error if a collection cannot be listed or a record cannot be moved.
+func (d *Driver) VerifyAndRepair() (Report, error) {
+	if err := d.checkWritable(""); err != nil {
+		return Report{}, err
+	}
+
+	if d.dryRun {
+		return Report{}, ErrDryRun
+	}
+
+	return d.verify(true)
+}
+
+// verify scans every collection.
+//
+// Parameters:
+// - repair: Whether corrupt records are moved to the corrupt directory.
+//
+// Returns:
+// - Report: The problems found.
+// - error: An error if a collection cannot be scanned.
+func (d *Driver) verify(repair bool) (Report, error) {
+	var report Report
+
+	collections, err := d.Collections()
+	if err != nil {
+		return report, err
+	}
+
+	for _, collection := range collections {
+		if err := d.verifyCollection(collection, repair, &report); err != nil {
+			return report, err
+		}
+		report.Collections++
+	}
+
+	return report, nil
+}
+
+// verifyCollection scans the files of a collection, adding the problems
+// found to the report.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - repair: Whether corrupt records are moved to the corrupt directory.
+// - report: The report to fill.
+//
+// Returns:
+// - error: An error if the collection cannot be listed or a record cannot be moved.
+func (d *Driver) verifyCollection(collection string, repair bool, report *Report) error {
+	if repair {
+		if err := d.checkWritable(collection); err != nil {
+			return err
+		}
+
+		unlock := d.lockCollection(collection)
+		defer unlock()
+	} else {
+		unlock := d.rlockCollection(collection)
+		defer unlock()
+	}
+
+	collectionPath := filepath.Join(d.dir, collection)
+
+	var names []string
+	var entries []os.DirEntry
+	err := d.walkShards(collectionPath, d.shardDepthFor(collection), func(name string, entry os.DirEntry) {
+		names = append(names, name)
+		entries = append(entries, entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		name := names[i]
+		path := filepath.Join(collectionPath, name)
+
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".tmp") {
+			report.TempFiles = append(report.TempFiles, path)
+			continue
+		}
+
+		if !d.isRecordFile(collection, entry) {
+			continue
+		}
+
+		report.Records++
+
+		bytes, err := d.readRecord(path)
+		if errors.Is(err, ErrEncrypted) {
+			continue
+		}
+
+		var record map[string]interface{}
+		if err == nil {
+			record, err = d.decodeRecord(collection, bytes)
+		}
+
+		if err != nil {
+			d.log.Warn("Corrupt record: %s (%s)", path, err)
+			report.Corrupt = append(report.Corrupt, path)
+
+			if repair {
+				dest, err := d.quarantine(collection, name)
+				if err != nil {
+					return err
+				}
+				report.Quarantined = append(report.Quarantined, dest)
+			}
+			continue
+		}
+
+		if _, ok := record["_id"]; !ok {
+			report.MissingID = append(report.MissingID, path)
+		}
+	}
+
+	return nil
+}
+
+// quarantine moves a record file of a collection to the corrupt directory.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - name: The filename of the record, relative to the collection directory.
+//
+// Returns:
+// - string: The path the record was moved to.
+// - error: An error if the record cannot be moved.
+func (d *Driver) quarantine(collection, name string) (string, error) {
+	path := filepath.Join(d.dir, collection, name)
+	dest := filepath.Join(d.dir, corruptDir, collection, name)
+
+	if err := d.mkdirAll(filepath.Dir(dest)); err != nil {
+		return "", err
+	}
+
+	if err := d.storage.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("unable to quarantine record: %s (%s)", path, err)
+	}
+
+	id := d.recordID(collection, name)
+	d.reindex(collection, id, nil)
+	d.cache.invalidate(collection, id)
+	d.logChange(OpDelete, collection, id, nil)
+	d.notify(OpDelete, collection, id)
+
+	d.log.Warn("Quarantined corrupt record: %s -> %s", path, dest)
+
+	return dest, nil
+}
@@ -0,0 +1,65 @@
+package bdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/babu10103/bdb/util"
+)
+
+// versionField holds the version of a record, 1 when written and
+// incremented by every change.
+const versionField = "_version"
+
+// ErrVersionConflict is returned by UpdateWithVersion when the record has
+// changed since the expected version was read.
+var ErrVersionConflict = errors.New("record version conflict")
+
+// UpdateWithVersion updates a record like Update, but only if it is still
+// at the expected version.
+//
+// Read the record, including its "_version" field, make the changes and
+// pass the version that was read: if another writer changed the record in
+// the meantime, the update fails instead of clobbering that change, and
+// the caller can read the record again and retry.
+//
+// Parameters:
+// - collection: The name of the collection to update.
+// - resource: The name of the resource to update.
+// - expectedVersion: The version the record must be at, 0 for a record written before versioning.
+// - v: The data to update.
+//
+// Returns:
+// - error: ErrVersionConflict if the record is at another version, or an error if the update fails.
+func (d *Driver) UpdateWithVersion(collection, resource string, expectedVersion int, v interface{}) error {
+	return d.updateRecord(context.Background(), collection, resource, v, false, func(newData, existing map[string]interface{}) error {
+		if version := recordVersion(existing); version != expectedVersion {
+			return fmt.Errorf("%w: %s/%s is at version %d, expected %d", ErrVersionConflict, collection, resource, version, expectedVersion)
+		}
+
+		util.UpdateMapWith(newData, existing, d.arrayMerge)
+
+		return nil
+	})
+}
+
+// recordVersion returns the version of a decoded record, 0 if it has none.
+//
+// Parameters:
+// - record: The decoded record.
+//
+// Returns:
+// - int: The version.
+func recordVersion(record map[string]interface{}) int {
+	switch version := record[versionField].(type) {
+	case float64:
+		return int(version)
+	case json.Number:
+		n, _ := version.Int64()
+		return int(n)
+	}
+
+	return 0
+}
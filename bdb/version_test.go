@@ -0,0 +1,71 @@
+package bdb
+
+import (
+	"errors"
+	"testing"
+)
+
+// readVersion reads the version of a record.
+func readVersion(t *testing.T, db *Driver, collection, id string) int {
+	t.Helper()
+
+	var record struct {
+		Version int `json:"_version"`
+	}
+	if err := db.Read(collection, id, &record); err != nil {
+		t.Fatal(err)
+	}
+	return record.Version
+}
+
+func TestUpdateWithVersionRejectsStaleUpdate(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John", Age: "23"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := readVersion(t, db, "users", "a"); v != 1 {
+		t.Fatalf("version after Write = %d, want 1", v)
+	}
+
+	// Two clients read version 1; the first update wins.
+	if err := db.UpdateWithVersion("users", "a", 1, map[string]interface{}{"Name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.UpdateWithVersion("users", "a", 1, map[string]interface{}{"Age": "40"})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale update: got %v, want ErrVersionConflict", err)
+	}
+
+	var got testUser
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Jane" || got.Age != "23" {
+		t.Errorf("record = %+v, want the first update only", got)
+	}
+
+	// The second client retries with the current version.
+	if v := readVersion(t, db, "users", "a"); v != 2 {
+		t.Fatalf("version after one update = %d, want 2", v)
+	}
+	if err := db.UpdateWithVersion("users", "a", 2, map[string]interface{}{"Age": "40"}); err != nil {
+		t.Errorf("retry: %s", err)
+	}
+}
+
+func TestUpdateIncrementsVersion(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	if err := db.WriteWithID("users", "a", testUser{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Update("users", "a", map[string]interface{}{"Contact": string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := readVersion(t, db, "users", "a"); v != 4 {
+		t.Errorf("version = %d, want 4", v)
+	}
+}
@@ -0,0 +1,195 @@
+package bdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// watchBufferSize is the capacity of the channel returned by Watch.
+const watchBufferSize = 64
+
+// Op is the kind of change carried by an Event.
+type Op int
+
+const (
+	// OpCreate reports a new record.
+	OpCreate Op = iota
+	// OpUpdate reports a modified record.
+	OpUpdate
+	// OpDelete reports a removed record.
+	OpDelete
+)
+
+// String returns the name of the operation.
+func (op Op) String() string {
+	switch op {
+	case OpCreate:
+		return "Create"
+	case OpUpdate:
+		return "Update"
+	case OpDelete:
+		return "Delete"
+	}
+
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Event describes a change to a record.
+type Event struct {
+	Op         Op
+	Collection string
+	ID         string
+	Time       time.Time
+}
+
+// selfChangeWindow is how long a change made by the driver is remembered,
+// so WatchFS can tell it apart from changes made by other processes.
+const selfChangeWindow = time.Second
+
+// watchers holds the active Watch and WatchFS subscriptions of a driver.
+type watchers struct {
+	mutex  sync.Mutex
+	nextID int
+	subs   map[string]map[int]chan Event
+
+	// fsWatchers counts active WatchFS subscriptions; changes made by the
+	// driver are only tracked, by record path, while it is non-zero.
+	fsWatchers int
+	recent     map[string]time.Time
+}
+
+// Watch subscribes to the changes made through this driver to a collection.
+//
+// An event is sent after each successful Write, Update or Delete. The
+// channel is buffered; if a consumer falls behind, events are dropped with
+// a logged warning so writers never block.
+//
+// Parameters:
+// - collection: The name of the collection to watch.
+//
+// Returns:
+// - <-chan Event: The channel receiving the events.
+// - func(): The function that unsubscribes and closes the channel; Close calls it too.
+// - error: An error if the collection name is missing.
+func (d *Driver) Watch(collection string) (<-chan Event, func(), error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+
+	if collection == "" {
+		return nil, nil, ErrCollectionMissing
+	}
+
+	if err := validateCollection(collection); err != nil {
+		return nil, nil, err
+	}
+
+	w := &d.watchers
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.subs == nil {
+		w.subs = make(map[string]map[int]chan Event)
+	}
+	if w.subs[collection] == nil {
+		w.subs[collection] = make(map[int]chan Event)
+	}
+
+	id := w.nextID
+	w.nextID++
+
+	ch := make(chan Event, watchBufferSize)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+
+			delete(w.subs[collection], id)
+			if len(w.subs[collection]) == 0 {
+				delete(w.subs, collection)
+			}
+			close(ch)
+		})
+	}
+
+	// Close may call cancel as soon as it is registered; it then waits for
+	// the mutex, so the subscription is removed once added.
+	forget, err := d.onClose(cancel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.subs[collection][id] = ch
+
+	return ch, func() {
+		forget()
+		cancel()
+	}, nil
+}
+
+// notify sends an event to every watcher of a collection without blocking.
+//
+// Parameters:
+// - op: The kind of change.
+// - collection: The name of the collection.
+// - id: The ID of the changed record.
+func (d *Driver) notify(op Op, collection, id string) {
+	w := &d.watchers
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.subs[collection]) == 0 {
+		return
+	}
+
+	event := Event{Op: op, Collection: collection, ID: id, Time: time.Now()}
+
+	for _, ch := range w.subs[collection] {
+		select {
+		case ch <- event:
+		default:
+			d.log.Warn("Dropping %s event for '%s/%s': watcher is falling behind", op, collection, id)
+		}
+	}
+}
+
+// markSelfChange remembers that the driver is about to change the record
+// file at path, while any WatchFS subscription is active. It must be
+// called before the file is touched so the notification cannot overtake
+// it.
+func (w *watchers) markSelfChange(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.fsWatchers == 0 {
+		return
+	}
+
+	if w.recent == nil {
+		w.recent = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for k, t := range w.recent {
+		if now.Sub(t) > selfChangeWindow {
+			delete(w.recent, k)
+		}
+	}
+
+	w.recent[path] = now
+}
+
+// isSelfChange reports whether the driver changed the record file at path
+// within the last selfChangeWindow.
+func (w *watchers) isSelfChange(path string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	t, ok := w.recent[path]
+	return ok && time.Since(t) <= selfChangeWindow
+}
@@ -0,0 +1,98 @@
+package bdb
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent receives an event, failing the test after a second.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestWatchEvents(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	events, unsubscribe, err := db.Watch("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	id, err := db.Write("users", testUser{Name: "John"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteWithID("other", "a", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("users", id, map[string]interface{}{"Name": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("users", id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, op := range []Op{OpCreate, OpUpdate, OpDelete} {
+		event := nextEvent(t, events)
+		if event.Op != op || event.Collection != "users" || event.ID != id {
+			t.Errorf("event = %+v, want %s of users/%s", event, op, id)
+		}
+		if event.Time.Before(start) {
+			t.Errorf("event time %s is before the change", event.Time)
+		}
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("events channel open after unsubscribing")
+	}
+}
+
+func TestWatchDropsEventsOfSlowConsumer(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	events, unsubscribe, err := db.Watch("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// Writes must not block on a consumer that does not read.
+	for i := 0; i < watchBufferSize*2; i++ {
+		if _, err := db.Write("users", map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(events) != watchBufferSize {
+		t.Errorf("%d events buffered, want %d", len(events), watchBufferSize)
+	}
+}
+
+func TestCloseClosesWatchChannels(t *testing.T) {
+	db := newTestDriver(t, nil)
+
+	events, unsubscribe, err := db.Watch("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+	if _, ok := <-events; ok {
+		t.Error("events channel open after Close")
+	}
+	// Unsubscribing after Close does nothing.
+	unsubscribe()
+}
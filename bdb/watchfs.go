@@ -0,0 +1,220 @@
+package bdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchFS reports changes made to a collection's files by other processes
+// or by hand, using filesystem notifications.
+//
+// The temporary-file-then-rename pattern used for atomic writes is
+// coalesced into a single OpCreate or OpUpdate event, and temporary files
+// are never reported. Changes made through this driver within the last
+// second are skipped since Watch already reports them. Like Watch, events
+// are dropped with a logged warning if the consumer falls behind. In
+// sharded collections, the shard directories are watched as well.
+//
+// Parameters:
+// - collection: The name of the collection to watch. It must exist.
+//
+// Returns:
+// - <-chan Event: The channel receiving the events.
+// - func(): The function that stops watching and closes the channel; Close calls it too.
+// - error: An error if the collection does not exist or cannot be watched, e.g. as the database is not stored on disk.
+func (d *Driver) WatchFS(collection string) (<-chan Event, func(), error) {
+	if err := d.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := d.storage.(DiskStorage); !ok {
+		return nil, nil, fmt.Errorf("unable to watch collection: %s (storage is not on disk)", collection)
+	}
+
+	collectionPath, names, err := d.recordNames(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := watcher.Add(collectionPath); err != nil {
+		watcher.Close()
+		return nil, nil, err
+	}
+
+	// Shard directories are watched too, including those created later.
+	depth := d.shardDepthFor(collection)
+	d.watchShards(watcher, collectionPath, depth)
+
+	// Records known to exist, so that a rename over an existing record is
+	// reported as an update rather than a creation.
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[d.recordID(collection, name)] = true
+	}
+
+	d.watchers.mutex.Lock()
+	d.watchers.fsWatchers++
+	d.watchers.mutex.Unlock()
+
+	ch := make(chan Event, watchBufferSize)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				d.log.Warn("Error watching '%s': %s", collectionPath, err)
+
+			case fsEvent, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if depth > 0 && fsEvent.Has(fsnotify.Create) && isShardName(filepath.Base(fsEvent.Name)) {
+					rel, err := filepath.Rel(collectionPath, fsEvent.Name)
+					level := strings.Count(rel, string(filepath.Separator)) + 1
+					if fi, statErr := os.Stat(fsEvent.Name); err == nil && statErr == nil && fi.IsDir() && level <= depth {
+						if err := watcher.Add(fsEvent.Name); err != nil {
+							d.log.Warn("Unable to watch '%s': %s", fsEvent.Name, err)
+						}
+						d.watchShards(watcher, fsEvent.Name, depth-level)
+						continue
+					}
+				}
+
+				event, ok := d.fsEvent(collection, fsEvent, known)
+				if !ok || d.watchers.isSelfChange(fsEvent.Name) {
+					continue
+				}
+
+				select {
+				case ch <- event:
+				default:
+					d.log.Warn("Dropping %s event for '%s/%s': watcher is falling behind", event.Op, collection, event.ID)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			watcher.Close()
+			<-stopped
+			close(ch)
+
+			d.watchers.mutex.Lock()
+			d.watchers.fsWatchers--
+			d.watchers.mutex.Unlock()
+		})
+	}
+	forget, err := d.onClose(cancel)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return ch, func() {
+		forget()
+		cancel()
+	}, nil
+}
+
+// watchShards adds the shard directories below a directory to a watcher.
+//
+// Parameters:
+// - watcher: The watcher.
+// - dir: The collection directory, or a shard directory.
+// - levels: The number of shard levels below dir.
+func (d *Driver) watchShards(watcher *fsnotify.Watcher, dir string, levels int) {
+	if levels <= 0 {
+		return
+	}
+
+	entries, err := d.storage.ReadDir(dir)
+	if err != nil {
+		d.log.Warn("Unable to watch '%s': %s", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !isShardName(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := watcher.Add(path); err != nil {
+			d.log.Warn("Unable to watch '%s': %s", path, err)
+			continue
+		}
+
+		d.watchShards(watcher, path, levels-1)
+	}
+}
+
+// fsEvent translates a filesystem notification into an Event.
+//
+// Parameters:
+// - collection: The name of the collection.
+// - fsEvent: The filesystem notification.
+// - known: The IDs of the records known to exist, updated in place.
+//
+// Returns:
+// - Event: The translated event.
+// - bool: False if the notification does not concern a record.
+func (d *Driver) fsEvent(collection string, fsEvent fsnotify.Event, known map[string]bool) (Event, bool) {
+	name := filepath.Base(fsEvent.Name)
+	if !d.isRecordName(collection, name) {
+		return Event{}, false
+	}
+
+	id := d.recordID(collection, name)
+	if filepath.Dir(fsEvent.Name) != filepath.Join(d.dir, collection, shardDir(id, d.shardDepthFor(collection))) {
+		return Event{}, false
+	}
+
+	event := Event{Collection: collection, ID: id, Time: time.Now()}
+
+	switch {
+	case fsEvent.Has(fsnotify.Create):
+		event.Op = OpCreate
+		if known[id] {
+			event.Op = OpUpdate
+		}
+		known[id] = true
+
+	case fsEvent.Has(fsnotify.Write):
+		event.Op = OpUpdate
+		known[id] = true
+
+	case fsEvent.Has(fsnotify.Remove), fsEvent.Has(fsnotify.Rename):
+		event.Op = OpDelete
+		delete(known, id)
+
+	default:
+		return Event{}, false
+	}
+
+	return event, true
+}
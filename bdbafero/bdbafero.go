@@ -0,0 +1,122 @@
+// Package bdbafero stores the files of a bdb.Driver in an afero.Fs, such
+// as an in-memory, read-only or layered file system.
+//
+// It lives in its own package so that the core bdb package does not
+// depend on afero. Pass it as the storage of the driver:
+//
+//	db, err := bdb.New("mydb", &bdb.Options{
+//		Storage: bdbafero.New(afero.NewMemMapFs()),
+//	})
+//	if err != nil {
+//		return err
+//	}
+//
+// WatchFS is not supported, as changes cannot be watched through afero,
+// and the database directory is not locked against other processes.
+package bdbafero
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/babu10103/bdb/bdb"
+	"github.com/spf13/afero"
+)
+
+// Storage is a bdb.Storage backed by an afero.Fs.
+type Storage struct {
+	fs afero.Fs
+}
+
+var _ bdb.Storage = Storage{}
+
+// New creates a storage backed by a file system.
+//
+// Parameters:
+// - fs: The file system, e.g. afero.NewMemMapFs(); nil for afero.NewOsFs().
+//
+// Returns:
+// - Storage: The storage.
+func New(fs afero.Fs) Storage {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+
+	return Storage{fs: fs}
+}
+
+// ReadFile returns the content of a file.
+func (s Storage) ReadFile(path string) ([]byte, error) {
+	return afero.ReadFile(s.fs, path)
+}
+
+// WriteFile creates or truncates a file and writes data to it.
+func (s Storage) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return afero.WriteFile(s.fs, path, data, perm)
+}
+
+// AppendFile appends data to a file in a single write, creating the file if
+// needed.
+func (s Storage) AppendFile(path string, data []byte, perm os.FileMode) error {
+	f, err := s.fs.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Rename moves a file, replacing the destination if it exists.
+func (s Storage) Rename(oldPath, newPath string) error {
+	return s.fs.Rename(oldPath, newPath)
+}
+
+// ReadDir lists a directory sorted by name.
+func (s Storage) ReadDir(path string) ([]os.DirEntry, error) {
+	infos, err := afero.ReadDir(s.fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]os.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// Stat describes a file or directory.
+func (s Storage) Stat(path string) (os.FileInfo, error) {
+	return s.fs.Stat(path)
+}
+
+// MkdirAll creates a directory and any missing parents.
+func (s Storage) MkdirAll(path string, perm os.FileMode) error {
+	return s.fs.MkdirAll(path, perm)
+}
+
+// Chmod changes the mode of a file or directory.
+func (s Storage) Chmod(path string, mode os.FileMode) error {
+	return s.fs.Chmod(path, mode)
+}
+
+// Remove removes a file or an empty directory.
+func (s Storage) Remove(path string) error {
+	return s.fs.Remove(path)
+}
+
+// RemoveAll removes a path and everything it contains.
+func (s Storage) RemoveAll(path string) error {
+	return s.fs.RemoveAll(path)
+}
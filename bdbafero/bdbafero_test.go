@@ -0,0 +1,98 @@
+package bdbafero
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/babu10103/bdb/bdb"
+	"github.com/jcelliott/lumber"
+	"github.com/spf13/afero"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+// newDriver opens a database in fs, closed when the test ends.
+func newDriver(t *testing.T, fs afero.Fs, opts bdb.Options) *bdb.Driver {
+	t.Helper()
+
+	opts.Storage = New(fs)
+	opts.Logger = lumber.NewConsoleLogger(lumber.ERROR)
+
+	db, err := bdb.New("/db", &opts)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestMemMapFs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	db := newDriver(t, fs, bdb.Options{})
+
+	if err := db.WriteWithID("users", "a", user{Name: "John", Age: 23}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Write("users", user{Name: "Jane", Age: 31}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The records live in the file system, not on disk.
+	if exists, err := afero.Exists(fs, "/db/users/a.json"); err != nil || !exists {
+		t.Errorf("record file in the afero fs: %t, %v", exists, err)
+	}
+	if _, err := os.Stat("/db/users/a.json"); !os.IsNotExist(err) {
+		t.Errorf("record written to disk: %v", err)
+	}
+
+	if err := db.Update("users", "a", map[string]interface{}{"Age": 24}); err != nil {
+		t.Fatal(err)
+	}
+	var got user
+	if err := db.Read("users", "a", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (user{Name: "John", Age: 24}) {
+		t.Errorf("Read = %+v", got)
+	}
+
+	records, err := db.ReadAll("users")
+	if err != nil || len(records) != 2 {
+		t.Errorf("ReadAll = %d records, %v; want 2", len(records), err)
+	}
+
+	if err := db.Delete("users", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Read("users", "a", &got); !errors.Is(err, bdb.ErrNotFound) {
+		t.Errorf("Read after Delete: got %v, want ErrNotFound", err)
+	}
+	if collections, err := db.Collections(); err != nil || len(collections) != 1 {
+		t.Errorf("Collections = %v, %v", collections, err)
+	}
+}
+
+func TestReadOnlyFs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	db := newDriver(t, fs, bdb.Options{})
+	if err := db.WriteWithID("users", "a", user{Name: "John"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write probe of New reports a read-only file system.
+	_, err := bdb.New("/db", &bdb.Options{Storage: New(afero.NewReadOnlyFs(fs)), Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err == nil {
+		t.Fatal("New on a read-only fs: got nil error")
+	}
+
+	ro := newDriver(t, afero.NewReadOnlyFs(fs), bdb.Options{ReadOnly: true})
+	var got user
+	if err := ro.Read("users", "a", &got); err != nil || got.Name != "John" {
+		t.Errorf("Read = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package bdbprom exports the operation stats of a bdb.Driver as
+// Prometheus metrics.
+//
+// It lives in its own package so that the core bdb package does not
+// depend on the Prometheus client. Wire it up after creating the driver:
+//
+//	db, err := bdb.New("mydb", nil)
+//	if err != nil {
+//		return err
+//	}
+//	if err := bdbprom.Register(db, prometheus.DefaultRegisterer); err != nil {
+//		return err
+//	}
+package bdbprom
+
+import (
+	"github.com/babu10103/bdb/bdb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	durationDesc = prometheus.NewDesc(
+		"bdb_operation_duration_seconds",
+		"Latency of bdb operations.",
+		[]string{"method", "collection"}, nil,
+	)
+	errorsDesc = prometheus.NewDesc(
+		"bdb_operation_errors_total",
+		"Number of failed bdb operations.",
+		[]string{"method", "collection"}, nil,
+	)
+	bytesReadDesc = prometheus.NewDesc(
+		"bdb_bytes_read_total",
+		"Number of encoded record bytes read.",
+		[]string{"collection"}, nil,
+	)
+	bytesWrittenDesc = prometheus.NewDesc(
+		"bdb_bytes_written_total",
+		"Number of encoded record bytes written.",
+		[]string{"collection"}, nil,
+	)
+)
+
+// Collector is a prometheus.Collector reading a driver's Stats on every
+// scrape.
+type Collector struct {
+	driver *bdb.Driver
+}
+
+// NewCollector creates a collector for a driver.
+//
+// Parameters:
+// - d: The database driver.
+//
+// Returns:
+// - *Collector: The collector.
+func NewCollector(d *bdb.Driver) *Collector {
+	return &Collector{driver: d}
+}
+
+// Register registers a collector for a driver with reg.
+//
+// Parameters:
+// - d: The database driver.
+// - reg: The registerer, e.g. prometheus.DefaultRegisterer.
+//
+// Returns:
+// - error: An error if the metrics are already registered.
+func Register(d *bdb.Driver, reg prometheus.Registerer) error {
+	return reg.Register(NewCollector(d))
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- durationDesc
+	ch <- errorsDesc
+	ch <- bytesReadDesc
+	ch <- bytesWrittenDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for collection, cs := range c.driver.Stats().Collections {
+		ops := map[string]bdb.OpStats{
+			"read":   cs.Reads,
+			"write":  cs.Writes,
+			"update": cs.Updates,
+			"delete": cs.Deletes,
+		}
+
+		for method, op := range ops {
+			if op.Count == 0 {
+				continue
+			}
+
+			buckets := make(map[float64]uint64, len(bdb.LatencyBuckets))
+			for i, bound := range bdb.LatencyBuckets {
+				buckets[bound.Seconds()] = uint64(op.Buckets[i])
+			}
+
+			ch <- prometheus.MustNewConstHistogram(
+				durationDesc, uint64(op.Count), op.Latency.Seconds(), buckets,
+				method, collection,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				errorsDesc, prometheus.CounterValue, float64(op.Errors),
+				method, collection,
+			)
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			bytesReadDesc, prometheus.CounterValue, float64(cs.BytesRead), collection,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			bytesWrittenDesc, prometheus.CounterValue, float64(cs.BytesWritten), collection,
+		)
+	}
+}
@@ -0,0 +1,82 @@
+package bdbprom
+
+import (
+	"testing"
+
+	"github.com/babu10103/bdb/bdb"
+	"github.com/jcelliott/lumber"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterScrape(t *testing.T) {
+	db, err := bdb.New(t.TempDir(), &bdb.Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reg := prometheus.NewRegistry()
+	if err := Register(db, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if err := db.WriteWithID("users", id, map[string]interface{}{"name": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v map[string]interface{}
+	if err := db.Read("users", "a", &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Read("users", "missing", &v); err == nil {
+		t.Fatal("Read of a missing record: got nil error")
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// samples maps "<metric> <method>" to the sample count of histograms
+	// and the value of counters, for the users collection.
+	samples := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["collection"] != "users" {
+				continue
+			}
+
+			key := family.GetName() + " " + labels["method"]
+			if h := metric.GetHistogram(); h != nil {
+				samples[key] = float64(h.GetSampleCount())
+			} else {
+				samples[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"bdb_operation_duration_seconds write": 2,
+		"bdb_operation_errors_total write":     0,
+		"bdb_operation_duration_seconds read":  2,
+		"bdb_operation_errors_total read":      1,
+	}
+	for key, value := range want {
+		if got, ok := samples[key]; !ok || got != value {
+			t.Errorf("%s = %v (scraped: %t), want %v", key, got, ok, value)
+		}
+	}
+	if samples["bdb_bytes_written_total "] <= 0 {
+		t.Error("no bytes written reported")
+	}
+
+	// Registering the same metrics twice fails.
+	if err := Register(db, reg); err == nil {
+		t.Error("second Register: got nil error")
+	}
+}
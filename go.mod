@@ -2,4 +2,24 @@ module github.com/babu10103/bdb
 
 go 1.19
 
-require github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
+	github.com/prometheus/client_golang v1.17.0
+	github.com/spf13/afero v1.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.4.1-0.20230718164431-9a2bf3000d16 // indirect
+	github.com/prometheus/common v0.44.0 // indirect
+	github.com/prometheus/procfs v0.11.1 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
@@ -42,20 +42,26 @@ func main() {
 		return
 	}
 
+	var ids []string
 	for _, user := range employees {
-		db.Write("employees", user)
+		id, err := db.Write("employees", user)
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		ids = append(ids, id)
 	}
 
 	var user User
-	err = db.Read("employees", "apz01zicapy9eqixuc9uehq235", &user)
+	err = db.Read("employees", ids[0], &user)
 	if err != nil {
 		fmt.Println("Error", err)
 	}
-	db.Update("employees", "apz01zicapy9eqixuc9uehq235", User{
+	db.Update("employees", ids[0], User{
 		Address: Address{Pincode: "515671"},
 	})
 
-	err = db.Read("employees", "apz01zicapy9eqixuc9uehq235", &user)
+	err = db.Read("employees", ids[0], &user)
 	if err != nil {
 		fmt.Println("Error", err)
 	}
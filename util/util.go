@@ -1,64 +1,288 @@
 package util
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/json"
-	"math/rand"
+	"fmt"
+	"math"
+	"math/big"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 )
 
-func Stat(path string) (fi os.FileInfo, err error) {
-	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+// Stater describes files, like the Storage a bdb.Driver is configured with.
+type Stater interface {
+	Stat(path string) (os.FileInfo, error)
+}
+
+// Stat returns the FileInfo of path in fs, falling back to path+ext (the
+// record extension of the configured codec, e.g. ".json") if path does not
+// exist. Only a missing path triggers the fallback; any other error, e.g. a
+// permission failure, is returned as is, so callers can tell the two apart
+// with os.IsNotExist.
+func Stat(fs Stater, path, ext string) (fi os.FileInfo, err error) {
+	if fi, err = fs.Stat(path); os.IsNotExist(err) {
+		fi, err = fs.Stat(path + ext)
 	}
 	return fi, err
 }
 
+// ToMap converts v into a generic map through JSON. Numbers are kept as
+// json.Number, so large integers and precise decimals survive unchanged.
 func ToMap(v interface{}) (map[string]interface{}, error) {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
+	result, err := DecodeMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("value is not a JSON object: %T", v)
+	}
+	return result, nil
+}
+
+// DecodeMap decodes a JSON object, keeping numbers as json.Number. It fails
+// if data holds another JSON value, including null.
+func DecodeMap(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
 	var result map[string]interface{}
-	if err := json.Unmarshal(data, &result); err != nil {
+	if err := decoder.Decode(&result); err != nil {
 		return nil, err
 	}
+	if result == nil {
+		return nil, fmt.Errorf("json: null is not an object")
+	}
 	return result, nil
 }
 
+// ArrayMergeStrategy selects how UpdateMapWith merges an array of the new
+// map into the array stored under the same key.
+type ArrayMergeStrategy int
+
+const (
+	// ArrayReplace replaces the stored array with the new one. It is the
+	// default.
+	ArrayReplace ArrayMergeStrategy = iota
+	// ArrayAppend appends the elements of the new array to the stored one.
+	ArrayAppend
+	// ArrayUnion appends the elements of the new array that the stored one
+	// does not hold yet. Elements are equal if their JSON encodings are.
+	ArrayUnion
+)
+
+// DeleteValue is the value that, set on a key of the new map, makes
+// UpdateMapWith delete the key from the existing map instead of merging it.
+// It is a string, so it survives the JSON encoding of ToMap and can be set
+// on string struct fields as well as on map entries.
+const DeleteValue = "\x00bdb:delete\x00"
+
+// UpdateMap merges newMap into existingMap, replacing arrays. See
+// UpdateMapWith.
 func UpdateMap(newMap, existingMap map[string]interface{}) {
+	UpdateMapWith(newMap, existingMap, ArrayReplace)
+}
+
+// UpdateMapWith merges newMap into existingMap: values accepted by IsValid
+// replace the stored ones, nested maps are merged recursively and arrays
+// are merged following the strategy. Missing or null stored values are
+// always replaced. Keys set to DeleteValue are deleted.
+func UpdateMapWith(newMap, existingMap map[string]interface{}, arrays ArrayMergeStrategy) {
 	for k, v := range newMap {
+		if v == DeleteValue {
+			delete(existingMap, k)
+			continue
+		}
 		_, ok := existingMap[k]
 		if !ok || existingMap[k] == nil {
-			existingMap[k] = v
+			existingMap[k] = DropDeleted(v)
 			continue
 		}
-		if v != nil && IsValid(v) && v != existingMap[k] {
+		if v != nil && IsValid(v) && !equalScalars(v, existingMap[k]) {
 			existingMap[k] = v
 			continue
 		}
-		if reflect.TypeOf(v).Kind() == reflect.Slice && !reflect.DeepEqual(v, existingMap[k]) {
-			existingMap[k] = v
+		if reflect.ValueOf(v).Kind() == reflect.Slice {
+			existingMap[k] = mergeArrays(v, existingMap[k], arrays)
 			continue
 		}
 		if v1, ok := v.(map[string]interface{}); ok {
 			if v2, ok := existingMap[k].(map[string]interface{}); ok {
-				UpdateMap(v1, v2)
+				UpdateMapWith(v1, v2, arrays)
 			}
 		}
 	}
 }
 
+// DropDeleted removes the keys set to DeleteValue from v and the maps
+// nested in it, so a value without a stored counterpart does not store the
+// sentinel.
+//
+// Parameters:
+// - v: The value, changed in place if it is a map.
+//
+// Returns:
+// - interface{}: v.
+func DropDeleted(v interface{}) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, value := range m {
+			if value == DeleteValue {
+				delete(m, k)
+				continue
+			}
+			DropDeleted(value)
+		}
+	}
+
+	return v
+}
+
+// mergeArrays merges the array v into the stored value existing. If
+// existing is not an array, v replaces it whatever the strategy.
+func mergeArrays(v, existing interface{}, strategy ArrayMergeStrategy) interface{} {
+	if strategy == ArrayReplace {
+		return v
+	}
+
+	stored, ok := toSlice(existing)
+	if !ok {
+		return v
+	}
+	added, _ := toSlice(v)
+
+	merged := append([]interface{}{}, stored...)
+
+	if strategy == ArrayAppend {
+		return append(merged, added...)
+	}
+
+	seen := make(map[string]bool, len(stored)+len(added))
+	for _, item := range stored {
+		if key, err := json.Marshal(item); err == nil {
+			seen[string(key)] = true
+		}
+	}
+
+	for _, item := range added {
+		key, err := json.Marshal(item)
+		if err == nil && seen[string(key)] {
+			continue
+		}
+		if err == nil {
+			seen[string(key)] = true
+		}
+		merged = append(merged, item)
+	}
+
+	return merged
+}
+
+// toSlice converts a slice of any element type to a []interface{}.
+func toSlice(v interface{}) ([]interface{}, bool) {
+	if s, ok := v.([]interface{}); ok {
+		return s, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+
+	s := make([]interface{}, rv.Len())
+	for i := range s {
+		s[i] = rv.Index(i).Interface()
+	}
+
+	return s, true
+}
+
+// equalScalars reports whether a scalar accepted by IsValid equals an
+// existing value. Numbers are compared exactly whatever their type, so a
+// json.Number equals the float64 or int holding the same number, but two
+// 64-bit integers never compare equal through a rounded float64. Two
+// json.Numbers are compared as written.
+func equalScalars(v, existing interface{}) bool {
+	if na, ok := v.(json.Number); ok {
+		if nb, ok := existing.(json.Number); ok {
+			return na == nb
+		}
+	}
+
+	a, aNumber := toRat(v)
+	b, bNumber := toRat(existing)
+	if aNumber && bNumber {
+		return a.Cmp(b) == 0
+	}
+
+	// v is of a comparable type, so this cannot panic.
+	return v == existing
+}
+
+// toRat converts an int, int64, finite float64 or json.Number to an exact
+// rational number.
+func toRat(value interface{}) (*big.Rat, bool) {
+	switch v := value.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(v), true
+	case json.Number:
+		return new(big.Rat).SetString(string(v))
+	}
+
+	return nil, false
+}
+
+// ToFloat converts a decoded numeric value, an int, int64, float64 or
+// json.Number, to a float64. It reports false for any other value,
+// including a json.Number that is not a number. Large integers may lose
+// precision; use it to aggregate numbers, not to compare them.
+func ToFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// IsValid reports whether value should be applied by UpdateMap.
+//
+// Non-zero numbers, including json.Number, non-empty strings, booleans
+// (true or false) and nil are valid. Zero numbers, empty strings and any
+// other type (slices, maps) are not; UpdateMap handles slices and maps
+// separately. A json.Number is valid if it is non-zero or not a number; a
+// zero one is not, as an unset json.Number struct field marshals to 0 and
+// must not overwrite the stored value.
 func IsValid(value interface{}) bool {
 	switch v := value.(type) {
 	case int:
 		return v != 0
 	case float64:
 		return v != 0
+	case json.Number:
+		f, err := v.Float64()
+		return err != nil || f != 0
 	case string:
 		return v != ""
 	case bool:
-		return !v
+		return true
 	case nil:
 		return true
 	default:
@@ -66,11 +290,214 @@ func IsValid(value interface{}) bool {
 	}
 }
 
+const (
+	idCharSet  = "0123456789abcdefghijklmnopqrstuvwxyz"
+	idTimeLen  = 9
+	idSeqLen   = 4
+	idRandLen  = 13
+	idMaxSeq   = 36 * 36 * 36 * 36
+	idRandBase = 252 // largest multiple of 36 that fits in a byte
+)
+
+var (
+	idMutex    sync.Mutex
+	idLastTime int64
+	idSeq      int64
+)
+
+// GenerateObjectId returns a new 26 character object ID.
+//
+// The ID is made of lowercase base36 characters: 9 characters of Unix time
+// in milliseconds, a 4 character sequence number that orders IDs created
+// within the same millisecond, and 13 characters of randomness from
+// crypto/rand. IDs therefore sort lexicographically by creation order and
+// stay unique across goroutines and process restarts.
 func GenerateObjectId() string {
-	charSet := []rune("abcdefghijklmnopqrstuvwxyz0123456789")
-	b := make([]rune, 26)
-	for i := range b {
-		b[i] = charSet[rand.Intn(len(charSet))]
+	idMutex.Lock()
+	now := time.Now().UnixMilli()
+	if now <= idLastTime {
+		now = idLastTime
+		idSeq++
+		if idSeq >= idMaxSeq {
+			now++
+			idSeq = 0
+		}
+	} else {
+		idSeq = 0
+	}
+	idLastTime = now
+	seq := idSeq
+	idMutex.Unlock()
+
+	b := make([]byte, 0, idTimeLen+idSeqLen+idRandLen)
+	b = appendBase36(b, now, idTimeLen)
+	b = appendBase36(b, seq, idSeqLen)
+	return string(append(b, randomChars(idRandLen)...))
+}
+
+// ObjectIdTime returns the creation time embedded in an ID returned by
+// GenerateObjectId, to the millisecond. The boolean is false if id is not
+// such an ID.
+func ObjectIdTime(id string) (time.Time, bool) {
+	if len(id) != idTimeLen+idSeqLen+idRandLen {
+		return time.Time{}, false
+	}
+	var ms int64
+	for i := 0; i < len(id); i++ {
+		digit := strings.IndexByte(idCharSet, id[i])
+		if digit < 0 {
+			return time.Time{}, false
+		}
+		if i < idTimeLen {
+			ms = ms*36 + int64(digit)
+		}
+	}
+	return time.UnixMilli(ms), true
+}
+
+func appendBase36(b []byte, n int64, width int) []byte {
+	digits := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		digits[i] = idCharSet[n%36]
+		n /= 36
+	}
+	return append(b, digits...)
+}
+
+func randomChars(n int) []byte {
+	result := make([]byte, 0, n)
+	buf := make([]byte, n*2)
+	for len(result) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic("util: unable to read random bytes: " + err.Error())
+		}
+		for _, c := range buf {
+			// Reject bytes that would bias the modulo.
+			if c < idRandBase && len(result) < n {
+				result = append(result, idCharSet[c%36])
+			}
+		}
+	}
+	return result
+}
+
+// GetField returns the value at a dotted path (e.g. "Address.City") by
+// walking nested maps. The boolean is false if any segment is missing.
+func GetField(m map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		next, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = next[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SetField sets the value at a dotted path (e.g. "Address.City"), creating
+// missing intermediate maps. It fails if an intermediate segment holds a
+// value that is not a map.
+func SetField(m map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	current := m
+	for i, key := range keys[:len(keys)-1] {
+		next, ok := current[key]
+		if !ok || next == nil {
+			child := map[string]interface{}{}
+			current[key] = child
+			current = child
+			continue
+		}
+		if current, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("field %s is not an object", strings.Join(keys[:i+1], "."))
+		}
+	}
+	current[keys[len(keys)-1]] = value
+	return nil
+}
+
+// Normalize converts v into the generic form produced by decoding JSON into
+// an interface{}, so it can be compared with values read from records.
+func Normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Canonicalize converts v into the generic form produced by decoding JSON,
+// like Normalize, but keeps numbers exact: each one is a json.Number in a
+// single canonical decimal form, so 1, 1.0 and 1e0 encode the same while
+// two different integers never do, whatever their size.
+func Canonicalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return canonicalNumbers(result), nil
+}
+
+// canonicalNumbers rewrites the json.Numbers of a decoded value, and of
+// the maps and slices nested in it, in their canonical form.
+func canonicalNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		return canonicalNumber(value)
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = canonicalNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = canonicalNumbers(item)
+		}
+	}
+	return v
+}
+
+// canonicalNumber writes a number as an integer, or as a decimal without
+// trailing zeros. A decimal literal is a fraction whose denominator only
+// has the factors 2 and 5, so it has an exact decimal form.
+func canonicalNumber(n json.Number) json.Number {
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return n
+	}
+	if r.IsInt() {
+		return json.Number(r.Num().String())
+	}
+
+	denom := new(big.Int).Set(r.Denom())
+	twos := int(denom.TrailingZeroBits())
+	denom.Rsh(denom, uint(twos))
+	fives := 0
+	five, rem := big.NewInt(5), new(big.Int)
+	for denom.Cmp(big.NewInt(1)) > 0 {
+		quo, _ := new(big.Int).QuoRem(denom, five, rem)
+		if rem.Sign() != 0 {
+			return n
+		}
+		denom = quo
+		fives++
+	}
+
+	digits := twos
+	if fives > digits {
+		digits = fives
 	}
-	return string(b)
+	return json.Number(r.FloatString(digits))
 }
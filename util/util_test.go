@@ -0,0 +1,195 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{1, 1, true},
+		{int64(-3), -3, true},
+		{2.5, 2.5, true},
+		{json.Number("42"), 42, true},
+		{json.Number("1e3"), 1000, true},
+		{json.Number("abc"), 0, false},
+		{"42", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ToFloat(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ToFloat(%#v) = %v, %t; want %v, %t", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  bool
+	}{
+		{"true", true, true},
+		{"false", false, true},
+		{"zero int", 0, false},
+		{"non-zero int", 42, true},
+		{"negative int", -1, true},
+		{"zero float", 0.0, false},
+		{"non-zero float", 2.5, true},
+		{"zero json.Number", json.Number("0"), false},
+		{"non-zero json.Number", json.Number("410013"), true},
+		{"empty string", "", false},
+		{"non-empty string", "bangalore", true},
+		{"nil", nil, true},
+		{"slice", []interface{}{1}, false},
+		{"map", map[string]interface{}{"a": 1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValid(tt.value); got != tt.want {
+			t.Errorf("IsValid(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateMapAppliesBooleans(t *testing.T) {
+	existing := map[string]interface{}{"active": false, "admin": true}
+
+	UpdateMap(map[string]interface{}{"active": true, "admin": false}, existing)
+
+	if existing["active"] != true || existing["admin"] != false {
+		t.Errorf("booleans not applied: %v", existing)
+	}
+}
+
+func TestGenerateObjectIdUnique(t *testing.T) {
+	const goroutines, perGoroutine = 100, 1000
+
+	ids := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for g := range ids {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			ids[g] = make([]string, perGoroutine)
+			for i := range ids[g] {
+				ids[g][i] = GenerateObjectId()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, goroutines*perGoroutine)
+	for _, batch := range ids {
+		// IDs of a goroutine sort by creation order.
+		if !sort.StringsAreSorted(batch) {
+			t.Error("IDs of a goroutine are not sorted")
+		}
+		for _, id := range batch {
+			if len(id) != 26 {
+				t.Fatalf("ID %q has %d characters, want 26", id, len(id))
+			}
+			if seen[id] {
+				t.Fatalf("duplicate ID %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+// osStater describes files on disk.
+type osStater struct{}
+
+func (osStater) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func TestStat(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "a.json")
+	if err := os.WriteFile(record, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := Stat(osStater{}, filepath.Join(dir, "a"), ".json"); err != nil || fi.Name() != "a.json" {
+		t.Errorf("Stat with the extension fallback = %v, %v", fi, err)
+	}
+
+	if _, err := Stat(osStater{}, filepath.Join(dir, "missing"), ".json"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file: got %v, want a not-exist error", err)
+	}
+
+	// A path through a file fails for another reason than a missing file.
+	if _, err := Stat(osStater{}, filepath.Join(record, "b"), ".json"); err == nil || os.IsNotExist(err) {
+		t.Errorf("Stat through a file: got %v, want an error other than not-exist", err)
+	}
+}
+
+func TestToMapKeepsNumbers(t *testing.T) {
+	m, err := ToMap(struct {
+		ID    int64
+		Price json.Number
+	}{ID: 1<<53 + 1, Price: "0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m["ID"] != json.Number("9007199254740993") {
+		t.Errorf("ID = %#v, want json.Number 9007199254740993", m["ID"])
+	}
+	if m["Price"] != json.Number("0.1") {
+		t.Errorf("Price = %#v, want json.Number 0.1", m["Price"])
+	}
+}
+
+func TestToMapRejectsNonObjects(t *testing.T) {
+	for _, v := range []interface{}{[]int{1}, "text", 42, nil} {
+		if m, err := ToMap(v); err == nil {
+			t.Errorf("ToMap(%#v) = %v, want an error", v, m)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{json.Number("1"), "1"},
+		{json.Number("1.0"), "1"},
+		{json.Number("1e0"), "1"},
+		{1.5, "1.5"},
+		{json.Number("1.50"), "1.5"},
+		{json.Number("0.1"), "0.1"},
+		{json.Number("-2.5e-3"), "-0.0025"},
+		{json.Number("1e21"), "1000000000000000000000"},
+		{int64(1<<53 + 1), "9007199254740993"},
+		{map[string]interface{}{"n": json.Number("2.0")}, `{"n":2}`},
+		{[]interface{}{json.Number("3e1"), "x"}, `[30,"x"]`},
+	}
+
+	for _, tt := range tests {
+		v, err := Canonicalize(tt.in)
+		if err != nil {
+			t.Fatalf("Canonicalize(%#v): %s", tt.in, err)
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Canonicalize(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}